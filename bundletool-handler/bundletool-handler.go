@@ -0,0 +1,142 @@
+package bundletoolHandler
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BundletoolHandler converts Android App Bundles into installable APKs
+type BundletoolHandler struct {
+	bundletool   string
+	keystore     string
+	keystorePass string
+	keyAlias     string
+	keyPass      string
+}
+
+// NewBundletoolHandler constructs a handler which runs bundletool. The path
+// may point to the bundletool jar (run with "java -jar") or to an executable
+func NewBundletoolHandler(bundletool string) *BundletoolHandler {
+	return &BundletoolHandler{
+		bundletool: bundletool,
+	}
+}
+
+// SetKeystore configures the key the universal APKs get signed with.
+// Passwords use the bundletool syntax ("pass:<password>" or "file:<path>").
+// Without a keystore bundletool signs with the debug key
+func (bh *BundletoolHandler) SetKeystore(keystore string, keystorePass string, keyAlias string, keyPass string) error {
+	if _, err := os.Stat(keystore); err != nil {
+		return fmt.Errorf("Cannot use keystore (%v)", err)
+	}
+	bh.keystore = keystore
+	bh.keystorePass = keystorePass
+	bh.keyAlias = keyAlias
+	bh.keyPass = keyPass
+	return nil
+}
+
+func (bh *BundletoolHandler) command(args ...string) *exec.Cmd {
+	if strings.HasSuffix(bh.bundletool, ".jar") {
+		return exec.Command("java", append([]string{"-jar", bh.bundletool}, args...)...)
+	}
+	return exec.Command(bh.bundletool, args...)
+}
+
+// BuildUniversalAPK creates a universal APK next to the bundle at aabPath
+// and returns its path
+func (bh *BundletoolHandler) BuildUniversalAPK(aabPath string) (string, error) {
+	apkPath := strings.TrimSuffix(aabPath, ".aab") + ".apk"
+	if _, err := os.Stat(apkPath); err == nil {
+		return "", fmt.Errorf("Destination %s does already exist", apkPath)
+	}
+
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "bundletool-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+	apksPath := filepath.Join(tmpDir, "universal.apks")
+
+	args := []string{
+		"build-apks",
+		"--bundle=" + aabPath,
+		"--output=" + apksPath,
+		"--mode=universal",
+	}
+	if bh.keystore != "" {
+		args = append(args, "--ks="+bh.keystore)
+		if bh.keystorePass != "" {
+			args = append(args, "--ks-pass="+bh.keystorePass)
+		}
+		if bh.keyAlias != "" {
+			args = append(args, "--ks-key-alias="+bh.keyAlias)
+		}
+		if bh.keyPass != "" {
+			args = append(args, "--key-pass="+bh.keyPass)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"bundle":   aabPath,
+		"keystore": bh.keystore,
+	}).Info("Build universal APK")
+	cmd := bh.command(args...)
+	cmd.Stdout = log.WithFields(log.Fields{
+		"cmd": "bundletool",
+	}).Writer()
+	cmd.Stderr = log.WithFields(log.Fields{
+		"cmd": "bundletool",
+	}).WriterLevel(log.WarnLevel)
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("bundletool failed for %s (%v)", aabPath, err)
+	}
+
+	if err := extractUniversalAPK(apksPath, apkPath); err != nil {
+		return "", err
+	}
+	log.WithFields(log.Fields{
+		"bundle": aabPath,
+		"apk":    apkPath,
+	}).Info("Universal APK built")
+	return apkPath, nil
+}
+
+// extractUniversalAPK copies universal.apk out of the APK set bundletool wrote
+func extractUniversalAPK(apksPath string, apkPath string) error {
+	apks, err := zip.OpenReader(apksPath)
+	if err != nil {
+		return fmt.Errorf("Cannot open APK set (%v)", err)
+	}
+	defer apks.Close()
+
+	for _, file := range apks.File {
+		if file.Name != "universal.apk" {
+			continue
+		}
+		src, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		dest, err := os.OpenFile(apkPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dest, src); err != nil {
+			dest.Close()
+			os.Remove(apkPath)
+			return fmt.Errorf("Cannot write %s (%v)", apkPath, err)
+		}
+		return dest.Close()
+	}
+	return fmt.Errorf("APK set does not contain universal.apk")
+}
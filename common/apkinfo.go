@@ -0,0 +1,97 @@
+package common
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/avast/apkparser"
+)
+
+// APKInfo holds metadata read from an APK
+type APKInfo struct {
+	PackageName string   `json:"packageName"`
+	VersionName string   `json:"versionName"`
+	VersionCode int64    `json:"versionCode"`
+	ABIs        []string `json:"abis,omitempty"`
+}
+
+// ABI describes the native code of the APK. It returns "noarch" for APKs
+// without native libraries and "universal" for APKs with multiple ABIs
+func (info *APKInfo) ABI() string {
+	switch len(info.ABIs) {
+	case 0:
+		return "noarch"
+	case 1:
+		return info.ABIs[0]
+	default:
+		return "universal"
+	}
+}
+
+// manifestInfoEncoder picks the attributes of the manifest element while
+// apkparser decodes the binary AndroidManifest.xml
+type manifestInfoEncoder struct {
+	info *APKInfo
+}
+
+func (e *manifestInfoEncoder) EncodeToken(t xml.Token) error {
+	st, ok := t.(xml.StartElement)
+	if !ok || st.Name.Local != "manifest" {
+		return nil
+	}
+	for _, attr := range st.Attr {
+		switch attr.Name.Local {
+		case "package":
+			e.info.PackageName = attr.Value
+		case "versionName":
+			e.info.VersionName = attr.Value
+		case "versionCode":
+			versionCode, err := strconv.ParseInt(attr.Value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("Cannot parse versionCode '%s' (%v)", attr.Value, err)
+			}
+			e.info.VersionCode = versionCode
+		}
+	}
+	return apkparser.ErrEndParsing
+}
+
+func (e *manifestInfoEncoder) Flush() error {
+	return nil
+}
+
+// ReadAPKInfo parses the manifest of the APK at path and detects the ABIs
+// of the included native libraries
+func ReadAPKInfo(path string) (*APKInfo, error) {
+	zip, err := apkparser.OpenZip(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zip.Close()
+
+	info := &APKInfo{}
+	_, err = apkparser.ParseApkWithZip(zip, &manifestInfoEncoder{info: info})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse manifest (%v)", err)
+	}
+	if info.PackageName == "" {
+		return nil, fmt.Errorf("Manifest does not declare a package")
+	}
+
+	abis := make(map[string]bool)
+	for name := range zip.File {
+		// native libraries are stored as lib/<abi>/<library>.so
+		parts := strings.Split(name, "/")
+		if len(parts) == 3 && parts[0] == "lib" && parts[1] != "" {
+			abis[parts[1]] = true
+		}
+	}
+	for abi := range abis {
+		info.ABIs = append(info.ABIs, abi)
+	}
+	sort.Strings(info.ABIs)
+	return info, nil
+}
@@ -0,0 +1,72 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits maps the supported suffixes to their multiplier. Decimal units
+// (KB, MB, ...) use powers of 1000, binary units (KiB, MiB, ...) powers of
+// 1024. Longer suffixes have to be listed first
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"TB", 1000 * 1000 * 1000 * 1000},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"T", 1 << 40},
+	{"B", 1},
+}
+
+// ParseSize parses sizes like "512", "200MiB" or "1.5GB" into bytes
+func ParseSize(size string) (int64, error) {
+	value := strings.TrimSpace(size)
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(value), strings.ToUpper(unit.suffix)) {
+			value = strings.TrimSpace(value[:len(value)-len(unit.suffix)])
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("Cannot parse size '%s'", size)
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// FormatSize formats bytes with the largest binary unit, e.g. "1.5 MiB"
+func FormatSize(bytes int64) string {
+	value := float64(bytes)
+	if value < 0 {
+		value = -value
+	}
+	for _, unit := range []string{"TiB", "GiB", "MiB", "KiB"} {
+		multiplier := float64(unitMultiplier(unit))
+		if value >= multiplier {
+			return fmt.Sprintf("%.1f %s", float64(bytes)/multiplier, unit)
+		}
+	}
+	return fmt.Sprintf("%d B", bytes)
+}
+
+// unitMultiplier returns the multiplier of suffix
+func unitMultiplier(suffix string) int64 {
+	for _, unit := range sizeUnits {
+		if unit.suffix == suffix {
+			return unit.multiplier
+		}
+	}
+	return 1
+}
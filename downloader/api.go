@@ -1,53 +1,200 @@
 package buildkiteArtifactDownloader
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
 
-	"github.com/avast/apkverifier"
+	common "github.com/krombel/buildkite-artifact-downloader/common"
 	log "github.com/sirupsen/logrus"
 )
 
 type BuildkiteBuildJobInfo struct {
-	ID    string `json:"id"`
-	Name  string `json:"name"`
-	State string `json:"state"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	StepKey string `json:"step_key"`
+	State   string `json:"state"`
+	// RetriedInJobID is the job which retried this one
+	RetriedInJobID string `json:"retried_in_job_id"`
 }
 type BuildkiteBuildInfo struct {
 	State    string `json:"state"`
 	CommitID string `json:"commit_id"`
+	Branch   string `json:"branch"`
+	Number   int    `json:"number"`
+	// Message and Author describe the commit of the build
+	Message string               `json:"message,omitempty"`
+	Author  BuildkiteBuildAuthor `json:"author"`
+	// MetaData holds the key/values set with buildkite-agent meta-data
+	MetaData map[string]string `json:"meta_data,omitempty"`
 	Jobs     []BuildkiteBuildJobInfo
+	// WebURL links the build in the web UI of the provider
+	WebURL string `json:"web_url,omitempty"`
 }
 
 type BuildkiteBuildArtifactInfo struct {
 	State    string `json:"state"`
 	Filename string `json:"file_name"`
-	URL      string `json:"url"`
-	SHA1sum  string `json:"sha1sum"`
+	// Path is the path relative to the build directory the artifact was
+	// uploaded from (e.g. outputs/apk/release/app.apk)
+	Path      string `json:"path"`
+	URL       string `json:"url"`
+	MimeType  string `json:"mime_type"`
+	FileSize  int64  `json:"file_size"`
+	SHA1sum   string `json:"sha1sum"`
+	SHA256sum string `json:"sha256sum"`
+
+	// signature is the detached signature uploaded next to the artifact
+	signature *BuildkiteBuildArtifactInfo
+	// job is the job which uploaded the artifact
+	job BuildkiteBuildJobInfo
+	// archiveKey is set for zip archives of providers which have to be
+	// unpacked before their files are downloaded
+	archiveKey string
+}
+
+// buildkiteRESTArtifactInfo is the artifact representation of the
+// authenticated REST API (api.buildkite.com)
+type buildkiteRESTArtifactInfo struct {
+	State       string `json:"state"`
+	Filename    string `json:"filename"`
+	Path        string `json:"path"`
+	DownloadURL string `json:"download_url"`
+	MimeType    string `json:"mime_type"`
+	FileSize    int64  `json:"file_size"`
+	SHA1sum     string `json:"sha1sum"`
+	SHA256sum   string `json:"sha256sum"`
 }
 
+const (
+	// artifactsPerPage is the page size requested from the REST API
+	artifactsPerPage = 100
+	// maxArtifactPages bounds the pages fetched per job
+	maxArtifactPages = 100
+)
+
+const (
+	buildkiteWebURL = "https://buildkite.com"
+	buildkiteAPIURL = "https://api.buildkite.com/v2"
+)
+
+// downloadURL returns the absolute URL of an artifact. The web endpoints
+// only return a path while the REST API and other providers return full URLs
+func (artifact BuildkiteBuildArtifactInfo) downloadURL() string {
+	if strings.HasPrefix(artifact.URL, "https://") || strings.HasPrefix(artifact.URL, "http://") ||
+		strings.HasPrefix(artifact.URL, localFileScheme+"://") {
+		return artifact.URL
+	}
+	return buildkiteWebURL + artifact.URL
+}
+
+// fullPath returns the path the artifact was uploaded from. Older responses
+// do not contain it, the filename is used then
+func (artifact BuildkiteBuildArtifactInfo) fullPath() string {
+	if artifact.Path != "" {
+		return artifact.Path
+	}
+	return artifact.Filename
+}
+
+// getLatestBuildID resolves the latest passed build of the branch. The REST
+// API is used if a token is configured, otherwise (or if that fails) the
+// redirect of the web UI is followed. Commit filters require the API as
+// the redirect only leads to the newest build
 func (bd *BuildkiteHandler) getLatestBuildID() (int, error) {
+	if bd.apiToken != "" {
+		buildID, err := bd.getLatestBuildIDFromAPI()
+		if err == nil || bd.hasCommitFilters() {
+			return buildID, err
+		}
+		log.WithFields(log.Fields{
+			"branch": bd.getBranch(),
+			"error":  err,
+		}).Warn("Cannot resolve latest build via API. Fall back to redirect")
+	}
+	if bd.hasCommitFilters() {
+		return 0, fmt.Errorf("Filtering builds by commit requires an API token")
+	}
+	return bd.getLatestBuildIDFromRedirect()
+}
+
+// getLatestBuildIDFromAPI queries the builds listing of the REST API
+func (bd *BuildkiteHandler) getLatestBuildIDFromAPI() (int, error) {
+	query := url.Values{}
+	query.Set("branch", bd.getBranch())
+	query.Set("state", "passed")
+	query.Set("per_page", strconv.Itoa(bd.candidateBuilds()))
+	bd.metaDataQuery(query)
+	bodyBytes, err := bd.getData(buildkiteAPIURL + "/organizations/" + bd.buildkiteOrg + "/pipelines/" + bd.buildkitePipeline + "/builds?" + query.Encode())
+	if err != nil {
+		return 0, err
+	}
+	builds := []BuildkiteBuildInfo{}
+	if err := json.Unmarshal(bodyBytes, &builds); err != nil {
+		return 0, fmt.Errorf("Cannot parse builds (%v)", err)
+	}
+	for _, build := range builds {
+		if build.Number != 0 && bd.matchesCommit(build.Message, build.Author.Name, build.Author.Email, build.Author.Username) {
+			return build.Number, nil
+		}
+	}
+	return 0, fmt.Errorf("No matching passed build on branch %s", bd.getBranch())
+}
+
+// buildkitePipelineInfo is the part of the pipeline of the REST API which
+// is used here
+type buildkitePipelineInfo struct {
+	DefaultBranch string `json:"default_branch"`
+}
+
+// getDefaultBranch queries the default branch configured for the pipeline
+func (bd *BuildkiteHandler) getDefaultBranch() (string, error) {
+	bodyBytes, err := bd.getData(buildkiteAPIURL + "/organizations/" + bd.buildkiteOrg + "/pipelines/" + bd.buildkitePipeline)
+	if err != nil {
+		return "", err
+	}
+	pipeline := buildkitePipelineInfo{}
+	if err := json.Unmarshal(bodyBytes, &pipeline); err != nil {
+		return "", fmt.Errorf("Cannot parse pipeline (%v)", err)
+	}
+	if pipeline.DefaultBranch == "" {
+		return "", fmt.Errorf("Pipeline has no default branch")
+	}
+	return pipeline.DefaultBranch, nil
+}
+
+// reBuildPath matches the build number of a build URL
+var reBuildPath = regexp.MustCompile(`/builds/([0-9]+)/?$`)
+
+// getLatestBuildIDFromRedirect follows the redirect of the "latest build"
+// link of the web UI and takes the build number from the final URL
+func (bd *BuildkiteHandler) getLatestBuildIDFromRedirect() (int, error) {
+	query := url.Values{}
+	query.Set("branch", bd.getBranch())
+	query.Set("state", "passed")
 	resp, err := bd.netClient.Head(
-		"https://buildkite.com/" + bd.buildkiteOrg + "/" + bd.buildkitePipeline + "/builds/latest?branch=develop&state=passed",
+		buildkiteWebURL + "/" + bd.buildkiteOrg + "/" + bd.buildkitePipeline + "/builds/latest?" + query.Encode(),
 	)
 	if err != nil {
 		return 0, fmt.Errorf("Could not fetch buildID (%v)", err)
 	}
-	rp := regexp.MustCompile("[0-9]+$")
-	match := rp.FindString(resp.Request.URL.String())
-	if match == "" {
+	resp.Body.Close()
+
+	match := reBuildPath.FindStringSubmatch(resp.Request.URL.Path)
+	if match == nil {
 		return 0, fmt.Errorf("URL does not end with and buildID")
 	}
 
-	i, err := strconv.Atoi(match)
+	i, err := strconv.Atoi(match[1])
 	if err != nil {
 		return 0, fmt.Errorf("Could not parse buildID (%v)", err)
 	}
@@ -70,52 +217,326 @@ func (bd *BuildkiteHandler) getBuildInfo() (*BuildkiteBuildInfo, error) {
 	}).Debug("Download succeeded")
 	parsedBuildResponse := BuildkiteBuildInfo{}
 	json.Unmarshal(bodyBytes, &parsedBuildResponse)
+	if parsedBuildResponse.MetaData == nil && bd.needsMetaData() {
+		parsedBuildResponse.MetaData, err = bd.getMetaData()
+		if err != nil {
+			return nil, err
+		}
+	}
 	return &parsedBuildResponse, nil
 }
 
 func (bd *BuildkiteHandler) getArtifactInfo(jobID string) ([]BuildkiteBuildArtifactInfo, error) {
 	url := "https://buildkite.com/organizations/" + bd.buildkiteOrg + "/pipelines/" + bd.buildkitePipeline + "/builds/" + strconv.Itoa(bd.buildID) + "/jobs/" + jobID + "/artifacts"
-	log.WithFields(log.Fields{
-		"buildID": bd.buildID,
-		"jobID":   jobID,
-		"url":     url,
-	}).Info("Start artifactInfo download")
-	bodyBytes, err := bd.getData(url)
+	if bd.apiToken != "" {
+		url = buildkiteAPIURL + "/organizations/" + bd.buildkiteOrg + "/pipelines/" + bd.buildkitePipeline + "/builds/" + strconv.Itoa(bd.buildID) + "/jobs/" + jobID + "/artifacts?per_page=" + strconv.Itoa(artifactsPerPage)
+	}
+
+	var parsedResponse []BuildkiteBuildArtifactInfo
+	for page := 1; url != "" && page <= maxArtifactPages; page++ {
+		log.WithFields(log.Fields{
+			"buildID": bd.buildID,
+			"jobID":   jobID,
+			"url":     url,
+			"page":    page,
+		}).Info("Start artifactInfo download")
+		bodyBytes, next, err := bd.getPage(url)
+		if err != nil {
+			return nil, err
+		}
+		log.WithFields(log.Fields{
+			"buildID": bd.buildID,
+			"jobID":   jobID,
+			"url":     url,
+			"page":    page,
+		}).Info("Download succeeded")
+
+		if bd.apiToken != "" {
+			restResponse := []buildkiteRESTArtifactInfo{}
+			json.Unmarshal(bodyBytes, &restResponse)
+			for _, artifact := range restResponse {
+				parsedResponse = append(parsedResponse, BuildkiteBuildArtifactInfo{
+					State:     artifact.State,
+					Filename:  artifact.Filename,
+					Path:      artifact.Path,
+					URL:       artifact.DownloadURL,
+					MimeType:  artifact.MimeType,
+					FileSize:  artifact.FileSize,
+					SHA1sum:   artifact.SHA1sum,
+					SHA256sum: artifact.SHA256sum,
+				})
+			}
+		} else {
+			pageResponse := []BuildkiteBuildArtifactInfo{}
+			json.Unmarshal(bodyBytes, &pageResponse)
+			parsedResponse = append(parsedResponse, pageResponse...)
+		}
+		url = next
+	}
+	if url != "" {
+		log.WithFields(log.Fields{
+			"buildID": bd.buildID,
+			"jobID":   jobID,
+			"pages":   maxArtifactPages,
+		}).Warn("Artifact list has more pages than supported. Ignore the remaining ones")
+	}
+	if parsedResponse == nil {
+		parsedResponse = []BuildkiteBuildArtifactInfo{}
+	}
+	return parsedResponse, nil
+}
+
+// getJobLog downloads the raw log of a job. The web UI serves it for public
+// pipelines, the REST API requires the read_build_logs scope
+func (bd *BuildkiteHandler) getJobLog(jobID string) ([]byte, error) {
+	url := buildkiteWebURL + "/organizations/" + bd.buildkiteOrg + "/pipelines/" + bd.buildkitePipeline + "/builds/" + strconv.Itoa(bd.buildID) + "/jobs/" + jobID + "/download.txt"
+	if bd.apiToken != "" {
+		url = buildkiteAPIURL + "/organizations/" + bd.buildkiteOrg + "/pipelines/" + bd.buildkitePipeline + "/builds/" + strconv.Itoa(bd.buildID) + "/jobs/" + jobID + "/log.txt"
+	}
+	return bd.getLogData(url)
+}
+
+// reNextLink matches the next page of a Link header
+// (<https://...?page=2>; rel="next")
+var reNextLink = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="next"`)
+
+// nextPage returns the URL of the next page announced in the Link header
+func nextPage(header http.Header) string {
+	for _, link := range header["Link"] {
+		if match := reNextLink.FindStringSubmatch(link); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// newRequest creates a request and authenticates it if it targets the API of
+// the provider
+func (bd *BuildkiteHandler) newRequest(method string, url string) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, nil)
 	if err != nil {
 		return nil, err
 	}
-	log.WithFields(log.Fields{
-		"buildID": bd.buildID,
-		"jobID":   jobID,
-		"url":     url,
-	}).Info("Download succeeded")
-	parsedResponse := []BuildkiteBuildArtifactInfo{}
-	json.Unmarshal(bodyBytes, &parsedResponse)
-	return parsedResponse, nil
+	bd.provider.authorize(req)
+	return req, nil
+}
+
+// cachedResponse holds the validators and body of a previous response so
+// repeated polls can be answered with "304 Not Modified"
+type cachedResponse struct {
+	etag         string
+	lastModified string
+	body         []byte
+	next         string
+	// buildID is the build which was processed when the response got
+	// cached (0 while the build gets resolved)
+	buildID int
+}
+
+// maxCachedResponses caps the count of cached responses
+const maxCachedResponses = 256
+
+// evictCachedResponses drops the responses cached for other builds than the
+// current one so a long running -watch process only keeps the build lists
+// and the responses of the build it polls
+func (bd *BuildkiteHandler) evictCachedResponses() {
+	for url, cached := range bd.responseCache {
+		if cached.buildID != 0 && cached.buildID != bd.buildID {
+			delete(bd.responseCache, url)
+		}
+	}
 }
 
 func (bd *BuildkiteHandler) getData(url string) (bodyBytes []byte, err error) {
-	buildResponse, err := bd.netClient.Get(url)
+	bodyBytes, _, err = bd.getPage(url)
+	return bodyBytes, err
+}
+
+// getLogData fetches url without the response cache. Logs are fetched once
+// and would only grow the cache
+func (bd *BuildkiteHandler) getLogData(url string) ([]byte, error) {
+	bodyBytes, _, err := bd.fetchPage(url, false)
+	return bodyBytes, err
+}
+
+// getPage fetches url and returns the body and the URL of the next page (if
+// the response is paginated)
+func (bd *BuildkiteHandler) getPage(url string) (bodyBytes []byte, next string, err error) {
+	return bd.fetchPage(url, true)
+}
+
+// fetchPage implements getPage. With useCache the request is conditional on
+// a cached response and the response gets cached if it has validators
+func (bd *BuildkiteHandler) fetchPage(url string, useCache bool) (bodyBytes []byte, next string, err error) {
+	req, err := bd.newRequest(http.MethodGet, url)
 	if err != nil {
-		log.Fatal("GET failed", err)
-		return nil, err
+		return nil, "", err
+	}
+	cached, isCached := bd.responseCache[url]
+	isCached = isCached && useCache
+	if isCached {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	buildResponse, err := bd.netClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("GET failed (%v)", err)
 	}
 	defer buildResponse.Body.Close()
 
+	if isCached && buildResponse.StatusCode == http.StatusNotModified {
+		log.WithFields(log.Fields{
+			"url": url,
+		}).Debug("Not modified. Use cached response")
+		return cached.body, cached.next, nil
+	}
+
 	if buildResponse.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Could not get data")
+		// drain the body so the connection can be reused
+		io.Copy(ioutil.Discard, buildResponse.Body)
+		return nil, "", fmt.Errorf("Could not get data")
 	}
 
 	bodyBytes, err = ioutil.ReadAll(buildResponse.Body)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	next = nextPage(buildResponse.Header)
+
+	etag := buildResponse.Header.Get("ETag")
+	lastModified := buildResponse.Header.Get("Last-Modified")
+	if useCache && (etag != "" || lastModified != "") && (isCached || len(bd.responseCache) < maxCachedResponses) {
+		bd.responseCache[url] = cachedResponse{
+			etag:         etag,
+			lastModified: lastModified,
+			body:         bodyBytes,
+			next:         next,
+			buildID:      bd.buildID,
+		}
+	}
+	return bodyBytes, next, nil
+}
+
+// retryableError marks failures of a download which might succeed on
+// another attempt (e.g. timeouts or truncated transfers)
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+// byteCounter counts the bytes written to it
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// fetchArtifact writes the artifact to file and validates that it got
+// transferred completely. The checksums of the transferred data get
+// calculated on the fly so the file does not have to be read again for
+// verification. If decompressed is set the gzip compressed artifact gets
+// decompressed into file and decompressed receives the checksums of the
+// stored data. It returns the count of transferred bytes
+func (bd *BuildkiteHandler) fetchArtifact(artifact BuildkiteBuildArtifactInfo, file *os.File, digests *artifactDigests, decompressed *artifactDigests) (int64, error) {
+	req, err := bd.newRequest(http.MethodGet, artifact.downloadURL())
+	if err != nil {
+		return 0, err
+	}
+	// the checksums refer to the stored artifact so the transport must not
+	// negotiate a transparent compression
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := bd.netClient.Do(req)
+	if err != nil {
+		return 0, retryableError{fmt.Errorf("Cannot download %s ('%s')", artifact.Filename, err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// never write error documents into the destination
+		return 0, responseError(resp, artifact)
+	}
+
+	// Write the body to file
+	var written int64
+	if decompressed != nil {
+		counter := &byteCounter{}
+		transferred := io.TeeReader(resp.Body, io.MultiWriter(digests.writer(), counter))
+		var gzipReader *gzip.Reader
+		gzipReader, err = gzip.NewReader(transferred)
+		if err == nil {
+			_, err = io.Copy(io.MultiWriter(file, decompressed.writer()), gzipReader)
+		}
+		if err == nil {
+			// consume what the gzip reader left over so that the
+			// checksums cover the whole artifact
+			_, err = io.Copy(ioutil.Discard, transferred)
+		}
+		written = counter.n
+	} else {
+		written, err = io.Copy(io.MultiWriter(file, digests.writer()), resp.Body)
+	}
+	if err != nil {
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			return written, retryableError{fmt.Errorf("Download of %s interrupted. Timeout occured ('%s')", artifact.Filename, e)}
+		}
+		if _, ok := err.(*os.PathError); ok {
+			return written, fmt.Errorf("Cannot write to temp file %s ('%s')", file.Name(), err)
+		}
+		return written, retryableError{fmt.Errorf("Download of %s interrupted ('%s')", artifact.Filename, err)}
 	}
-	return bodyBytes, nil
+
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return written, retryableError{fmt.Errorf("Short read of %s (got %d of %d bytes)", artifact.Filename, written, resp.ContentLength)}
+	}
+	if artifact.FileSize > 0 && written != artifact.FileSize {
+		return written, retryableError{fmt.Errorf("Size of %s does not match (got %d bytes, API reports %d bytes)", artifact.Filename, written, artifact.FileSize)}
+	}
+	return written, nil
 }
 
-func (bd *BuildkiteHandler) downloadArtifact(artifact BuildkiteBuildArtifactInfo, destPath string) error {
-	if _, err := os.Stat(destPath); err == nil {
-		return fmt.Errorf("Destination does already exist - do not download")
+// downloadToTemp downloads an auxiliary artifact (e.g. a signature) into a
+// temporary file. The caller has to remove the file afterwards
+func (bd *BuildkiteHandler) downloadToTemp(artifact BuildkiteBuildArtifactInfo) (string, error) {
+	tmpFile, err := ioutil.TempFile(os.TempDir(), "buildkite-artifact-")
+	if err != nil {
+		return "", err
+	}
+	defer tmpFile.Close()
+
+	digests := newArtifactDigests()
+	if _, err := bd.fetchArtifact(artifact, tmpFile, digests, nil); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+	if _, _, err := verifyDigest(artifact, digests); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+	return tmpFile.Name(), nil
+}
+
+func (bd *BuildkiteHandler) downloadArtifact(buildInfo BuildkiteBuildInfo, artifact BuildkiteBuildArtifactInfo) (*DownloadResult, error) {
+	// the destination can only be checked upfront if it does not depend on
+	// the content of the APK
+	needsAPKInfo := bd.destinationNeedsAPKInfo()
+	destPath, err := bd.getDestinationPath(buildInfo, artifact, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !needsAPKInfo {
+		if err := bd.checkExistingDestination(artifact, destPath); err != nil {
+			return nil, err
+		}
 	}
 
 	tmpFile, err := ioutil.TempFile(os.TempDir(), "buildkite-artifact-")
@@ -136,32 +557,58 @@ func (bd *BuildkiteHandler) downloadArtifact(artifact BuildkiteBuildArtifactInfo
 		"destination":      destPath,
 	}).Info("Start artifact download")
 
-	// Get the data
-	resp, err := bd.netClient.Get("https://buildkite.com" + artifact.URL)
-	if err != nil {
-		return fmt.Errorf("Cannot download to %s ('%s')", destPath, err)
+	digests := newArtifactDigests()
+	// stored receives the checksums of the file as it gets stored which only
+	// differ from the transferred ones if the artifact gets decompressed
+	stored := digests
+	var decompressed *artifactDigests
+	if bd.decompresses(artifact) {
+		decompressed = newArtifactDigests()
+		stored = decompressed
 	}
-	defer resp.Body.Close()
-
-	// Write the body to file
-	_, err = io.Copy(tmpFile, resp.Body)
-	if err != nil {
-		if e, ok := err.(net.Error); ok && e.Timeout() {
+	var written int64
+	for attempt := 1; ; attempt++ {
+		written, err = bd.fetchArtifact(artifact, tmpFile, digests, decompressed)
+		if err == nil {
 			log.WithFields(log.Fields{
 				"buildID":          bd.buildID,
 				"artifactFilename": artifact.Filename,
-				"destination":      destPath,
-				"error":            e,
-			}).Warn("Download interrupted. Timeout occured")
-			// This was a timeout
-		} else {
+				"bytes":            written,
+			}).Debug("Artifact fetched")
+			break
+		}
+		if _, retryable := err.(retryableError); !retryable || attempt >= bd.downloadAttempts {
 			log.WithFields(log.Fields{
 				"buildID":          bd.buildID,
 				"artifactFilename": artifact.Filename,
 				"destination":      destPath,
+				"attempt":          attempt,
 				"error":            err,
 			}).Warn("Download interrupted. Download not stored")
-			return fmt.Errorf("Cannot write to temp file %s ('%s')", tmpFile.Name(), err)
+			return nil, err
+		}
+		log.WithFields(log.Fields{
+			"buildID":          bd.buildID,
+			"artifactFilename": artifact.Filename,
+			"attempt":          attempt,
+			"error":            err,
+		}).Warn("Download incomplete. Retry")
+
+		// start over with an empty file
+		digests.reset()
+		stored.reset()
+		if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := tmpFile.Truncate(0); err != nil {
+			return nil, err
+		}
+	}
+
+	if decompressed != nil {
+		// report the size of the stored file instead of the transferred one
+		if fileInfo, err := tmpFile.Stat(); err == nil {
+			written = fileInfo.Size()
 		}
 	}
 
@@ -175,35 +622,95 @@ func (bd *BuildkiteHandler) downloadArtifact(artifact BuildkiteBuildArtifactInfo
 		}).Fatal("Cannot close tmpfile")
 	}
 
-	if strings.HasSuffix(destPath, ".apk") {
+	digestAlgorithm, digest, err := verifyDigest(artifact, digests)
+	if err != nil {
 		log.WithFields(log.Fields{
 			"buildID":          bd.buildID,
 			"artifactFilename": artifact.Filename,
 			"tmpFile":          tmpFile.Name(),
-		}).Info("Validate APK")
-		_, err := apkverifier.Verify(tmpFile.Name(), nil)
-		if err != nil {
+			"error":            err,
+		}).Warn("Checksum verification failed")
+		return nil, err
+	}
+	if digestAlgorithm != "" {
+		log.WithFields(log.Fields{
+			"buildID":          bd.buildID,
+			"artifactFilename": artifact.Filename,
+			"algorithm":        digestAlgorithm,
+			"digest":           digest,
+		}).Info("Checksum verified")
+	}
+
+	if bd.verifySignatures && !isSignatureFile(artifact.Filename) {
+		if err := bd.verifyArtifactSignature(artifact, tmpFile.Name()); err != nil {
 			log.WithFields(log.Fields{
 				"buildID":          bd.buildID,
 				"artifactFilename": artifact.Filename,
 				"tmpFile":          tmpFile.Name(),
 				"error":            err,
-			}).Warn("Verification of APK failed: %s", err.Error())
-			return fmt.Errorf("Verification of APK failed: %s", err.Error())
+			}).Warn("Signature verification failed")
+			return nil, err
 		}
 	}
 
-	data, err := ioutil.ReadFile(tmpFile.Name())
-	if err != nil {
+	if bd.needsProvenance(artifact.Filename) {
+		if err := bd.verifyProvenance(artifact, tmpFile.Name()); err != nil {
+			log.WithFields(log.Fields{
+				"buildID":          bd.buildID,
+				"artifactFilename": artifact.Filename,
+				"tmpFile":          tmpFile.Name(),
+				"error":            err,
+			}).Warn("Provenance verification failed")
+			return nil, err
+		}
+	}
+
+	if err := bd.verifyContent(artifact, tmpFile.Name()); err != nil {
 		log.WithFields(log.Fields{
 			"buildID":          bd.buildID,
 			"artifactFilename": artifact.Filename,
 			"tmpFile":          tmpFile.Name(),
 			"error":            err,
-		}).Warn("Cannot read tmpfile")
-		return fmt.Errorf("Cannot read tmpfile %s ('%s')", tmpFile.Name(), err)
+		}).Warn("Verification of artifact failed")
+		return nil, err
 	}
-	err = ioutil.WriteFile(destPath, data, 0644)
+
+	if err := bd.scanArtifact(artifact, tmpFile.Name()); err != nil {
+		log.WithFields(log.Fields{
+			"buildID":          bd.buildID,
+			"artifactFilename": artifact.Filename,
+			"tmpFile":          tmpFile.Name(),
+			"error":            err,
+		}).Warn("Artifact rejected by scan")
+		return nil, err
+	}
+
+	var apkInfo *common.APKInfo
+	if strings.HasSuffix(artifact.Filename, ".apk") {
+		apkInfo, err = common.ReadAPKInfo(tmpFile.Name())
+		if err != nil {
+			log.WithFields(log.Fields{
+				"buildID":          bd.buildID,
+				"artifactFilename": artifact.Filename,
+				"tmpFile":          tmpFile.Name(),
+				"error":            err,
+			}).Warn("Cannot read APK metadata")
+			if needsAPKInfo {
+				return nil, fmt.Errorf("Cannot read APK metadata of %s (%v)", artifact.Filename, err)
+			}
+		}
+	}
+	if needsAPKInfo {
+		destPath, err = bd.getDestinationPath(buildInfo, artifact, apkInfo)
+		if err != nil {
+			return nil, err
+		}
+		if err := bd.checkExistingDownload(artifact, destPath, stored.sha256Hex()); err != nil {
+			return nil, err
+		}
+	}
+
+	backupPath, err := bd.placeArtifact(tmpFile.Name(), destPath, written, stored.sha256Hex())
 	if err != nil {
 		log.WithFields(log.Fields{
 			"buildID":          bd.buildID,
@@ -211,13 +718,77 @@ func (bd *BuildkiteHandler) downloadArtifact(artifact BuildkiteBuildArtifactInfo
 			"destination":      destPath,
 			"error":            err,
 		}).Warn("Cannot write to destination")
-		return fmt.Errorf("Cannot write to %s ('%s')", destPath, err)
+		return nil, err
 	}
 
 	log.WithFields(log.Fields{
 		"buildID":          bd.buildID,
 		"artifactFilename": artifact.Filename,
 		"destination":      destPath,
+		"algorithm":        digestAlgorithm,
+		"digest":           digest,
 	}).Info("Download finished")
-	return nil
+
+	var extracted []string
+	if bd.extractArchives && !bd.mirror && isArchive(destPath) {
+		extracted, err = bd.extractArchive(destPath)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"buildID":          bd.buildID,
+				"artifactFilename": artifact.Filename,
+				"destination":      destPath,
+				"error":            err,
+			}).Warn("Extraction failed")
+		}
+	}
+
+	result := &DownloadResult{
+		Filename:        artifact.Filename,
+		Destination:     destPath,
+		Size:            written,
+		SHA1:            stored.sha1Hex(),
+		SHA256:          stored.sha256Hex(),
+		DigestAlgorithm: digestAlgorithm,
+		Digest:          digest,
+		APK:             apkInfo,
+		Extracted:       extracted,
+		Backup:          backupPath,
+		job:             artifact.job,
+	}
+	if bd.ipfs != nil {
+		bd.publishToIPFS(result)
+	}
+	if bd.torrent != nil {
+		bd.createTorrent(result)
+	}
+	metadata := bd.artifactMetadata(buildInfo, artifact, result)
+	if bd.writeSidecars {
+		if err := bd.writeSidecar(metadata); err != nil {
+			log.WithFields(log.Fields{
+				"buildID":          bd.buildID,
+				"artifactFilename": artifact.Filename,
+				"error":            err,
+			}).Warn("Cannot write metadata file")
+		}
+	}
+	if bd.auditLog != "" {
+		if err := bd.appendAuditLog(metadata); err != nil {
+			log.WithFields(log.Fields{
+				"buildID":          bd.buildID,
+				"artifactFilename": artifact.Filename,
+				"auditLog":         bd.auditLog,
+				"error":            err,
+			}).Warn("Cannot append to audit log")
+		}
+	}
+	if bd.postHook != "" {
+		if err := bd.runPostHook(metadata); err != nil {
+			log.WithFields(log.Fields{
+				"buildID":          bd.buildID,
+				"artifactFilename": artifact.Filename,
+				"error":            err,
+			}).Warn("Post hook failed")
+		}
+	}
+	return result, nil
 }
@@ -0,0 +1,236 @@
+package buildkiteArtifactDownloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGetPageReturnsNetworkErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := srv.URL
+	srv.Close()
+
+	bd := NewBuildkiteHandler("org", "pipe")
+	if _, _, err := bd.getPage(url); err == nil {
+		t.Error("request to a closed server succeeded")
+	}
+}
+
+func TestNextPage(t *testing.T) {
+	tests := []struct {
+		links []string
+		next  string
+	}{
+		{nil, ""},
+		{[]string{`<https://api.buildkite.com/v2/artifacts?page=2>; rel="next"`}, "https://api.buildkite.com/v2/artifacts?page=2"},
+		{[]string{`<https://api.github.com/artifacts?page=3>; rel="next", <https://api.github.com/artifacts?page=9>; rel="last"`}, "https://api.github.com/artifacts?page=3"},
+		{[]string{`<https://api.github.com/artifacts?page=1>; rel="prev", <https://api.github.com/artifacts?page=3>;rel="next"`}, "https://api.github.com/artifacts?page=3"},
+		// the last page only links backwards
+		{[]string{`<https://api.github.com/artifacts?page=1>; rel="first", <https://api.github.com/artifacts?page=8>; rel="prev"`}, ""},
+		{[]string{`<https://api.github.com/artifacts?page=1>; rel="prev"`, `<https://api.github.com/artifacts?page=3>; rel="next"`}, "https://api.github.com/artifacts?page=3"},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		for _, link := range test.links {
+			header.Add("Link", link)
+		}
+		if next := nextPage(header); next != test.next {
+			t.Errorf("%v: got %q, want %q", test.links, next, test.next)
+		}
+	}
+}
+
+func TestArtifactInfoFollowsLinkHeader(t *testing.T) {
+	tests := []struct {
+		pages     int
+		artifacts int
+	}{
+		{1, 1},
+		{3, 3},
+		// pages beyond maxArtifactPages are ignored
+		{maxArtifactPages + 5, maxArtifactPages},
+	}
+	for _, test := range tests {
+		var requests int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			if page == 0 {
+				page = 1
+			}
+			if page < test.pages {
+				w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?page=%d>; rel="next"`, r.Host, r.URL.Path, page+1))
+			}
+			fmt.Fprintf(w, `{"artifacts": [{"id": %d, "name": "artifact-%d", "archive_download_url": "http://%s/zip/%d"}]}`, page, page, r.Host, page)
+		}))
+
+		bd := NewBuildkiteHandler("org", "repo")
+		if err := bd.SetGitHubActions(srv.URL, "", "token"); err != nil {
+			t.Fatal(err)
+		}
+		artifacts, err := bd.provider.getArtifactInfo("7")
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%d pages: %v", test.pages, err)
+		}
+		if len(artifacts) != test.artifacts || requests != test.artifacts {
+			t.Errorf("%d pages: got %d artifacts with %d requests, want %d", test.pages, len(artifacts), requests, test.artifacts)
+			continue
+		}
+		for i, artifact := range artifacts {
+			if want := "artifact-" + strconv.Itoa(i+1); artifact.Filename != want {
+				t.Errorf("%d pages: artifact %d is %s, want %s", test.pages, i, artifact.Filename, want)
+			}
+		}
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	var requests, notModified int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprintf(w, "body of %s", r.URL.Path)
+	}))
+	defer srv.Close()
+
+	bd := NewBuildkiteHandler("org", "pipe")
+	bd.SetBuildID(7)
+	for i := 0; i < 2; i++ {
+		body, err := bd.getData(srv.URL + "/builds/7")
+		if err != nil || string(body) != "body of /builds/7" {
+			t.Fatalf("request %d: got %q (%v)", i, body, err)
+		}
+	}
+	if requests != 2 || notModified != 1 {
+		t.Errorf("got %d requests with %d answered by 304, want 2 and 1", requests, notModified)
+	}
+
+	for i := 0; i < 2; i++ {
+		body, err := bd.getLogData(srv.URL + "/jobs/1/log.txt")
+		if err != nil || string(body) != "body of /jobs/1/log.txt" {
+			t.Fatalf("log request %d: got %q (%v)", i, body, err)
+		}
+	}
+	if _, cached := bd.responseCache[srv.URL+"/jobs/1/log.txt"]; cached {
+		t.Error("log got cached")
+	}
+	if notModified != 1 {
+		t.Error("log got requested conditionally")
+	}
+
+	// responses of other builds are dropped once the next build is processed
+	bd.SetBuildID(0)
+	bd.getData(srv.URL + "/builds")
+	bd.SetBuildID(8)
+	bd.evictCachedResponses()
+	if _, cached := bd.responseCache[srv.URL+"/builds/7"]; cached {
+		t.Error("response of the previous build is still cached")
+	}
+	if _, cached := bd.responseCache[srv.URL+"/builds"]; !cached {
+		t.Error("build list got evicted")
+	}
+
+	for i := 0; i < maxCachedResponses+10; i++ {
+		bd.getData(srv.URL + "/artifacts/" + strconv.Itoa(i))
+	}
+	if len(bd.responseCache) > maxCachedResponses {
+		t.Errorf("cache holds %d responses, want at most %d", len(bd.responseCache), maxCachedResponses)
+	}
+}
+
+func TestDownloadArtifactVerification(t *testing.T) {
+	content := []byte("artifact content")
+	sha1sum := "1cf80df7d11d9e5e5fe9e8cfb19fcd767b3b78bc"
+	sum := sha256.Sum256(content)
+	sha256sum := hex.EncodeToString(sum[:])
+	tests := []struct {
+		name          string
+		contentLength int
+		artifact      BuildkiteBuildArtifactInfo
+		valid         bool
+		// incomplete transfers are retried
+		requests int
+	}{
+		{"matching SHA-256", len(content), BuildkiteBuildArtifactInfo{SHA256sum: sha256sum, FileSize: int64(len(content))}, true, 1},
+		{"matching SHA-1", len(content), BuildkiteBuildArtifactInfo{SHA1sum: sha1sum}, true, 1},
+		{"mismatching SHA-256", len(content), BuildkiteBuildArtifactInfo{SHA256sum: strings.Repeat("0", 64)}, false, 1},
+		// the stronger checksum wins
+		{"mismatching SHA-256 with SHA-1", len(content), BuildkiteBuildArtifactInfo{SHA1sum: sha1sum, SHA256sum: strings.Repeat("0", 64)}, false, 1},
+		{"mismatching SHA-1", len(content), BuildkiteBuildArtifactInfo{SHA1sum: strings.Repeat("0", 40)}, false, 1},
+		{"truncated body", len(content) + 10, BuildkiteBuildArtifactInfo{}, false, 2},
+		{"size reported by the API", len(content), BuildkiteBuildArtifactInfo{FileSize: int64(len(content)) + 1}, false, 2},
+	}
+	root, err := ioutil.TempDir("", "download-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	// downloads are written to a temporary file first
+	tmpDir := filepath.Join(root, "tmp")
+	if err := os.Mkdir(tmpDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", tmpDir)
+
+	for _, test := range tests {
+		var requests int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.Header().Set("Content-Length", strconv.Itoa(test.contentLength))
+			w.Write(content)
+		}))
+		dir, err := ioutil.TempDir(root, "dest-")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bd := NewBuildkiteHandler("org", "pipe")
+		bd.SetBuildID(1)
+		bd.SetDownloadAttempts(2)
+		if err := bd.SetDestinationPattern(dir + "/<artifactFilename>"); err != nil {
+			t.Fatal(err)
+		}
+		artifact := test.artifact
+		artifact.Filename = "app.txt"
+		artifact.URL = srv.URL + "/app.txt"
+		result, err := bd.downloadArtifact(BuildkiteBuildInfo{}, artifact)
+		srv.Close()
+
+		entries, _ := ioutil.ReadDir(dir)
+		if leftovers, _ := ioutil.ReadDir(tmpDir); len(leftovers) != 0 {
+			t.Errorf("%s: temporary file %s got left", test.name, leftovers[0].Name())
+		}
+		if requests != test.requests {
+			t.Errorf("%s: got %d requests, want %d", test.name, requests, test.requests)
+		}
+		if test.valid {
+			stored, readErr := ioutil.ReadFile(filepath.Join(dir, "app.txt"))
+			if err != nil || result == nil || readErr != nil || string(stored) != string(content) {
+				t.Errorf("%s: got %q (%v, %v)", test.name, stored, err, readErr)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: download got accepted", test.name)
+		}
+		if len(entries) != 0 {
+			t.Errorf("%s: %s got left at the destination", test.name, entries[0].Name())
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package buildkiteArtifactDownloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// SetAuditLog appends one JSON line per downloaded artifact to path. The
+// file is never truncated so it keeps the history of all runs
+func (bd *BuildkiteHandler) SetAuditLog(path string) {
+	bd.auditLog = path
+}
+
+// appendAuditLog writes metadata as single line to the audit log
+func (bd *BuildkiteHandler) appendAuditLog(metadata ArtifactMetadata) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(bd.auditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, bd.fileMode)
+	if err != nil {
+		return fmt.Errorf("Cannot open audit log (%v)", err)
+	}
+	// a single write keeps lines of concurrent writers intact
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("Cannot write audit log (%v)", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,318 @@
+package buildkiteArtifactDownloader
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultAzureDevOpsURL is the server of Azure DevOps Services
+	DefaultAzureDevOpsURL = "https://dev.azure.com"
+	// azureDevOpsAPIVersion is the version of the REST API which is used
+	azureDevOpsAPIVersion = "6.0"
+	// azureDevOpsArtifactHost serves the pipeline artifacts of Azure
+	// DevOps Services
+	azureDevOpsArtifactHost = "artifacts.visualstudio.com"
+	// azureDevOpsBranchPrefix prefixes the branches of the API
+	azureDevOpsBranchPrefix = "refs/heads/"
+)
+
+// azureDevOpsProvider downloads the artifacts of Azure DevOps pipelines.
+// The organization and pipeline of the handler are the organization and
+// project, a build is a build with a single job. The artifacts are
+// downloaded as zip archive which gets unpacked
+type azureDevOpsProvider struct {
+	*unpackedArtifacts
+	bd         *BuildkiteHandler
+	serverURL  string
+	definition string
+	token      string
+	// definitionID is the resolved ID of definition
+	definitionID int
+}
+
+// azureDevOpsBuild is the part of a build of the Azure DevOps API which is
+// used
+type azureDevOpsBuild struct {
+	ID            int    `json:"id"`
+	BuildNumber   string `json:"buildNumber"`
+	Status        string `json:"status"`
+	Result        string `json:"result"`
+	SourceBranch  string `json:"sourceBranch"`
+	SourceVersion string `json:"sourceVersion"`
+	Definition    struct {
+		Name string `json:"name"`
+	} `json:"definition"`
+	RequestedFor struct {
+		DisplayName string `json:"displayName"`
+		UniqueName  string `json:"uniqueName"`
+	} `json:"requestedFor"`
+	// TriggerInfo holds the commit message of CI builds as ci.message
+	TriggerInfo map[string]string `json:"triggerInfo"`
+	Links       struct {
+		Web struct {
+			Href string `json:"href"`
+		} `json:"web"`
+	} `json:"_links"`
+}
+
+// azureDevOpsArtifact is an artifact published by a build
+type azureDevOpsArtifact struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Resource struct {
+		Type        string `json:"type"`
+		DownloadURL string `json:"downloadUrl"`
+		Properties  struct {
+			ArtifactSize string `json:"artifactsize"`
+		} `json:"properties"`
+	} `json:"resource"`
+}
+
+// SetAzureDevOps downloads the published artifacts of Azure DevOps
+// pipelines instead of Buildkite builds. The organization and pipeline are
+// the organization and project. definition (the name or ID of the pipeline)
+// restricts the builds the latest one is picked from. token is a personal
+// access token with the Build (Read) scope. serverURL may point to an Azure
+// DevOps Server collection
+func (bd *BuildkiteHandler) SetAzureDevOps(serverURL string, definition string, token string) error {
+	if token == "" {
+		return fmt.Errorf("Downloading Azure DevOps artifacts requires a personal access token")
+	}
+	if serverURL == "" {
+		serverURL = DefaultAzureDevOpsURL
+	}
+	if _, err := url.Parse(serverURL); err != nil {
+		return fmt.Errorf("Invalid Azure DevOps URL %s (%v)", serverURL, err)
+	}
+	bd.provider = &azureDevOpsProvider{
+		unpackedArtifacts: newUnpackedArtifacts(bd),
+		bd:                bd,
+		serverURL:         strings.TrimSuffix(serverURL, "/"),
+		definition:        definition,
+		token:             token,
+	}
+	return nil
+}
+
+// apiURL returns the URL of resource in the build API of the project
+func (p *azureDevOpsProvider) apiURL(resource string, query url.Values) string {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("api-version", azureDevOpsAPIVersion)
+	return p.serverURL + "/" + url.PathEscape(p.bd.buildkiteOrg) + "/" + url.PathEscape(p.bd.buildkitePipeline) +
+		"/_apis/build/" + resource + "?" + query.Encode()
+}
+
+// getDefinitionID resolves the definition by its name unless it is an ID
+func (p *azureDevOpsProvider) getDefinitionID() (int, error) {
+	if p.definitionID != 0 {
+		return p.definitionID, nil
+	}
+	if id, err := strconv.Atoi(p.definition); err == nil {
+		p.definitionID = id
+		return id, nil
+	}
+	query := url.Values{}
+	query.Set("name", p.definition)
+	bodyBytes, err := p.bd.getData(p.apiURL("definitions", query))
+	if err != nil {
+		return 0, err
+	}
+	var definitions struct {
+		Value []struct {
+			ID int `json:"id"`
+		} `json:"value"`
+	}
+	if err := json.Unmarshal(bodyBytes, &definitions); err != nil {
+		return 0, fmt.Errorf("Cannot parse pipeline definitions (%v)", err)
+	}
+	if len(definitions.Value) == 0 {
+		return 0, fmt.Errorf("No pipeline %s in project %s", p.definition, p.bd.buildkitePipeline)
+	}
+	p.definitionID = definitions.Value[0].ID
+	return p.definitionID, nil
+}
+
+func (p *azureDevOpsProvider) getLatestBuildID() (int, error) {
+	query := url.Values{}
+	branch := p.bd.getBranch()
+	if !strings.HasPrefix(branch, "refs/") {
+		branch = azureDevOpsBranchPrefix + branch
+	}
+	query.Set("branchName", branch)
+	query.Set("statusFilter", "completed")
+	query.Set("resultFilter", "succeeded")
+	query.Set("queryOrder", "finishTimeDescending")
+	query.Set("$top", strconv.Itoa(p.bd.candidateBuilds()))
+	if p.definition != "" {
+		id, err := p.getDefinitionID()
+		if err != nil {
+			return 0, err
+		}
+		query.Set("definitions", strconv.Itoa(id))
+	}
+	bodyBytes, err := p.bd.getData(p.apiURL("builds", query))
+	if err != nil {
+		return 0, err
+	}
+	var builds struct {
+		Value []azureDevOpsBuild `json:"value"`
+	}
+	if err := json.Unmarshal(bodyBytes, &builds); err != nil {
+		return 0, fmt.Errorf("Cannot parse builds (%v)", err)
+	}
+	for _, build := range builds.Value {
+		if p.bd.matchesCommit(build.TriggerInfo["ci.message"], build.RequestedFor.DisplayName, build.RequestedFor.UniqueName) {
+			return build.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("No matching successful build on branch %s", p.bd.getBranch())
+}
+
+// getDefaultBranch returns the default branch of the repository of the
+// definition. Without definition there is no repository to ask
+func (p *azureDevOpsProvider) getDefaultBranch() (string, error) {
+	if p.definition == "" {
+		return DefaultBranch, nil
+	}
+	id, err := p.getDefinitionID()
+	if err != nil {
+		return "", err
+	}
+	bodyBytes, err := p.bd.getData(p.apiURL("definitions/"+strconv.Itoa(id), nil))
+	if err != nil {
+		return "", err
+	}
+	var definition struct {
+		Repository struct {
+			DefaultBranch string `json:"defaultBranch"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(bodyBytes, &definition); err != nil {
+		return "", fmt.Errorf("Cannot parse pipeline definition (%v)", err)
+	}
+	if definition.Repository.DefaultBranch == "" {
+		return "", fmt.Errorf("Repository of pipeline %s has no default branch", p.definition)
+	}
+	return strings.TrimPrefix(definition.Repository.DefaultBranch, azureDevOpsBranchPrefix), nil
+}
+
+// azureDevOpsState maps the status and result of a build onto the states
+// of Buildkite builds
+func azureDevOpsState(build azureDevOpsBuild) string {
+	if build.Status != "completed" {
+		return "running"
+	}
+	switch build.Result {
+	case "succeeded":
+		return "passed"
+	case "canceled":
+		return "canceled"
+	default:
+		// partiallySucceeded builds had failing tasks as well
+		return "failed"
+	}
+}
+
+func (p *azureDevOpsProvider) getBuildInfo() (*BuildkiteBuildInfo, error) {
+	bodyBytes, err := p.bd.getData(p.apiURL("builds/"+strconv.Itoa(p.bd.buildID), nil))
+	if err != nil {
+		return nil, err
+	}
+	var build azureDevOpsBuild
+	if err := json.Unmarshal(bodyBytes, &build); err != nil {
+		return nil, fmt.Errorf("Cannot parse build (%v)", err)
+	}
+	state := azureDevOpsState(build)
+	return &BuildkiteBuildInfo{
+		State:    state,
+		CommitID: build.SourceVersion,
+		Branch:   strings.TrimPrefix(build.SourceBranch, azureDevOpsBranchPrefix),
+		Number:   build.ID,
+		Message:  build.TriggerInfo["ci.message"],
+		WebURL:   build.Links.Web.Href,
+		Author: BuildkiteBuildAuthor{
+			Name:     build.RequestedFor.DisplayName,
+			Username: build.RequestedFor.UniqueName,
+		},
+		// artifacts belong to the build and not to its jobs
+		Jobs: []BuildkiteBuildJobInfo{{
+			ID:    strconv.Itoa(build.ID),
+			Name:  build.Definition.Name,
+			State: state,
+		}},
+	}, nil
+}
+
+func (p *azureDevOpsProvider) getArtifactInfo(jobID string) ([]BuildkiteBuildArtifactInfo, error) {
+	bodyBytes, err := p.bd.getData(p.apiURL("builds/"+jobID+"/artifacts", nil))
+	if err != nil {
+		return nil, err
+	}
+	var artifacts struct {
+		Value []azureDevOpsArtifact `json:"value"`
+	}
+	if err := json.Unmarshal(bodyBytes, &artifacts); err != nil {
+		return nil, fmt.Errorf("Cannot parse artifacts (%v)", err)
+	}
+
+	result := []BuildkiteBuildArtifactInfo{}
+	for _, artifact := range artifacts.Value {
+		if artifact.Resource.DownloadURL == "" {
+			// artifacts on file shares cannot be downloaded via the API
+			log.WithFields(log.Fields{
+				"buildID":  p.bd.buildID,
+				"artifact": artifact.Name,
+				"type":     artifact.Resource.Type,
+			}).Warn("Skip artifact without download URL")
+			continue
+		}
+		// only pipeline artifacts report their size
+		size, _ := strconv.ParseInt(artifact.Resource.Properties.ArtifactSize, 10, 64)
+		result = append(result, archiveArtifact(artifact.Name, strconv.Itoa(artifact.ID), artifact.Resource.DownloadURL, size))
+	}
+	return result, nil
+}
+
+// getJobLog concatenates the logs of the steps of the build
+func (p *azureDevOpsProvider) getJobLog(jobID string) ([]byte, error) {
+	bodyBytes, err := p.bd.getLogData(p.apiURL("builds/"+jobID+"/logs", nil))
+	if err != nil {
+		return nil, err
+	}
+	var logList struct {
+		Value []struct {
+			ID int `json:"id"`
+		} `json:"value"`
+	}
+	if err := json.Unmarshal(bodyBytes, &logList); err != nil {
+		return nil, fmt.Errorf("Cannot parse logs (%v)", err)
+	}
+	var logs []byte
+	for _, entry := range logList.Value {
+		stepLog, err := p.bd.getLogData(p.apiURL("builds/"+jobID+"/logs/"+strconv.Itoa(entry.ID), nil))
+		if err != nil {
+			return nil, fmt.Errorf("Cannot download log %d (%v)", entry.ID, err)
+		}
+		logs = append(logs, stepLog...)
+	}
+	return logs, nil
+}
+
+// authorize adds the personal access token to requests for the server and
+// the host of pipeline artifacts
+func (p *azureDevOpsProvider) authorize(req *http.Request) {
+	if strings.HasPrefix(req.URL.String(), p.serverURL+"/") ||
+		strings.HasSuffix(req.URL.Host, "."+azureDevOpsArtifactHost) {
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+p.token)))
+	}
+}
@@ -0,0 +1,177 @@
+package buildkiteArtifactDownloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultBackfillConcurrency is the count of builds downloaded at once
+	DefaultBackfillConcurrency = 2
+)
+
+// BackfillOptions selects the builds of a backfill
+type BackfillOptions struct {
+	// Branch restricts the builds to a branch (all branches if empty)
+	Branch string
+	// State restricts the builds to a state like passed (all if empty)
+	State string
+	// UntilBuild stops the walk at this build number (inclusive)
+	UntilBuild int
+	// UntilDate stops the walk at builds created before it
+	UntilDate time.Time
+	// Concurrency is the count of builds downloaded at once
+	Concurrency int
+}
+
+// BackfillResult is the outcome of a build of a backfill
+type BackfillResult struct {
+	BuildID   int
+	Downloads int
+	Report    RunReport
+	Err       error
+}
+
+// backfillBuild is the part of a build of the builds listing which is used
+type backfillBuild struct {
+	Number    int       `json:"number"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Backfill walks backwards through the builds of the pipeline until
+// opts.UntilBuild or opts.UntilDate and downloads every build like Start
+// does, opts.Concurrency builds at once. done is called for every build
+// from the calling goroutine. Listing builds requires an API token
+func (bd *BuildkiteHandler) Backfill(opts BackfillOptions, done func(BackfillResult)) error {
+	if _, ok := bd.provider.(buildkiteProvider); !ok {
+		return fmt.Errorf("Backfill only supports Buildkite")
+	}
+	if bd.apiToken == "" {
+		return fmt.Errorf("Backfill requires an API token")
+	}
+	if opts.UntilBuild <= 0 && opts.UntilDate.IsZero() {
+		return fmt.Errorf("Backfill requires a build number or date to stop at")
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultBackfillConcurrency
+	}
+
+	if bd.keepBuilds > 0 || bd.keepDays > 0 || bd.changelog != nil || bd.sizeDiff {
+		log.Warn("Retention, changelogs and size comparison are disabled during a backfill")
+	}
+
+	builds := make(chan int)
+	results := make(chan BackfillResult)
+	var workers sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for buildID := range builds {
+				worker := bd.backfillClone()
+				worker.SetBuildID(buildID)
+				downloads, err := worker.Start()
+				results <- BackfillResult{
+					BuildID:   buildID,
+					Downloads: downloads,
+					Report:    worker.Report(),
+					Err:       err,
+				}
+			}
+		}()
+	}
+
+	var listErr error
+	go func() {
+		listErr = bd.listBackfillBuilds(opts, builds)
+		close(builds)
+		workers.Wait()
+		close(results)
+	}()
+	for result := range results {
+		done(result)
+	}
+	return listErr
+}
+
+// listBackfillBuilds sends the numbers of the builds to backfill, newest
+// first, until the stop condition of opts is reached
+func (bd *BuildkiteHandler) listBackfillBuilds(opts BackfillOptions, builds chan<- int) error {
+	query := url.Values{}
+	if opts.Branch != "" {
+		query.Set("branch", opts.Branch)
+	}
+	if opts.State != "" {
+		query.Set("state", opts.State)
+	}
+	bd.metaDataQuery(query)
+	query.Set("per_page", strconv.Itoa(artifactsPerPage))
+	listURL := buildkiteAPIURL + "/organizations/" + bd.buildkiteOrg + "/pipelines/" + bd.buildkitePipeline + "/builds?" + query.Encode()
+
+	for listURL != "" {
+		bodyBytes, next, err := bd.getPage(listURL)
+		if err != nil {
+			return err
+		}
+		var page []backfillBuild
+		if err := json.Unmarshal(bodyBytes, &page); err != nil {
+			return fmt.Errorf("Cannot parse builds (%v)", err)
+		}
+		for _, build := range page {
+			if build.Number < opts.UntilBuild ||
+				(!opts.UntilDate.IsZero() && build.CreatedAt.Before(opts.UntilDate)) {
+				return nil
+			}
+			log.WithFields(log.Fields{
+				"buildID":   build.Number,
+				"createdAt": build.CreatedAt,
+			}).Debug("Queue build for backfill")
+			builds <- build.Number
+		}
+		listURL = next
+	}
+	return nil
+}
+
+// backfillClone returns a handler for a build of a backfill. As builds are
+// processed newest first and concurrently, retention would remove builds
+// which were just downloaded and the previous build is not processed yet
+// for changelogs and size comparisons. The state is not capped so an
+// interrupted backfill skips all processed builds
+func (bd *BuildkiteHandler) backfillClone() *BuildkiteHandler {
+	c := bd.clone()
+	c.keepBuilds = 0
+	c.keepDays = 0
+	c.changelog = nil
+	c.sizeDiff = false
+	c.uncappedState = true
+	return c
+}
+
+// clone returns a handler with the configuration of bd for downloading
+// another build concurrently. Only the Buildkite provider can be cloned
+func (bd *BuildkiteHandler) clone() *BuildkiteHandler {
+	c := *bd
+	c.results = nil
+	c.skipped = nil
+	c.failed = nil
+	c.unfinished = nil
+	c.attestations = nil
+	c.attestationPaths = nil
+	c.buildInfo = nil
+	c.responseCache = make(map[string]cachedResponse)
+	c.provider = buildkiteProvider{&c}
+	c.netClient = &http.Client{
+		Timeout:       bd.netClient.Timeout,
+		Transport:     bd.netClient.Transport,
+		CheckRedirect: c.checkRedirect,
+	}
+	return &c
+}
@@ -0,0 +1,37 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+)
+
+// SetMaxTotalSize limits the bytes downloaded per build. Once an artifact
+// would exceed the budget, it and all remaining artifacts are skipped. 0
+// disables the limit
+func (bd *BuildkiteHandler) SetMaxTotalSize(maxTotalSize int64) {
+	bd.maxTotalSize = maxTotalSize
+}
+
+// SetMaxArtifactSize skips artifacts which are larger than maxArtifactSize
+// according to the API. 0 disables the limit
+func (bd *BuildkiteHandler) SetMaxArtifactSize(maxArtifactSize int64) {
+	bd.maxArtifactSize = maxArtifactSize
+}
+
+// checkBudget reports if artifact may be downloaded after downloadedBytes
+// got downloaded already. Artifacts of unknown size are downloaded as long
+// as the budget is not used up
+func (bd *BuildkiteHandler) checkBudget(artifact BuildkiteBuildArtifactInfo, downloadedBytes int64) error {
+	if bd.maxTotalSize <= 0 {
+		return nil
+	}
+	if !bd.budgetExceeded && downloadedBytes+artifact.FileSize > bd.maxTotalSize {
+		bd.budgetExceeded = true
+	}
+	if !bd.budgetExceeded && artifact.FileSize == 0 && downloadedBytes >= bd.maxTotalSize {
+		bd.budgetExceeded = true
+	}
+	if bd.budgetExceeded {
+		return fmt.Errorf("Download budget of %d bytes exceeded. Skip %s", bd.maxTotalSize, artifact.Filename)
+	}
+	return nil
+}
@@ -2,28 +2,145 @@ package buildkiteArtifactDownloader
 
 import (
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"path"
 	"regexp"
-	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
+	common "github.com/krombel/buildkite-artifact-downloader/common"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
 	// DefaultDestinationPattern for artifact download
 	DefaultDestinationPattern = "./<buildID>-<commitID>-<artifactFilename>"
+	// DefaultBranch is used to resolve the latest build if no branch is set
+	// and the default branch of the pipeline cannot be queried
+	DefaultBranch = "develop"
+	// DefaultDownloadAttempts is the count of tries per artifact when a
+	// download gets interrupted or truncated
+	DefaultDownloadAttempts = 3
+	// DefaultFileMode is applied to written files
+	DefaultFileMode os.FileMode = 0644
+	// DefaultDirMode is applied to created directories
+	DefaultDirMode os.FileMode = 0755
 )
 
 // BuildkiteHandler object which handles all data to fetch artifacts from a pipeline
 type BuildkiteHandler struct {
-	buildkiteOrg      string
-	buildkitePipeline string
-	buildID           int
-	artifactFilter    *regexp.Regexp
-	destPattern       string
-	netClient         *http.Client
+	buildkiteOrg       string
+	buildkitePipeline  string
+	buildID            int
+	branch             string
+	defaultBranch      string
+	artifactFilters    []*regexp.Regexp
+	artifactGlobs      []string
+	mimeTypes          []string
+	jobFilter          *regexp.Regexp
+	stepKeys           []string
+	metaDataFilters    map[string]string
+	messageFilter      *regexp.Regexp
+	authorFilter       *regexp.Regexp
+	onlyPassedJobs     bool
+	artifactOrder      string
+	maxArtifacts       int
+	artifactExclude    *regexp.Regexp
+	pathFilter         *regexp.Regexp
+	destPattern        string
+	destTemplate       *template.Template
+	apiToken           string
+	downloadAttempts   int
+	writeManifest      bool
+	gpgKey             string
+	gpgHome            string
+	verifySignatures   bool
+	keyring            string
+	apkSignerPins      []apkSignerPin
+	rejectDebugAPKs    bool
+	verificationRules  []verificationRule
+	provenance         *provenanceConfig
+	ipfs               *ipfsConfig
+	torrent            *torrentConfig
+	attestations       []BuildkiteBuildArtifactInfo
+	attestationPaths   []string
+	scanCommand        []string
+	postHook           string
+	postRunHook        string
+	decider            ArtifactDecider
+	quarantineDir      string
+	extractArchives    bool
+	decompressGzip     bool
+	startTime          time.Time
+	forceOverwrite     bool
+	backupExisting     bool
+	latestLinks        []latestLink
+	keepBuilds         int
+	keepDays           int
+	hardlinkDuplicates bool
+	fileMode           os.FileMode
+	dirMode            os.FileMode
+	ownerUID           int
+	ownerGID           int
+	writeSidecars      bool
+	downloadJobLogs    bool
+	changelog          *changelogConfig
+	changelogText      string
+	sizeDiff           bool
+	sizeGrowthWarning  float64
+	sizeChanges        []SizeChange
+	mirror             bool
+	mirrorDir          string
+	auditLog           string
+	stateFile          string
+	uncappedState      bool
+	onlyNew            bool
+	maxTotalSize       int64
+	maxArtifactSize    int64
+	budgetExceeded     bool
+	buildInfo          *BuildkiteBuildInfo
+	provider           provider
+	skipped            []ArtifactOutcome
+	failed             []ArtifactOutcome
+	unfinished         []ArtifactOutcome
+	netClient          *http.Client
+	transport          *http.Transport
+	dialer             *net.Dialer
+	ipVersion          string
+	readTimeout        time.Duration
+	responseCache      map[string]cachedResponse
+	maxRedirects       int
+	results            []DownloadResult
+}
+
+// DownloadResult describes a downloaded artifact
+type DownloadResult struct {
+	Filename    string `json:"filename"`
+	Destination string `json:"destination"`
+	Size        int64  `json:"size"`
+	SHA1        string `json:"sha1"`
+	SHA256      string `json:"sha256"`
+	// DigestAlgorithm and Digest are empty when the API did not provide a
+	// checksum to verify the download against
+	DigestAlgorithm string `json:"digestAlgorithm,omitempty"`
+	Digest          string `json:"digest,omitempty"`
+	// APK holds the metadata of APK artifacts
+	APK *common.APKInfo `json:"apk,omitempty"`
+	// Extracted lists the files unpacked from archive artifacts
+	Extracted []string `json:"extracted,omitempty"`
+	// Backup is the path the replaced destination was kept at
+	Backup string `json:"backup,omitempty"`
+	// CID is the IPFS content identifier if the download was added to IPFS
+	CID string `json:"cid,omitempty"`
+	// Torrent is the path of the torrent file written for the download
+	Torrent string `json:"torrent,omitempty"`
+	// Log is the path of the log of the job which produced the artifact
+	Log string `json:"log,omitempty"`
+
+	job BuildkiteBuildJobInfo
 }
 
 // NewBuildkiteHandler constructs a new buildkite downloader instance
@@ -31,24 +148,48 @@ func NewBuildkiteHandler(
 	buildkiteOrg string,
 	buildkitePipeline string,
 ) *BuildkiteHandler {
-	return &BuildkiteHandler{
+	bd := &BuildkiteHandler{
 		buildkiteOrg:      buildkiteOrg,
 		buildkitePipeline: buildkitePipeline,
+		downloadAttempts:  DefaultDownloadAttempts,
+		fileMode:          DefaultFileMode,
+		dirMode:           DefaultDirMode,
+		ownerUID:          -1,
+		ownerGID:          -1,
 
-		netClient: &http.Client{
-			Timeout: time.Second * 10,
+		dialer: &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
 		},
+		readTimeout:   DefaultReadTimeout,
+		responseCache: make(map[string]cachedResponse),
+		maxRedirects:  DefaultMaxRedirects,
 	}
+	bd.provider = buildkiteProvider{bd}
+	bd.transport = newTransport(bd.dialContext)
+	// no total timeout as it would cover downloading the body as well. The
+	// connections enforce the read timeout instead
+	bd.netClient = &http.Client{
+		Transport:     bd.transport,
+		CheckRedirect: bd.checkRedirect,
+	}
+	return bd
 }
 
 // SetArtifactFilter sets (or deletes when nil passed) an artifact filter.
 // Only matching files will be downloaded
 func (bd *BuildkiteHandler) SetArtifactFilter(artifactFilter string) (err error) {
-	var reArtifactFilter *regexp.Regexp
+	bd.artifactFilters = nil
 	if artifactFilter == "" {
-		bd.artifactFilter = nil
 		return
 	}
+	return bd.AddArtifactFilter(artifactFilter)
+}
+
+// AddArtifactFilter adds an artifact filter. Files matching any of the
+// filters will be downloaded
+func (bd *BuildkiteHandler) AddArtifactFilter(artifactFilter string) (err error) {
+	var reArtifactFilter *regexp.Regexp
 	log.WithFields(log.Fields{
 		"artifactFilter": artifactFilter,
 	}).Debug("Compile artifact filter")
@@ -57,60 +198,177 @@ func (bd *BuildkiteHandler) SetArtifactFilter(artifactFilter string) (err error)
 	if err != nil {
 		return
 	}
-	bd.artifactFilter = reArtifactFilter
+	bd.artifactFilters = append(bd.artifactFilters, reArtifactFilter)
 	return
 }
 
-// SetBuildID prefills buildID
-func (bd *BuildkiteHandler) SetBuildID(buildID int) {
-	bd.buildID = buildID
+// AddArtifactGlob adds a shell glob (e.g. *.apk) as artifact filter. It is
+// combined with the regexp filters, files matching any of them will be
+// downloaded
+func (bd *BuildkiteHandler) AddArtifactGlob(artifactGlob string) error {
+	if _, err := path.Match(artifactGlob, ""); err != nil {
+		return fmt.Errorf("Cannot parse glob '%s' (%v)", artifactGlob, err)
+	}
+	bd.artifactGlobs = append(bd.artifactGlobs, artifactGlob)
+	return nil
 }
 
-// SetDestinationPattern allows overwriting the default destination pattern
-func (bd *BuildkiteHandler) SetDestinationPattern(destPattern string) {
-	bd.destPattern = destPattern
-	log.Info("Set DestPath: ", bd.destPattern)
+// SetArtifactGlobs replaces the artifact globs (deletes them when empty)
+func (bd *BuildkiteHandler) SetArtifactGlobs(artifactGlobs []string) error {
+	bd.artifactGlobs = nil
+	for _, artifactGlob := range artifactGlobs {
+		if err := bd.AddArtifactGlob(artifactGlob); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesArtifactFilter reports if filename matches one of the artifact
+// filters or globs. Without filters all files match
+func (bd *BuildkiteHandler) matchesArtifactFilter(filename string) bool {
+	if len(bd.artifactFilters) == 0 && len(bd.artifactGlobs) == 0 {
+		return true
+	}
+	for _, filter := range bd.artifactFilters {
+		if filter.MatchString(filename) {
+			return true
+		}
+	}
+	for _, glob := range bd.artifactGlobs {
+		if matched, _ := path.Match(glob, filename); matched {
+			return true
+		}
+	}
+	return false
 }
 
-func (bd *BuildkiteHandler) getDestinationPattern() string {
-	if bd.destPattern != "" {
-		return bd.destPattern
+// AddMimeType only downloads artifacts with the given mime type (e.g.
+// application/vnd.android.package-archive) as reported by the API. Globs like
+// image/* are supported. Artifacts matching any of the types are downloaded
+func (bd *BuildkiteHandler) AddMimeType(mimeType string) error {
+	if _, err := path.Match(mimeType, ""); err != nil {
+		return fmt.Errorf("Cannot parse mime type '%s' (%v)", mimeType, err)
 	}
-	return DefaultDestinationPattern
+	bd.mimeTypes = append(bd.mimeTypes, strings.ToLower(mimeType))
+	return nil
 }
 
-func (bd *BuildkiteHandler) getDestinationPath(buildInfo BuildkiteBuildInfo, artifact BuildkiteBuildArtifactInfo) string {
-	var output = bd.getDestinationPattern()
+// SetMimeTypes replaces the mime types (deletes them when empty)
+func (bd *BuildkiteHandler) SetMimeTypes(mimeTypes []string) error {
+	bd.mimeTypes = nil
+	for _, mimeType := range mimeTypes {
+		if err := bd.AddMimeType(mimeType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	log.WithFields(log.Fields{
-		"destPattern":      output,
-		"buildID":          bd.buildID,
-		"commit":           buildInfo.CommitID[:8],
-		"artifactFilename": artifact.Filename,
-	}).Info("getDestinationPath")
-
-	output = strings.ReplaceAll(
-		output,
-		`<buildID>`,
-		strconv.Itoa(bd.buildID),
-	)
-	output = strings.ReplaceAll(
-		output,
-		`<commitID>`,
-		buildInfo.CommitID[:8],
-	)
-	output = strings.ReplaceAll(
-		output,
-		`<artifactFilename>`,
-		artifact.Filename,
-	)
+// matchesMimeType reports if mimeType matches one of the configured types.
+// Parameters like "; charset=utf-8" are ignored
+func (bd *BuildkiteHandler) matchesMimeType(mimeType string) bool {
+	if len(bd.mimeTypes) == 0 {
+		return true
+	}
+	mimeType = strings.ToLower(strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0]))
+	for _, pattern := range bd.mimeTypes {
+		if matched, _ := path.Match(pattern, mimeType); matched {
+			return true
+		}
+	}
+	return false
+}
 
-	log.WithFields(log.Fields{
-		"output":  output,
-		"buildID": bd.buildID,
-	}).Info("ReplaceString end")
+// SetArtifactExclude sets (or deletes when empty) a filter which is applied
+// after the artifact filter. Matching files will not be downloaded
+func (bd *BuildkiteHandler) SetArtifactExclude(artifactExclude string) error {
+	if artifactExclude == "" {
+		bd.artifactExclude = nil
+		return nil
+	}
+	reArtifactExclude, err := regexp.Compile(artifactExclude)
+	if err != nil {
+		return err
+	}
+	bd.artifactExclude = reArtifactExclude
+	return nil
+}
 
-	return output
+// SetPathFilter sets (or deletes when empty) a filter which is matched
+// against the full relative path of the artifact (e.g.
+// outputs/apk/release/.*\.apk). Only matching files will be downloaded
+func (bd *BuildkiteHandler) SetPathFilter(pathFilter string) error {
+	if pathFilter == "" {
+		bd.pathFilter = nil
+		return nil
+	}
+	rePathFilter, err := regexp.Compile(pathFilter)
+	if err != nil {
+		return err
+	}
+	bd.pathFilter = rePathFilter
+	return nil
+}
+
+// SetBranch sets the branch whose latest passed build gets downloaded if no
+// build ID is set
+func (bd *BuildkiteHandler) SetBranch(branch string) {
+	bd.branch = branch
+}
+
+// getBranch returns the configured branch or the default branch of the
+// pipeline. DefaultBranch is used if the latter cannot be resolved
+func (bd *BuildkiteHandler) getBranch() string {
+	if bd.branch != "" {
+		return bd.branch
+	}
+	if bd.defaultBranch == "" {
+		bd.defaultBranch = DefaultBranch
+		branch, err := bd.provider.getDefaultBranch()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"branch": DefaultBranch,
+			}).Warn("Cannot resolve default branch of pipeline")
+		} else {
+			bd.defaultBranch = branch
+		}
+	}
+	return bd.defaultBranch
+}
+
+// SetBuildID prefills buildID
+func (bd *BuildkiteHandler) SetBuildID(buildID int) {
+	bd.buildID = buildID
+}
+
+// SetAPIToken enables the authenticated REST API (api.buildkite.com) which
+// e.g. provides SHA-256 checksums of artifacts
+func (bd *BuildkiteHandler) SetAPIToken(apiToken string) {
+	bd.apiToken = apiToken
+}
+
+// SetDownloadAttempts sets how often an interrupted or truncated download
+// gets tried before giving up
+func (bd *BuildkiteHandler) SetDownloadAttempts(attempts int) {
+	if attempts < 1 {
+		attempts = 1
+	}
+	bd.downloadAttempts = attempts
+}
+
+// SetDestinationPattern allows overwriting the default destination pattern.
+// Patterns containing "{{" are parsed as Go text/template
+func (bd *BuildkiteHandler) SetDestinationPattern(destPattern string) error {
+	destTemplate, err := parseDestinationTemplate(destPattern)
+	if err != nil {
+		return err
+	}
+	bd.destPattern = destPattern
+	bd.destTemplate = destTemplate
+	log.Info("Set DestPath: ", bd.destPattern)
+	return nil
 }
 
 // resolveArtifacts returns an array of artifacts (filtered by artifactFilter)
@@ -118,19 +376,41 @@ func (bd *BuildkiteHandler) resolveArtifacts(job BuildkiteBuildJobInfo) ([]Build
 	var err error
 
 	var artifactInfo []BuildkiteBuildArtifactInfo
-	artifactInfo, err = bd.getArtifactInfo(job.ID)
+	artifactInfo, err = bd.provider.getArtifactInfo(job.ID)
 	if err != nil {
 		return nil, err
 	}
 
+	// signatures are looked up in the unfiltered list as the artifact filter
+	// usually only matches the binaries
+	signatures := make(map[string]BuildkiteBuildArtifactInfo)
+	for _, artifact := range artifactInfo {
+		if isSignatureFile(artifact.Filename) {
+			signatures[artifact.Filename] = artifact
+		}
+		if bd.isAttestation(artifact.Filename) {
+			bd.attestations = append(bd.attestations, artifact)
+		}
+	}
+
 	var result []BuildkiteBuildArtifactInfo
 	for _, artifact := range artifactInfo {
-		if bd.artifactFilter != nil &&
-			!bd.artifactFilter.MatchString(artifact.Filename) {
+		artifact.signature = findSignature(signatures, artifact.Filename)
+		artifact.job = job
+		if !bd.mirror && !bd.matchesFilters(artifact) {
+			continue
+		}
+		// older responses do not contain the state
+		if artifact.State != "" && artifact.State != "finished" {
 			log.WithFields(log.Fields{
 				"buildID":          bd.buildID,
 				"artifactFilename": artifact.Filename,
-			}).Info("Skip artifact because it does not match artifact filter")
+				"state":            artifact.State,
+			}).Warn("Skip artifact because its upload is not finished")
+			bd.unfinished = append(bd.unfinished, ArtifactOutcome{
+				Filename: artifact.Filename,
+				Reason:   "Upload state is " + artifact.State,
+			})
 			continue
 		}
 		result = append(result, artifact)
@@ -139,24 +419,110 @@ func (bd *BuildkiteHandler) resolveArtifacts(job BuildkiteBuildJobInfo) ([]Build
 	return result, nil
 }
 
+// matchesFilters reports if the artifact passes the artifact, mime type,
+// path and size filters. Skipped artifacts are logged. The name filters of
+// archives apply to the name of the archive
+func (bd *BuildkiteHandler) matchesFilters(artifact BuildkiteBuildArtifactInfo) bool {
+	if !bd.matchesArtifactFilter(artifact.Filename) {
+		log.WithFields(log.Fields{
+			"buildID":          bd.buildID,
+			"artifactFilename": artifact.Filename,
+		}).Info("Skip artifact because it does not match artifact filter")
+		return false
+	}
+	if bd.artifactExclude != nil &&
+		bd.artifactExclude.MatchString(artifact.Filename) {
+		log.WithFields(log.Fields{
+			"buildID":          bd.buildID,
+			"artifactFilename": artifact.Filename,
+		}).Info("Skip artifact because it matches artifact exclude filter")
+		return false
+	}
+	if bd.pathFilter != nil &&
+		!bd.pathFilter.MatchString(artifact.fullPath()) {
+		log.WithFields(log.Fields{
+			"buildID":          bd.buildID,
+			"artifactFilename": artifact.Filename,
+			"artifactPath":     artifact.fullPath(),
+		}).Info("Skip artifact because it does not match path filter")
+		return false
+	}
+	return bd.matchesContentFilters(artifact)
+}
+
+// matchesContentFilters reports if the artifact passes the mime type and
+// size filters. The mime types of the files of archives are checked once
+// the archive got unpacked
+func (bd *BuildkiteHandler) matchesContentFilters(artifact BuildkiteBuildArtifactInfo) bool {
+	if artifact.archiveKey == "" && !bd.matchesMimeType(artifact.MimeType) {
+		log.WithFields(log.Fields{
+			"buildID":          bd.buildID,
+			"artifactFilename": artifact.Filename,
+			"mimeType":         artifact.MimeType,
+		}).Info("Skip artifact because its mime type does not match")
+		return false
+	}
+	if bd.maxArtifactSize > 0 && artifact.FileSize > bd.maxArtifactSize {
+		log.WithFields(log.Fields{
+			"buildID":          bd.buildID,
+			"artifactFilename": artifact.Filename,
+			"size":             artifact.FileSize,
+			"maxArtifactSize":  bd.maxArtifactSize,
+		}).Info("Skip artifact because it exceeds the maximum size")
+		return false
+	}
+	return true
+}
+
 // Start triggers a download of artifacts and returns
 // the count of artifact downloads
 func (bd *BuildkiteHandler) Start() (int, error) {
 	var err error
+	bd.results = nil
+	bd.skipped = nil
+	bd.failed = nil
+	bd.unfinished = nil
+	bd.buildInfo = nil
+	bd.changelogText = ""
+	bd.sizeChanges = nil
+	bd.budgetExceeded = false
+	bd.startTime = time.Now()
+	defer bd.cleanupAttestations()
+	defer bd.provider.cleanup()
 	if bd.buildID == 0 {
 		log.Debug("BuildId unset. Try resolving")
-		bd.buildID, err = bd.getLatestBuildID()
+		bd.buildID, err = bd.provider.getLatestBuildID()
 		// ignore error as it is just meant to be a fallback
 	}
 
 	if bd.buildID == 0 {
+		if err != nil {
+			return 0, fmt.Errorf("BuildID unset and cannot be resolved (%v)", err)
+		}
 		return 0, fmt.Errorf("BuildID unset and cannot be resolved")
 	}
 
-	buildInfo, err := bd.getBuildInfo()
+	bd.evictCachedResponses()
+
+	if bd.onlyNew {
+		processed, err := bd.processedBuild(bd.buildID)
+		if err != nil {
+			return 0, err
+		}
+		if processed != nil {
+			log.WithFields(log.Fields{
+				"buildID":     bd.buildID,
+				"processedAt": processed.ProcessedAt,
+			}).Info("Build was processed already")
+			return 0, ErrNothingNew
+		}
+	}
+
+	buildInfo, err := bd.provider.getBuildInfo()
 	if err != nil {
 		return 0, err
 	}
+	bd.buildInfo = buildInfo
 
 	if buildInfo.State == "failed" {
 		log.WithFields(log.Fields{
@@ -164,9 +530,39 @@ func (bd *BuildkiteHandler) Start() (int, error) {
 		}).Warn("Build failed. Abort")
 		return 0, fmt.Errorf("Build %d failed", bd.buildID)
 	}
+	if !bd.matchesMetaData(*buildInfo) {
+		log.WithFields(log.Fields{
+			"buildID":  bd.buildID,
+			"metaData": buildInfo.MetaData,
+		}).Warn("Build does not match meta-data filter. Abort")
+		return 0, fmt.Errorf("Build %d does not match meta-data filter", bd.buildID)
+	}
 
 	var artifacts []BuildkiteBuildArtifactInfo
-	for _, job := range buildInfo.Jobs {
+	jobs := bd.latestAttempts(buildInfo.Jobs)
+	if bd.mirror {
+		// a mirror keeps every attempt of every job
+		jobs = buildInfo.Jobs
+	}
+	for _, job := range jobs {
+		if bd.onlyPassedJobs && !bd.mirror && job.State != "passed" {
+			log.WithFields(log.Fields{
+				"buildID":  bd.buildID,
+				"jobID":    job.ID,
+				"jobName":  job.Name,
+				"jobState": job.State,
+			}).Info("Skip job because it did not pass")
+			continue
+		}
+		if !bd.mirror && !bd.matchesJob(job) {
+			log.WithFields(log.Fields{
+				"buildID": bd.buildID,
+				"jobID":   job.ID,
+				"jobName": job.Name,
+				"stepKey": job.StepKey,
+			}).Debug("Skip job because it does not match job filter")
+			continue
+		}
 		artifactsTmp, err := bd.resolveArtifacts(job)
 		if err != nil {
 			log.WithFields(log.Fields{
@@ -196,15 +592,133 @@ func (bd *BuildkiteHandler) Start() (int, error) {
 		"artifacts": len(artifacts),
 	}).Debug("Found artifacts")
 
+	bd.sortArtifacts(artifacts)
+	artifacts = bd.decideArtifacts(artifacts)
+	if bd.maxArtifacts > 0 && !bd.mirror && len(artifacts) > bd.maxArtifacts {
+		for _, artifact := range artifacts[bd.maxArtifacts:] {
+			log.WithFields(log.Fields{
+				"buildID":          bd.buildID,
+				"artifactFilename": artifact.Filename,
+				"maxArtifacts":     bd.maxArtifacts,
+			}).Info("Skip artifact because the maximum count of artifacts is reached")
+			bd.skipped = append(bd.skipped, ArtifactOutcome{
+				Filename: artifact.Filename,
+				Reason:   fmt.Sprintf("Limit of %d artifacts reached", bd.maxArtifacts),
+			})
+		}
+		artifacts = artifacts[:bd.maxArtifacts]
+	}
+
+	if err := bd.checkDiskSpace(*buildInfo, artifacts); err != nil {
+		log.WithFields(log.Fields{
+			"buildID": bd.buildID,
+			"error":   err,
+		}).Error("Pre-flight check failed")
+		return 0, err
+	}
+
 	var downloadCount int
-	for _, artifact := range artifacts {
-		outPath := bd.getDestinationPath(*buildInfo, artifact)
-		if err := bd.downloadArtifact(artifact, outPath); err != nil {
+	var downloadedBytes int64
+	for i := 0; i < len(artifacts); i++ {
+		artifact := artifacts[i]
+		if err := bd.checkBudget(artifact, downloadedBytes); err != nil {
+			log.WithFields(log.Fields{
+				"buildID":          bd.buildID,
+				"artifactFilename": artifact.Filename,
+			}).Warn(err)
+			bd.failed = append(bd.failed, ArtifactOutcome{Filename: artifact.Filename, Reason: err.Error()})
+			continue
+		}
+		if artifact.archiveKey != "" {
+			// the files of the archive are downloaded next
+			files, err := bd.unwrapArchive(artifact, downloadedBytes)
+			if err != nil {
+				log.Warn(err)
+				bd.failed = append(bd.failed, ArtifactOutcome{Filename: artifact.Filename, Reason: err.Error()})
+				continue
+			}
+			artifacts = append(artifacts[:i+1], append(files, artifacts[i+1:]...)...)
+			continue
+		}
+		result, err := bd.downloadArtifact(*buildInfo, artifact)
+		if _, skipped := err.(skippedError); skipped {
+			log.WithFields(log.Fields{
+				"buildID":          bd.buildID,
+				"artifactFilename": artifact.Filename,
+			}).Info(err)
+			bd.skipped = append(bd.skipped, ArtifactOutcome{Filename: artifact.Filename, Reason: err.Error()})
+		} else if err != nil {
 			log.Warn(err)
+			bd.failed = append(bd.failed, ArtifactOutcome{Filename: artifact.Filename, Reason: err.Error()})
 		} else {
 			// there is no error so we assume, that the download succeeded
 			downloadCount++
+			downloadedBytes += result.Size
+			bd.results = append(bd.results, *result)
+		}
+	}
+
+	if bd.downloadJobLogs && downloadCount > 0 {
+		bd.writeJobLogs()
+	}
+
+	if bd.changelog != nil && downloadCount > 0 {
+		bd.writeChangelogs()
+	}
+
+	if bd.sizeDiff && downloadCount > 0 {
+		bd.compareSizes()
+	}
+
+	if downloadCount > 0 {
+		bd.updateLatestLinks()
+		if err := bd.applyRetention(); err != nil {
+			log.WithFields(log.Fields{
+				"buildID": bd.buildID,
+				"error":   err,
+			}).Warn("Cannot apply retention")
+		}
+	}
+
+	if bd.writeManifest && downloadCount > 0 {
+		manifests, err := bd.writeChecksumManifests()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"buildID": bd.buildID,
+				"error":   err,
+			}).Warn("Cannot write checksum manifest")
+		}
+		if bd.gpgKey != "" {
+			for _, manifest := range manifests {
+				if err := bd.signFile(manifest); err != nil {
+					log.Warn(err)
+				}
+			}
+		}
+	}
+
+	if bd.postRunHook != "" && downloadCount > 0 {
+		if err := bd.runPostRunHook(); err != nil {
+			log.WithFields(log.Fields{
+				"buildID": bd.buildID,
+				"error":   err,
+			}).Warn("Post run hook failed")
+		}
+	}
+
+	// builds with failed or unfinished artifacts have to be processed again
+	if len(bd.failed) == 0 && len(bd.unfinished) == 0 {
+		if err := bd.recordProcessedBuild(); err != nil {
+			log.WithFields(log.Fields{
+				"buildID": bd.buildID,
+				"error":   err,
+			}).Warn("Cannot update state file")
 		}
 	}
 	return downloadCount, nil
 }
+
+// Results returns the artifacts downloaded by the last call of Start
+func (bd *BuildkiteHandler) Results() []DownloadResult {
+	return bd.results
+}
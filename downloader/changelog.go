@@ -0,0 +1,166 @@
+package buildkiteArtifactDownloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ChangelogSuffix is appended to the build ID for the file name of the
+	// generated changelog
+	ChangelogSuffix = "-changelog.txt"
+	// maxChangelogEntries bounds the commits listed in a changelog
+	maxChangelogEntries = 200
+	// changelogFormat is the git log format of a changelog entry
+	changelogFormat = "- %h %s (%an)"
+)
+
+// changelogConfig is the git remote changelogs are generated from
+type changelogConfig struct {
+	remote string
+	// cacheDir holds a bare clone of remote which is updated on every run
+	cacheDir string
+	// lock serializes the git commands of concurrent handlers
+	lock sync.Mutex
+}
+
+// SetChangelog generates a changelog of the commits between the previous
+// processed build (from the state file) and the current one. The commits
+// are taken from a bare clone of the git remote in cacheDir, which defaults
+// to a directory in the user cache. The changelog is written next to the
+// downloads as <buildID>-changelog.txt and added to the run report
+func (bd *BuildkiteHandler) SetChangelog(remote string, cacheDir string) error {
+	if remote == "" {
+		bd.changelog = nil
+		return nil
+	}
+	if bd.stateFile == "" {
+		return fmt.Errorf("Generating changelogs requires a state file")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		return fmt.Errorf("Generating changelogs requires git (%v)", err)
+	}
+	if cacheDir == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return fmt.Errorf("Cannot determine changelog cache (%v)", err)
+		}
+		sum := sha256.Sum256([]byte(remote))
+		cacheDir = filepath.Join(userCache, "buildkite-artifact-downloader", "git-"+hex.EncodeToString(sum[:8]))
+	}
+	bd.changelog = &changelogConfig{
+		remote:   remote,
+		cacheDir: cacheDir,
+	}
+	return nil
+}
+
+// git runs git with args and returns its output
+func (c *changelogConfig) git(args ...string) (string, error) {
+	output, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed (%v: %s)", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// update clones the remote into the cache or fetches its new commits
+func (c *changelogConfig) update() error {
+	if _, err := os.Stat(filepath.Join(c.cacheDir, "HEAD")); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(c.cacheDir), 0700); err != nil {
+			return err
+		}
+		// the changelog only needs the commits and not their content
+		_, err := c.git("clone", "--quiet", "--bare", "--filter=blob:none", c.remote, c.cacheDir)
+		return err
+	}
+	_, err := c.git("--git-dir", c.cacheDir, "fetch", "--quiet", "--prune", c.remote, "+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*")
+	return err
+}
+
+// generateChangelog lists the commits between the previous processed build
+// and the current one. It returns an empty changelog if there is no previous
+// build or the commit did not change
+func (bd *BuildkiteHandler) generateChangelog() (string, error) {
+	if bd.buildInfo == nil || bd.buildInfo.CommitID == "" {
+		return "", fmt.Errorf("Build has no commit")
+	}
+	previousBuild, err := bd.previousBuild()
+	if err != nil || previousBuild == nil {
+		return "", err
+	}
+	previous := previousBuild.CommitID
+	if previous == "" || previous == bd.buildInfo.CommitID {
+		return "", nil
+	}
+	bd.changelog.lock.Lock()
+	defer bd.changelog.lock.Unlock()
+	if err := bd.changelog.update(); err != nil {
+		return "", err
+	}
+	// commits of other branches might not have been fetched by the branch
+	// refspecs, fetching them directly works on most hosts
+	for _, commit := range []string{previous, bd.buildInfo.CommitID} {
+		if _, err := bd.changelog.git("--git-dir", bd.changelog.cacheDir, "cat-file", "-e", commit+"^{commit}"); err != nil {
+			if _, err := bd.changelog.git("--git-dir", bd.changelog.cacheDir, "fetch", "--quiet", bd.changelog.remote, commit); err != nil {
+				return "", err
+			}
+		}
+	}
+	return bd.changelog.git("--git-dir", bd.changelog.cacheDir, "log", "--no-merges",
+		"--max-count="+strconv.Itoa(maxChangelogEntries), "--format="+changelogFormat,
+		previous+".."+bd.buildInfo.CommitID)
+}
+
+// writeChangelogs generates the changelog and stores it in every directory
+// artifacts got downloaded to
+func (bd *BuildkiteHandler) writeChangelogs() {
+	changelog, err := bd.generateChangelog()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"buildID": bd.buildID,
+			"remote":  bd.changelog.remote,
+			"error":   err,
+		}).Warn("Cannot generate changelog")
+		return
+	}
+	if changelog == "" {
+		log.WithFields(log.Fields{
+			"buildID": bd.buildID,
+		}).Info("No changes since the previous build")
+		return
+	}
+	bd.changelogText = changelog
+
+	written := make(map[string]bool)
+	for _, result := range bd.results {
+		changelogPath := filepath.Join(filepath.Dir(result.Destination), strconv.Itoa(bd.buildID)+ChangelogSuffix)
+		if written[changelogPath] {
+			continue
+		}
+		written[changelogPath] = true
+		if err := writeFileAtomic(changelogPath, []byte(changelog), bd.fileMode); err != nil {
+			log.WithFields(log.Fields{
+				"buildID": bd.buildID,
+				"error":   err,
+			}).Warn("Cannot write changelog")
+			continue
+		}
+		if err := bd.chown(changelogPath); err != nil {
+			log.Warn(err)
+		}
+		log.WithFields(log.Fields{
+			"buildID":   bd.buildID,
+			"changelog": changelogPath,
+		}).Info("Changelog written")
+	}
+}
@@ -0,0 +1,105 @@
+package buildkiteArtifactDownloader
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// DigestSHA256 names the SHA-256 digest algorithm
+	DigestSHA256 = "sha256"
+	// DigestSHA1 names the SHA-1 digest algorithm
+	DigestSHA1 = "sha1"
+)
+
+// artifactDigests holds the checksums calculated while downloading
+type artifactDigests struct {
+	sha1   hash.Hash
+	sha256 hash.Hash
+}
+
+func newArtifactDigests() *artifactDigests {
+	return &artifactDigests{
+		sha1:   sha1.New(),
+		sha256: sha256.New(),
+	}
+}
+
+// writer returns a writer which feeds all digests
+func (d *artifactDigests) writer() io.Writer {
+	return io.MultiWriter(d.sha1, d.sha256)
+}
+
+func (d *artifactDigests) reset() {
+	d.sha1.Reset()
+	d.sha256.Reset()
+}
+
+func (d *artifactDigests) sha1Hex() string {
+	return hex.EncodeToString(d.sha1.Sum(nil))
+}
+
+func (d *artifactDigests) sha256Hex() string {
+	return hex.EncodeToString(d.sha256.Sum(nil))
+}
+
+// strongestChecksum returns the best checksum the API provided for the
+// artifact (lowercase) and its algorithm or empty strings if there is none
+func (artifact BuildkiteBuildArtifactInfo) strongestChecksum() (algorithm string, checksum string) {
+	if artifact.SHA256sum != "" {
+		return DigestSHA256, strings.ToLower(artifact.SHA256sum)
+	}
+	if artifact.SHA1sum != "" {
+		return DigestSHA1, strings.ToLower(artifact.SHA1sum)
+	}
+	return "", ""
+}
+
+// fileDigest calculates the hex encoded digest of the file at path
+func fileDigest(path string, algorithm string) (string, error) {
+	var h hash.Hash
+	switch algorithm {
+	case DigestSHA256:
+		h = sha256.New()
+	case DigestSHA1:
+		h = sha1.New()
+	default:
+		return "", fmt.Errorf("Unsupported digest algorithm %s", algorithm)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyDigest checks the digests calculated during the download against the
+// strongest checksum the API provided for the artifact. It returns the used
+// algorithm and the verified digest or empty strings if the API did not
+// provide any checksum
+func verifyDigest(artifact BuildkiteBuildArtifactInfo, digests *artifactDigests) (algorithm string, digest string, err error) {
+	algorithm, expected := artifact.strongestChecksum()
+	switch algorithm {
+	case DigestSHA256:
+		digest = digests.sha256Hex()
+	case DigestSHA1:
+		digest = digests.sha1Hex()
+	default:
+		return "", "", nil
+	}
+
+	if digest != expected {
+		return "", "", fmt.Errorf("%s mismatch for %s (expected %s, got %s)", algorithm, artifact.Filename, expected, digest)
+	}
+	return algorithm, digest, nil
+}
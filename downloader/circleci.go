@@ -0,0 +1,363 @@
+package buildkiteArtifactDownloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultCircleCIAPIURL is the API of circleci.com
+	DefaultCircleCIAPIURL = "https://circleci.com/api/v2"
+	// DefaultCircleCIVCS is the VCS of project slugs (GitHub)
+	DefaultCircleCIVCS = "gh"
+	// circleCIArtifactHost serves the artifacts of circleci.com
+	circleCIArtifactHost = "circle-artifacts.com"
+	// maxCircleCIPipelines bounds the pipelines searched for the latest
+	// successful one
+	maxCircleCIPipelines = 20
+	// circleCIWebURL is the web UI of circleci.com
+	circleCIWebURL = "https://app.circleci.com"
+)
+
+// circleCIProvider downloads the artifacts of CircleCI pipelines. The
+// organization and pipeline of the handler are the organization and
+// project of the project slug, the build ID is the pipeline number and the
+// jobs are the jobs of its workflows
+type circleCIProvider struct {
+	bd       *BuildkiteHandler
+	apiURL   string
+	vcs      string
+	workflow string
+	token    string
+}
+
+// circleCIPipeline is a pipeline of the CircleCI API
+type circleCIPipeline struct {
+	ID     string `json:"id"`
+	Number int    `json:"number"`
+	VCS    struct {
+		Revision string `json:"revision"`
+		Branch   string `json:"branch"`
+		Commit   struct {
+			Subject string `json:"subject"`
+			Body    string `json:"body"`
+		} `json:"commit"`
+	} `json:"vcs"`
+	Trigger struct {
+		Actor struct {
+			Login string `json:"login"`
+		} `json:"actor"`
+	} `json:"trigger"`
+}
+
+// message joins the subject and body of the commit of the pipeline
+func (pipeline circleCIPipeline) message() string {
+	if pipeline.VCS.Commit.Body == "" {
+		return pipeline.VCS.Commit.Subject
+	}
+	return pipeline.VCS.Commit.Subject + "\n\n" + pipeline.VCS.Commit.Body
+}
+
+// circleCIWorkflow is a workflow of a pipeline
+type circleCIWorkflow struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// circleCIJob is a job of a workflow. Approval jobs have no job number
+type circleCIJob struct {
+	JobNumber int    `json:"job_number"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+}
+
+// circleCIArtifact is an artifact of a job
+type circleCIArtifact struct {
+	Path      string `json:"path"`
+	NodeIndex int    `json:"node_index"`
+	URL       string `json:"url"`
+}
+
+// SetCircleCI downloads the artifacts of CircleCI pipelines instead of
+// Buildkite builds. The organization and pipeline are the organization and
+// project of the project slug, vcs its prefix (gh, bb or circleci).
+// workflow restricts the workflows whose jobs are considered. apiURL may
+// point to a CircleCI server installation
+func (bd *BuildkiteHandler) SetCircleCI(apiURL string, vcs string, workflow string, token string) error {
+	if token == "" {
+		return fmt.Errorf("Downloading CircleCI artifacts requires a token")
+	}
+	if apiURL == "" {
+		apiURL = DefaultCircleCIAPIURL
+	}
+	if _, err := url.Parse(apiURL); err != nil {
+		return fmt.Errorf("Invalid CircleCI API URL %s (%v)", apiURL, err)
+	}
+	if vcs == "" {
+		vcs = DefaultCircleCIVCS
+	}
+	bd.provider = &circleCIProvider{
+		bd:       bd,
+		apiURL:   strings.TrimSuffix(apiURL, "/"),
+		vcs:      vcs,
+		workflow: workflow,
+		token:    token,
+	}
+	return nil
+}
+
+func (p *circleCIProvider) projectURL() string {
+	return p.apiURL + "/project/" + p.vcs + "/" + url.PathEscape(p.bd.buildkiteOrg) + "/" + url.PathEscape(p.bd.buildkitePipeline)
+}
+
+// getItems fetches all pages of a listing of the API into items. The
+// listings are paginated with next_page_token instead of Link headers
+func (p *circleCIProvider) getItems(listURL string, items func(json.RawMessage) error) error {
+	separator := "?"
+	if strings.Contains(listURL, "?") {
+		separator = "&"
+	}
+	pageURL := listURL
+	for page := 1; page <= maxArtifactPages; page++ {
+		bodyBytes, err := p.bd.getData(pageURL)
+		if err != nil {
+			return err
+		}
+		var response struct {
+			Items         json.RawMessage `json:"items"`
+			NextPageToken string          `json:"next_page_token"`
+		}
+		if err := json.Unmarshal(bodyBytes, &response); err != nil {
+			return fmt.Errorf("Cannot parse response of CircleCI (%v)", err)
+		}
+		if err := items(response.Items); err != nil {
+			return fmt.Errorf("Cannot parse response of CircleCI (%v)", err)
+		}
+		if response.NextPageToken == "" {
+			return nil
+		}
+		pageURL = listURL + separator + "page-token=" + url.QueryEscape(response.NextPageToken)
+	}
+	return nil
+}
+
+// workflows returns the workflows of the pipeline which are considered
+func (p *circleCIProvider) workflows(pipelineID string) ([]circleCIWorkflow, error) {
+	var workflows []circleCIWorkflow
+	err := p.getItems(p.apiURL+"/pipeline/"+pipelineID+"/workflow", func(items json.RawMessage) error {
+		var page []circleCIWorkflow
+		if err := json.Unmarshal(items, &page); err != nil {
+			return err
+		}
+		for _, workflow := range page {
+			if p.workflow == "" || workflow.Name == p.workflow {
+				workflows = append(workflows, workflow)
+			}
+		}
+		return nil
+	})
+	return workflows, err
+}
+
+// circleCIState maps the status of workflows and jobs onto the states of
+// Buildkite builds
+func circleCIState(status string) string {
+	switch status {
+	case "success":
+		return "passed"
+	case "failed", "error", "failing", "infrastructure_fail", "timedout":
+		return "failed"
+	case "canceled":
+		return "canceled"
+	default:
+		return "running"
+	}
+}
+
+// circleCIPipelineState combines the states of the workflows
+func circleCIPipelineState(workflows []circleCIWorkflow) string {
+	if len(workflows) == 0 {
+		return "running"
+	}
+	state := "passed"
+	for _, workflow := range workflows {
+		switch circleCIState(workflow.Status) {
+		case "failed":
+			return "failed"
+		case "passed":
+		default:
+			state = circleCIState(workflow.Status)
+		}
+	}
+	return state
+}
+
+func (p *circleCIProvider) getLatestBuildID() (int, error) {
+	query := url.Values{}
+	query.Set("branch", p.bd.getBranch())
+	var pipelines []circleCIPipeline
+	bodyBytes, err := p.bd.getData(p.projectURL() + "/pipeline?" + query.Encode())
+	if err != nil {
+		return 0, err
+	}
+	var response struct {
+		Items []circleCIPipeline `json:"items"`
+	}
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return 0, fmt.Errorf("Cannot parse pipelines (%v)", err)
+	}
+	pipelines = response.Items
+	if len(pipelines) > maxCircleCIPipelines {
+		pipelines = pipelines[:maxCircleCIPipelines]
+	}
+	// pipelines are listed newest first, the latest one might still run
+	for _, pipeline := range pipelines {
+		if !p.bd.matchesCommit(pipeline.message(), pipeline.Trigger.Actor.Login) {
+			continue
+		}
+		workflows, err := p.workflows(pipeline.ID)
+		if err != nil {
+			return 0, err
+		}
+		if circleCIPipelineState(workflows) == "passed" {
+			return pipeline.Number, nil
+		}
+	}
+	return 0, fmt.Errorf("No matching successful pipeline on branch %s", p.bd.getBranch())
+}
+
+func (p *circleCIProvider) getDefaultBranch() (string, error) {
+	bodyBytes, err := p.bd.getData(p.projectURL())
+	if err != nil {
+		return "", err
+	}
+	var project struct {
+		VCSInfo struct {
+			DefaultBranch string `json:"default_branch"`
+		} `json:"vcs_info"`
+	}
+	if err := json.Unmarshal(bodyBytes, &project); err != nil {
+		return "", fmt.Errorf("Cannot parse project (%v)", err)
+	}
+	if project.VCSInfo.DefaultBranch == "" {
+		return "", fmt.Errorf("Project has no default branch")
+	}
+	return project.VCSInfo.DefaultBranch, nil
+}
+
+// pipelineURL links the pipeline number in the web UI. Server
+// installations serve it on the host of the API
+func (p *circleCIProvider) pipelineURL(number int) string {
+	webURL := circleCIWebURL
+	if p.apiURL != DefaultCircleCIAPIURL {
+		webURL = strings.TrimSuffix(p.apiURL, "/api/v2")
+	}
+	vcs := p.vcs
+	switch vcs {
+	case "gh":
+		vcs = "github"
+	case "bb":
+		vcs = "bitbucket"
+	}
+	return webURL + "/pipelines/" + vcs + "/" + url.PathEscape(p.bd.buildkiteOrg) + "/" + url.PathEscape(p.bd.buildkitePipeline) + "/" + strconv.Itoa(number)
+}
+
+func (p *circleCIProvider) getBuildInfo() (*BuildkiteBuildInfo, error) {
+	bodyBytes, err := p.bd.getData(p.projectURL() + "/pipeline/" + strconv.Itoa(p.bd.buildID))
+	if err != nil {
+		return nil, err
+	}
+	var pipeline circleCIPipeline
+	if err := json.Unmarshal(bodyBytes, &pipeline); err != nil {
+		return nil, fmt.Errorf("Cannot parse pipeline (%v)", err)
+	}
+	workflows, err := p.workflows(pipeline.ID)
+	if err != nil {
+		return nil, err
+	}
+	buildInfo := &BuildkiteBuildInfo{
+		State:    circleCIPipelineState(workflows),
+		CommitID: pipeline.VCS.Revision,
+		Branch:   pipeline.VCS.Branch,
+		Number:   pipeline.Number,
+		Message:  pipeline.message(),
+		Author:   BuildkiteBuildAuthor{Username: pipeline.Trigger.Actor.Login},
+		WebURL:   p.pipelineURL(pipeline.Number),
+	}
+	for _, workflow := range workflows {
+		err := p.getItems(p.apiURL+"/workflow/"+workflow.ID+"/job", func(items json.RawMessage) error {
+			var jobs []circleCIJob
+			if err := json.Unmarshal(items, &jobs); err != nil {
+				return err
+			}
+			for _, job := range jobs {
+				if job.JobNumber == 0 {
+					continue
+				}
+				buildInfo.Jobs = append(buildInfo.Jobs, BuildkiteBuildJobInfo{
+					ID:      strconv.Itoa(job.JobNumber),
+					Name:    job.Name,
+					StepKey: workflow.Name,
+					State:   circleCIState(job.Status),
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buildInfo, nil
+}
+
+func (p *circleCIProvider) getArtifactInfo(jobID string) ([]BuildkiteBuildArtifactInfo, error) {
+	result := []BuildkiteBuildArtifactInfo{}
+	err := p.getItems(p.projectURL()+"/"+jobID+"/artifacts", func(items json.RawMessage) error {
+		var artifacts []circleCIArtifact
+		if err := json.Unmarshal(items, &artifacts); err != nil {
+			return err
+		}
+		for _, artifact := range artifacts {
+			// CircleCI neither reports sizes nor checksums
+			result = append(result, BuildkiteBuildArtifactInfo{
+				Filename: path.Base(artifact.Path),
+				Path:     artifact.Path,
+				URL:      artifact.URL,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"buildID":   p.bd.buildID,
+		"jobID":     jobID,
+		"artifacts": len(result),
+	}).Debug("Listed CircleCI artifacts")
+	return result, nil
+}
+
+// getJobLog fails as the API of CircleCI does not serve the output of jobs
+func (p *circleCIProvider) getJobLog(jobID string) ([]byte, error) {
+	return nil, fmt.Errorf("CircleCI does not provide job logs via its API")
+}
+
+// authorize adds the token to requests for the API and the artifact host
+// (artifacts of private projects require it)
+func (p *circleCIProvider) authorize(req *http.Request) {
+	if strings.HasPrefix(req.URL.String(), p.apiURL+"/") ||
+		req.URL.Host == circleCIArtifactHost || strings.HasSuffix(req.URL.Host, "."+circleCIArtifactHost) {
+		req.Header.Set("Circle-Token", p.token)
+	}
+}
+
+func (p *circleCIProvider) cleanup() {}
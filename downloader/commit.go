@@ -0,0 +1,79 @@
+package buildkiteArtifactDownloader
+
+import (
+	"regexp"
+)
+
+// maxCandidateBuilds bounds the builds searched for the latest one matching
+// the commit filters
+const maxCandidateBuilds = 50
+
+// BuildkiteBuildAuthor is the author of the commit of a build
+type BuildkiteBuildAuthor struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+}
+
+// SetMessageFilter only resolves the latest build to builds whose commit
+// message matches messageFilter (e.g. ^Release). An empty filter deletes it
+func (bd *BuildkiteHandler) SetMessageFilter(messageFilter string) error {
+	if messageFilter == "" {
+		bd.messageFilter = nil
+		return nil
+	}
+	reMessageFilter, err := regexp.Compile(messageFilter)
+	if err != nil {
+		return err
+	}
+	bd.messageFilter = reMessageFilter
+	return nil
+}
+
+// SetAuthorFilter only resolves the latest build to builds whose commit
+// author matches authorFilter. It is matched against the name, email and
+// user name of the author. An empty filter deletes it
+func (bd *BuildkiteHandler) SetAuthorFilter(authorFilter string) error {
+	if authorFilter == "" {
+		bd.authorFilter = nil
+		return nil
+	}
+	reAuthorFilter, err := regexp.Compile(authorFilter)
+	if err != nil {
+		return err
+	}
+	bd.authorFilter = reAuthorFilter
+	return nil
+}
+
+// hasCommitFilters reports if candidate builds have to be checked against
+// their commit when resolving the latest build
+func (bd *BuildkiteHandler) hasCommitFilters() bool {
+	return bd.messageFilter != nil || bd.authorFilter != nil
+}
+
+// candidateBuilds is the count of builds to list when resolving the latest
+// build. Only the newest one is needed without commit filters
+func (bd *BuildkiteHandler) candidateBuilds() int {
+	if bd.hasCommitFilters() {
+		return maxCandidateBuilds
+	}
+	return 1
+}
+
+// matchesCommit reports if message matches the message filter and any of
+// authors (name, email or user name) matches the author filter
+func (bd *BuildkiteHandler) matchesCommit(message string, authors ...string) bool {
+	if bd.messageFilter != nil && !bd.messageFilter.MatchString(message) {
+		return false
+	}
+	if bd.authorFilter == nil {
+		return true
+	}
+	for _, author := range authors {
+		if author != "" && bd.authorFilter.MatchString(author) {
+			return true
+		}
+	}
+	return false
+}
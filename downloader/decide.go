@@ -0,0 +1,131 @@
+package buildkiteArtifactDownloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ArtifactCandidate describes an artifact which is about to be downloaded
+type ArtifactCandidate struct {
+	Org         string `json:"org"`
+	Pipeline    string `json:"pipeline"`
+	BuildNumber int    `json:"buildNumber"`
+	CommitID    string `json:"commitID"`
+	Branch      string `json:"branch"`
+	JobID       string `json:"jobID"`
+	JobName     string `json:"jobName"`
+	StepKey     string `json:"stepKey,omitempty"`
+	Filename    string `json:"filename"`
+	Path        string `json:"path"`
+	MimeType    string `json:"mimeType,omitempty"`
+	Size        int64  `json:"size"`
+	SHA1        string `json:"sha1,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+}
+
+// ArtifactDecider decides whether an artifact gets downloaded. Errors mark
+// the artifact as failed
+type ArtifactDecider func(candidate ArtifactCandidate) (bool, error)
+
+// SetArtifactDecider sets a callback which can veto artifacts after all
+// filters are applied (nil removes it)
+func (bd *BuildkiteHandler) SetArtifactDecider(decider ArtifactDecider) {
+	bd.decider = decider
+}
+
+// SetPreDownloadHook vetoes artifacts with a shell command. It gets the
+// ArtifactCandidate as JSON on stdin; exit code 0 downloads the artifact,
+// any other exit code skips it
+func (bd *BuildkiteHandler) SetPreDownloadHook(command string) {
+	if command == "" {
+		bd.decider = nil
+		return
+	}
+	bd.decider = func(candidate ArtifactCandidate) (bool, error) {
+		input, err := json.Marshal(candidate)
+		if err != nil {
+			return false, err
+		}
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Stdin = bytes.NewReader(input)
+		output, err := cmd.CombinedOutput()
+		if len(output) > 0 {
+			log.WithFields(log.Fields{
+				"hook":             command,
+				"artifactFilename": candidate.Filename,
+			}).Info(strings.TrimSpace(string(output)))
+		}
+		if _, ok := err.(*exec.ExitError); ok {
+			return false, nil
+		} else if err != nil {
+			return false, fmt.Errorf("Hook '%s' failed (%v)", command, err)
+		}
+		return true, nil
+	}
+}
+
+// candidate describes the artifact for the decider
+func (bd *BuildkiteHandler) candidate(artifact BuildkiteBuildArtifactInfo) ArtifactCandidate {
+	candidate := ArtifactCandidate{
+		Org:         bd.buildkiteOrg,
+		Pipeline:    bd.buildkitePipeline,
+		BuildNumber: bd.buildID,
+		JobID:       artifact.job.ID,
+		JobName:     artifact.job.Name,
+		StepKey:     artifact.job.StepKey,
+		Filename:    artifact.Filename,
+		Path:        artifact.fullPath(),
+		MimeType:    artifact.MimeType,
+		Size:        artifact.FileSize,
+		SHA1:        artifact.SHA1sum,
+		SHA256:      artifact.SHA256sum,
+	}
+	if bd.buildInfo != nil {
+		candidate.CommitID = bd.buildInfo.CommitID
+		candidate.Branch = bd.buildInfo.Branch
+	}
+	return candidate
+}
+
+// decideArtifacts returns the artifacts the decider accepts. Vetoed
+// artifacts are reported as skipped, artifacts the decider failed for as
+// failed
+func (bd *BuildkiteHandler) decideArtifacts(artifacts []BuildkiteBuildArtifactInfo) []BuildkiteBuildArtifactInfo {
+	if bd.decider == nil {
+		return artifacts
+	}
+	var accepted []BuildkiteBuildArtifactInfo
+	for _, artifact := range artifacts {
+		download, err := bd.decider(bd.candidate(artifact))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"buildID":          bd.buildID,
+				"artifactFilename": artifact.Filename,
+				"error":            err,
+			}).Warn("Cannot decide whether to download artifact")
+			bd.failed = append(bd.failed, ArtifactOutcome{
+				Filename: artifact.Filename,
+				Reason:   err.Error(),
+			})
+			continue
+		}
+		if !download {
+			log.WithFields(log.Fields{
+				"buildID":          bd.buildID,
+				"artifactFilename": artifact.Filename,
+			}).Info("Skip artifact because it was vetoed")
+			bd.skipped = append(bd.skipped, ArtifactOutcome{
+				Filename: artifact.Filename,
+				Reason:   "Vetoed by pre-download hook",
+			})
+			continue
+		}
+		accepted = append(accepted, artifact)
+	}
+	return accepted
+}
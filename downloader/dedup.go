@@ -0,0 +1,63 @@
+package buildkiteArtifactDownloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SetHardlinkDuplicates hardlinks downloads which are identical to the
+// download of a previous build instead of storing a second copy
+func (bd *BuildkiteHandler) SetHardlinkDuplicates(enabled bool) {
+	bd.hardlinkDuplicates = enabled
+}
+
+// findDuplicate searches the destinations of previous builds for a file with
+// the given size and SHA-256 checksum. It returns an empty string if there
+// is none
+func (bd *BuildkiteHandler) findDuplicate(destPath string, size int64, sha256sum string) string {
+	reDestination, root, _, err := bd.destinationMatcher()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"buildID": bd.buildID,
+			"error":   err,
+		}).Debug("Cannot search for duplicates")
+		return ""
+	}
+	destInfo, _ := os.Stat(destPath)
+
+	duplicate := ""
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || duplicate != "" {
+			return nil
+		}
+		if !info.Mode().IsRegular() || info.Size() != size || !reDestination.MatchString(path) {
+			return nil
+		}
+		if destInfo != nil && os.SameFile(info, destInfo) {
+			return nil
+		}
+		if digest, err := fileDigest(path, DigestSHA256); err == nil && digest == sha256sum {
+			duplicate = path
+		}
+		return nil
+	})
+	return duplicate
+}
+
+// linkFile hardlinks source to destPath. An existing destination gets
+// replaced atomically
+func linkFile(source string, destPath string) error {
+	tmpDir, err := ioutil.TempDir(filepath.Dir(destPath), "."+filepath.Base(destPath)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	tmpLink := filepath.Join(tmpDir, "link")
+	if err := os.Link(source, tmpLink); err != nil {
+		return err
+	}
+	return os.Rename(tmpLink, destPath)
+}
@@ -0,0 +1,220 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	common "github.com/krombel/buildkite-artifact-downloader/common"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultDateLayout is used for <date> without an explicit layout
+	defaultDateLayout = "2006-01-02"
+	// defaultCommitLength is used for <commitID> without an explicit length
+	defaultCommitLength = 8
+)
+
+// apkPlaceholders can only be resolved after the APK got downloaded
+var apkPlaceholders = []string{
+	`<apkPackage>`,
+	`<apkVersionName>`,
+	`<apkVersionCode>`,
+	`<apkAbi>`,
+}
+
+// reDatePlaceholder matches <date> and <date:layout> with a Go time layout
+var reDatePlaceholder = regexp.MustCompile(`<date(?::([^>]+))?>`)
+
+// reCommitPlaceholder matches <commitID> and <commitID:length>
+var reCommitPlaceholder = regexp.MustCompile(`<commitID(?::([0-9]+))?>`)
+
+// shortCommit truncates the commit ID to length characters. Missing commit
+// IDs (e.g. of builds which are still scheduled) are returned as "unknown"
+func shortCommit(commitID string, length int) string {
+	if commitID == "" {
+		return "unknown"
+	}
+	if length > 0 && len(commitID) > length {
+		return commitID[:length]
+	}
+	return commitID
+}
+
+// reUnsafePathChars matches characters which are replaced when values like
+// job names or branches are used as part of a path
+var reUnsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sanitizePathComponent makes value safe to be used as a single path
+// component (e.g. "feature/foo" becomes "feature_foo")
+func sanitizePathComponent(value string) string {
+	value = strings.Trim(reUnsafePathChars.ReplaceAllString(value, "_"), "_.")
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
+// destinationData is passed to destination templates
+type destinationData struct {
+	Org      string
+	Pipeline string
+	BuildID  int
+	Build    BuildkiteBuildInfo
+	Job      BuildkiteBuildJobInfo
+	Artifact BuildkiteBuildArtifactInfo
+	// APK is nil for artifacts which are no APKs
+	APK  *common.APKInfo
+	Date time.Time
+}
+
+// destinationFuncs are available in destination templates
+var destinationFuncs = template.FuncMap{
+	// short truncates value to length characters (8 by default)
+	"short": func(value string, length ...int) string {
+		n := 8
+		if len(length) > 0 {
+			n = length[0]
+		}
+		if len(value) > n {
+			return value[:n]
+		}
+		return value
+	},
+	"lower":    strings.ToLower,
+	"sanitize": sanitizePathComponent,
+}
+
+// parseDestinationTemplate parses patterns which use the template syntax.
+// It returns nil for patterns which only use the legacy placeholders
+func parseDestinationTemplate(pattern string) (*template.Template, error) {
+	if !strings.Contains(pattern, "{{") {
+		return nil, nil
+	}
+	destTemplate, err := template.New("dest").Funcs(destinationFuncs).Option("missingkey=error").Parse(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse destination template (%v)", err)
+	}
+	return destTemplate, nil
+}
+
+func (bd *BuildkiteHandler) getDestinationPattern() string {
+	if bd.destPattern != "" {
+		return bd.destPattern
+	}
+	return DefaultDestinationPattern
+}
+
+// destinationNeedsAPKInfo reports if the destination pattern contains
+// placeholders which are filled from the APK metadata
+func (bd *BuildkiteHandler) destinationNeedsAPKInfo() bool {
+	if bd.mirror {
+		return false
+	}
+	pattern := bd.getDestinationPattern()
+	if bd.destTemplate != nil && strings.Contains(pattern, ".APK") {
+		return true
+	}
+	for _, placeholder := range apkPlaceholders {
+		if strings.Contains(pattern, placeholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// getDestinationPath resolves the destination pattern for artifact. A
+// template gets executed first, afterwards the legacy placeholders are
+// replaced. The APK placeholders are replaced with "unknown" when apkInfo is
+// nil
+func (bd *BuildkiteHandler) getDestinationPath(buildInfo BuildkiteBuildInfo, artifact BuildkiteBuildArtifactInfo, apkInfo *common.APKInfo) (string, error) {
+	if bd.mirror {
+		return bd.getMirrorPath(buildInfo, artifact)
+	}
+	var output = bd.getDestinationPattern()
+
+	if bd.destTemplate != nil {
+		var rendered strings.Builder
+		err := bd.destTemplate.Execute(&rendered, destinationData{
+			Org:      bd.buildkiteOrg,
+			Pipeline: bd.buildkitePipeline,
+			BuildID:  bd.buildID,
+			Build:    buildInfo,
+			Job:      artifact.job,
+			Artifact: artifact,
+			APK:      apkInfo,
+			Date:     bd.startTime,
+		})
+		if err != nil {
+			return "", fmt.Errorf("Cannot execute destination template for %s (%v)", artifact.Filename, err)
+		}
+		output = rendered.String()
+	}
+
+	log.WithFields(log.Fields{
+		"destPattern":      output,
+		"buildID":          bd.buildID,
+		"commit":           shortCommit(buildInfo.CommitID, defaultCommitLength),
+		"artifactFilename": artifact.Filename,
+	}).Info("getDestinationPath")
+
+	buildNumber := buildInfo.Number
+	if buildNumber == 0 {
+		buildNumber = bd.buildID
+	}
+	output = strings.NewReplacer(
+		`<buildID>`, strconv.Itoa(bd.buildID),
+		`<buildNumber>`, strconv.Itoa(buildNumber),
+		`<commitFull>`, shortCommit(buildInfo.CommitID, 0),
+		`<artifactFilename>`, artifact.Filename,
+		`<jobName>`, sanitizePathComponent(artifact.job.Name),
+		`<branch>`, sanitizePathComponent(buildInfo.Branch),
+		`<pipeline>`, bd.buildkitePipeline,
+		`<org>`, bd.buildkiteOrg,
+	).Replace(output)
+	output = replaceMetaData(output, buildInfo.MetaData)
+
+	output = reCommitPlaceholder.ReplaceAllStringFunc(output, func(placeholder string) string {
+		length := defaultCommitLength
+		if match := reCommitPlaceholder.FindStringSubmatch(placeholder)[1]; match != "" {
+			length, _ = strconv.Atoi(match)
+		}
+		return shortCommit(buildInfo.CommitID, length)
+	})
+
+	output = reDatePlaceholder.ReplaceAllStringFunc(output, func(placeholder string) string {
+		layout := reDatePlaceholder.FindStringSubmatch(placeholder)[1]
+		if layout == "" {
+			layout = defaultDateLayout
+		}
+		return bd.startTime.Format(layout)
+	})
+
+	apkValues := []string{"unknown", "unknown", "unknown", "unknown"}
+	if apkInfo != nil {
+		apkValues = []string{
+			apkInfo.PackageName,
+			apkInfo.VersionName,
+			strconv.FormatInt(apkInfo.VersionCode, 10),
+			apkInfo.ABI(),
+		}
+	}
+	for i, placeholder := range apkPlaceholders {
+		output = strings.ReplaceAll(output, placeholder, apkValues[i])
+	}
+
+	if bd.decompresses(artifact) {
+		output = strings.TrimSuffix(output, ".gz")
+	}
+
+	log.WithFields(log.Fields{
+		"output":  output,
+		"buildID": bd.buildID,
+	}).Info("ReplaceString end")
+
+	return output, nil
+}
@@ -0,0 +1,86 @@
+package buildkiteArtifactDownloader
+
+import (
+	"testing"
+	"time"
+
+	common "github.com/krombel/buildkite-artifact-downloader/common"
+)
+
+func TestGetDestinationPath(t *testing.T) {
+	buildInfo := BuildkiteBuildInfo{
+		CommitID: "abcdef1234567890",
+		Branch:   "feature/Login",
+		Number:   42,
+		MetaData: map[string]string{"flavor": "F-Droid"},
+	}
+	artifact := BuildkiteBuildArtifactInfo{
+		Filename: "app.apk",
+		Path:     "outputs/app.apk",
+		job:      BuildkiteBuildJobInfo{ID: "j1", Name: "Build APK"},
+	}
+	apk := &common.APKInfo{PackageName: "im.vector.app", VersionName: "1.2.3", VersionCode: 10203, ABIs: []string{"arm64-v8a"}}
+
+	tests := []struct {
+		pattern string
+		apk     *common.APKInfo
+		want    string
+		fails   bool
+	}{
+		{"dl/<buildID>-<commitID>-<artifactFilename>", nil, "dl/42-abcdef12-app.apk", false},
+		{"dl/<commitID:4>/<commitFull>/<buildNumber>", nil, "dl/abcd/abcdef1234567890/42", false},
+		{"dl/<org>/<pipeline>/<branch>/<jobName>/<date>/<date:200601>", nil, "dl/org/pipe/feature_Login/Build_APK/2024-06-10/202406", false},
+		{"dl/<apkPackage>-<apkVersionName>-<apkVersionCode>-<apkAbi>.apk", apk, "dl/im.vector.app-1.2.3-10203-arm64-v8a.apk", false},
+		{"dl/<apkPackage>-<apkVersionCode>.apk", nil, "dl/unknown-unknown.apk", false},
+		{"dl/<meta:flavor>/<artifactFilename>", nil, "dl/F-Droid/app.apk", false},
+		{"dl/{{.Pipeline}}/{{short .Build.CommitID 12}}/{{.Artifact.Filename}}", nil, "dl/pipe/abcdef123456/app.apk", false},
+		{"dl/{{short .Build.CommitID}}/{{lower .Org}}-{{sanitize .Build.Branch}}", nil, "dl/abcdef12/org-feature_Login", false},
+		{"dl/{{.Date.Format \"2006\"}}/{{.Job.Name | sanitize}}/{{index .Build.MetaData \"flavor\"}}", nil, "dl/2024/Build_APK/F-Droid", false},
+		{"dl/{{.APK.PackageName}}/{{.APK.ABI}}.apk", apk, "dl/im.vector.app/arm64-v8a.apk", false},
+		// templates and legacy placeholders can be mixed
+		{"dl/{{.BuildID}}-<commitID:6>/<artifactFilename>", nil, "dl/42-abcdef/app.apk", false},
+		{"dl/{{if .APK}}{{.APK.VersionCode}}{{else}}none{{end}}", nil, "dl/none", false},
+		// APK values are missing until the APK got downloaded
+		{"dl/{{.APK.PackageName}}.apk", nil, "", true},
+		{"dl/{{.Unknown}}", nil, "", true},
+	}
+	for _, test := range tests {
+		bd := NewBuildkiteHandler("org", "pipe")
+		if err := bd.SetDestinationPattern(test.pattern); err != nil {
+			t.Fatalf("%s: %v", test.pattern, err)
+		}
+		bd.SetBuildID(42)
+		bd.startTime = time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+		got, err := bd.getDestinationPath(buildInfo, artifact, test.apk)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: got %s, want an error", test.pattern, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.pattern, err)
+		} else if got != test.want {
+			t.Errorf("%s: got %s, want %s", test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestParseDestinationTemplate(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		template bool
+		fails    bool
+	}{
+		{"dl/<buildID>-<artifactFilename>", false, false},
+		{"dl/{{.BuildID}}", true, false},
+		{"dl/{{.BuildID}", false, true},
+		{"dl/{{unknownFunc .BuildID}}", false, true},
+	}
+	for _, test := range tests {
+		destTemplate, err := parseDestinationTemplate(test.pattern)
+		if (err != nil) != test.fails || (destTemplate != nil) != test.template {
+			t.Errorf("%s: got template %v and error %v", test.pattern, destTemplate != nil, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// existingParent returns path or its nearest parent which exists
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// checkDiskSpace compares the sizes reported by the API with the free space
+// of the destination directories and the temp directory. Artifacts whose
+// destination exists already are not counted as they replace a file
+func (bd *BuildkiteHandler) checkDiskSpace(buildInfo BuildkiteBuildInfo, artifacts []BuildkiteBuildArtifactInfo) error {
+	required := make(map[string]int64)
+	var largest int64
+	for _, artifact := range artifacts {
+		if artifact.FileSize > largest {
+			largest = artifact.FileSize
+		}
+		destPath, err := bd.getDestinationPath(buildInfo, artifact, nil)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(destPath); err == nil {
+			continue
+		}
+		dir := existingParent(filepath.Dir(destPath))
+		required[dir] += artifact.FileSize
+	}
+	// artifacts are downloaded one after another so the temp directory has
+	// to hold the largest one only
+	tmpDir := os.TempDir()
+	if required[tmpDir] < largest {
+		required[tmpDir] = largest
+	}
+
+	for dir, size := range required {
+		free, err := freeSpace(dir)
+		if err != nil || free < 0 {
+			log.WithFields(log.Fields{
+				"buildID":   bd.buildID,
+				"directory": dir,
+				"error":     err,
+			}).Debug("Cannot determine free disk space")
+			continue
+		}
+		if size > free {
+			return fmt.Errorf("Not enough disk space in %s (%d bytes required, %d bytes available)", dir, size, free)
+		}
+	}
+	return nil
+}
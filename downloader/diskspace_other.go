@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package buildkiteArtifactDownloader
+
+// freeSpace cannot be determined on this platform. -1 skips the check
+func freeSpace(path string) (int64, error) {
+	return -1, nil
+}
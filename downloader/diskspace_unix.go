@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package buildkiteArtifactDownloader
+
+import (
+	"syscall"
+)
+
+// freeSpace returns the bytes available to unprivileged users on the file
+// system of path
+func freeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return -1, err
+	}
+	return int64(uint64(stat.Bavail) * uint64(stat.Bsize)), nil
+}
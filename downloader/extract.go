@@ -0,0 +1,186 @@
+package buildkiteArtifactDownloader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SetExtractArchives unpacks downloaded .zip, .tar.gz and .tgz artifacts
+// into the directory they got downloaded to
+func (bd *BuildkiteHandler) SetExtractArchives(enabled bool) {
+	bd.extractArchives = enabled
+}
+
+// isArchive reports if the file can be extracted
+func isArchive(filename string) bool {
+	return strings.HasSuffix(filename, ".zip") ||
+		strings.HasSuffix(filename, ".tar.gz") ||
+		strings.HasSuffix(filename, ".tgz")
+}
+
+// SetDecompressGzip decompresses single .gz artifacts (not .tar.gz) while
+// downloading them. The .gz suffix gets removed from the destination
+func (bd *BuildkiteHandler) SetDecompressGzip(enabled bool) {
+	bd.decompressGzip = enabled
+}
+
+// decompresses reports if the artifact gets decompressed while downloading
+func (bd *BuildkiteHandler) decompresses(artifact BuildkiteBuildArtifactInfo) bool {
+	return bd.decompressGzip && !bd.mirror &&
+		strings.HasSuffix(artifact.Filename, ".gz") &&
+		!strings.HasSuffix(artifact.Filename, ".tar.gz")
+}
+
+// extractArchive unpacks the archive at path into its directory and returns
+// the paths of the extracted files
+func (bd *BuildkiteHandler) extractArchive(path string) ([]string, error) {
+	dir := filepath.Dir(path)
+	var extracted []string
+	var err error
+	if strings.HasSuffix(path, ".zip") {
+		extracted, err = bd.extractZip(path, dir)
+	} else {
+		extracted, err = bd.extractTarGz(path, dir)
+	}
+	if err != nil {
+		return extracted, fmt.Errorf("Cannot extract %s (%v)", path, err)
+	}
+	log.WithFields(log.Fields{
+		"buildID": bd.buildID,
+		"archive": path,
+		"files":   len(extracted),
+	}).Info("Archive extracted")
+	return extracted, nil
+}
+
+// extractionTarget resolves name inside of dir and refuses names which
+// would escape it (zip slip)
+func extractionTarget(dir string, name string) (string, error) {
+	target := filepath.Join(dir, name)
+	rel, err := filepath.Rel(dir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("Illegal path %s in archive", name)
+	}
+	return target, nil
+}
+
+// writeExtractedFile creates target (and its parents) with the content of r.
+// Existing files are never overwritten
+func (bd *BuildkiteHandler) writeExtractedFile(target string, r io.Reader) error {
+	if err := bd.makeDirs(filepath.Dir(target)); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, bd.fileMode)
+	if err != nil {
+		return err
+	}
+	// the mode of OpenFile is subject to the umask
+	if err := out.Chmod(bd.fileMode); err != nil {
+		out.Close()
+		os.Remove(target)
+		return err
+	}
+	if err := bd.chown(target); err != nil {
+		out.Close()
+		os.Remove(target)
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(target)
+		return err
+	}
+	return out.Close()
+}
+
+func (bd *BuildkiteHandler) extractZip(path string, dir string) ([]string, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	var extracted []string
+	for _, file := range archive.File {
+		target, err := extractionTarget(dir, file.Name)
+		if err != nil {
+			return extracted, err
+		}
+		if file.FileInfo().IsDir() {
+			if err := bd.makeDirs(target); err != nil {
+				return extracted, err
+			}
+			continue
+		}
+		if !file.Mode().IsRegular() {
+			log.WithFields(log.Fields{
+				"archive": path,
+				"entry":   file.Name,
+			}).Warn("Skip entry which is no regular file")
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return extracted, err
+		}
+		err = bd.writeExtractedFile(target, reader)
+		reader.Close()
+		if err != nil {
+			return extracted, err
+		}
+		extracted = append(extracted, target)
+	}
+	return extracted, nil
+}
+
+func (bd *BuildkiteHandler) extractTarGz(path string, dir string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReader.Close()
+
+	var extracted []string
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return extracted, nil
+		} else if err != nil {
+			return extracted, err
+		}
+		target, err := extractionTarget(dir, header.Name)
+		if err != nil {
+			return extracted, err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := bd.makeDirs(target); err != nil {
+				return extracted, err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := bd.writeExtractedFile(target, tarReader); err != nil {
+				return extracted, err
+			}
+			extracted = append(extracted, target)
+		default:
+			log.WithFields(log.Fields{
+				"archive": path,
+				"entry":   header.Name,
+			}).Warn("Skip entry which is no regular file")
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package buildkiteArtifactDownloader
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractionTarget(t *testing.T) {
+	dir := filepath.Join("srv", "repo")
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"app.apk", filepath.Join(dir, "app.apk")},
+		{"apks/app.apk", filepath.Join(dir, "apks", "app.apk")},
+		{"apks/../app.apk", filepath.Join(dir, "app.apk")},
+		{"./app.apk", filepath.Join(dir, "app.apk")},
+		// absolute names stay inside of the directory
+		{"/etc/passwd", filepath.Join(dir, "etc", "passwd")},
+		{"..", ""},
+		{"../app.apk", ""},
+		{"apks/../../app.apk", ""},
+		{"../repo-evil/app.apk", ""},
+		{"/../../etc/passwd", ""},
+	}
+	for _, test := range tests {
+		target, err := extractionTarget(dir, test.name)
+		if test.target == "" {
+			if err == nil {
+				t.Errorf("%q escapes %s as %s", test.name, dir, target)
+			}
+			continue
+		}
+		if err != nil || target != test.target {
+			t.Errorf("%q: got %q (%v), want %q", test.name, target, err, test.target)
+		}
+	}
+}
+
+// tarGzArchive returns a gzipped tar archive with the given files
+func tarGzArchive(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	w := tar.NewWriter(gzipWriter)
+	for name, content := range files {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		w.Write(content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractArchiveRefusesTraversal(t *testing.T) {
+	tests := []struct {
+		archive string
+		entry   string
+		escapes bool
+	}{
+		{"ok.zip", "apks/app.apk", false},
+		{"ok.tar.gz", "apks/app.apk", false},
+		{"evil.zip", "../escape.txt", true},
+		{"evil.tgz", "../escape.txt", true},
+		{"nested.tar.gz", "apks/../../escape.txt", true},
+	}
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "extract-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		// keep escaping files inside of the temporary directory
+		archiveDir := filepath.Join(dir, "archive")
+		if err := os.Mkdir(archiveDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		files := map[string][]byte{test.entry: []byte("content")}
+		content := zipArchive(t, files)
+		if filepath.Ext(test.archive) != ".zip" {
+			content = tarGzArchive(t, files)
+		}
+		path := filepath.Join(archiveDir, test.archive)
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		bd := NewBuildkiteHandler("org", "pipe")
+		extracted, err := bd.extractArchive(path)
+		if _, statErr := os.Stat(filepath.Join(dir, "escape.txt")); statErr == nil {
+			t.Errorf("%s: %s got written outside of the archive directory", test.archive, test.entry)
+		}
+		if test.escapes {
+			if err == nil || len(extracted) != 0 {
+				t.Errorf("%s: %s got extracted to %v", test.archive, test.entry, extracted)
+			}
+			continue
+		}
+		want := filepath.Join(archiveDir, test.entry)
+		if err != nil || len(extracted) != 1 || extracted[0] != want {
+			t.Errorf("%s: got %v (%v), want %s", test.archive, extracted, err, want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package buildkiteArtifactDownloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultGitHubAPIURL is the API of github.com
+	DefaultGitHubAPIURL = "https://api.github.com"
+	// localFileScheme is the scheme of artifacts which got unpacked locally
+	localFileScheme = "file"
+)
+
+// githubProvider downloads the artifacts of GitHub Actions workflow runs.
+// The organization and pipeline of the handler are the owner and name of
+// the repository, a workflow run is a build with a single job. GitHub
+// serves every artifact as zip archive which gets unpacked
+type githubProvider struct {
+	*unpackedArtifacts
+	bd       *BuildkiteHandler
+	apiURL   string
+	workflow string
+	token    string
+}
+
+// githubRun is the part of a workflow run of the GitHub API which is used
+type githubRun struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	RunNumber  int    `json:"run_number"`
+	HeadSHA    string `json:"head_sha"`
+	HeadBranch string `json:"head_branch"`
+	Status     string `json:"status"`
+	Conclusion string `json:"conclusion"`
+	HTMLURL    string `json:"html_url"`
+	HeadCommit struct {
+		Message string `json:"message"`
+		Author  struct {
+			Name  string `json:"name"`
+			Email string `json:"email"`
+		} `json:"author"`
+	} `json:"head_commit"`
+	Actor struct {
+		Login string `json:"login"`
+	} `json:"actor"`
+}
+
+// githubArtifact is an artifact of a workflow run
+type githubArtifact struct {
+	ID                 int    `json:"id"`
+	Name               string `json:"name"`
+	ArchiveDownloadURL string `json:"archive_download_url"`
+	SizeInBytes        int64  `json:"size_in_bytes"`
+	Expired            bool   `json:"expired"`
+}
+
+// SetGitHubActions downloads the artifacts of GitHub Actions workflow runs
+// instead of Buildkite builds. The organization and pipeline are the owner
+// and name of the repository. workflow (its file name or ID) restricts the
+// runs the latest one is picked from. token needs read access to the
+// actions of the repository. apiURL may point to a GitHub Enterprise server
+func (bd *BuildkiteHandler) SetGitHubActions(apiURL string, workflow string, token string) error {
+	if token == "" {
+		return fmt.Errorf("Downloading GitHub artifacts requires a token")
+	}
+	if apiURL == "" {
+		apiURL = DefaultGitHubAPIURL
+	}
+	if _, err := url.Parse(apiURL); err != nil {
+		return fmt.Errorf("Invalid GitHub API URL %s (%v)", apiURL, err)
+	}
+	bd.provider = &githubProvider{
+		unpackedArtifacts: newUnpackedArtifacts(bd),
+		bd:                bd,
+		apiURL:            strings.TrimSuffix(apiURL, "/"),
+		workflow:          workflow,
+		token:             token,
+	}
+	return nil
+}
+
+func (p *githubProvider) repoURL() string {
+	return p.apiURL + "/repos/" + url.PathEscape(p.bd.buildkiteOrg) + "/" + url.PathEscape(p.bd.buildkitePipeline)
+}
+
+func (p *githubProvider) getLatestBuildID() (int, error) {
+	runsURL := p.repoURL() + "/actions/runs"
+	if p.workflow != "" {
+		runsURL = p.repoURL() + "/actions/workflows/" + url.PathEscape(p.workflow) + "/runs"
+	}
+	query := url.Values{}
+	query.Set("branch", p.bd.getBranch())
+	query.Set("status", "success")
+	query.Set("per_page", strconv.Itoa(p.bd.candidateBuilds()))
+	bodyBytes, err := p.bd.getData(runsURL + "?" + query.Encode())
+	if err != nil {
+		return 0, err
+	}
+	var runs struct {
+		WorkflowRuns []githubRun `json:"workflow_runs"`
+	}
+	if err := json.Unmarshal(bodyBytes, &runs); err != nil {
+		return 0, fmt.Errorf("Cannot parse workflow runs (%v)", err)
+	}
+	for _, run := range runs.WorkflowRuns {
+		if p.bd.matchesCommit(run.HeadCommit.Message, run.HeadCommit.Author.Name, run.HeadCommit.Author.Email, run.Actor.Login) {
+			return run.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("No matching successful workflow run on branch %s", p.bd.getBranch())
+}
+
+func (p *githubProvider) getDefaultBranch() (string, error) {
+	bodyBytes, err := p.bd.getData(p.repoURL())
+	if err != nil {
+		return "", err
+	}
+	var repo struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := json.Unmarshal(bodyBytes, &repo); err != nil {
+		return "", fmt.Errorf("Cannot parse repository (%v)", err)
+	}
+	if repo.DefaultBranch == "" {
+		return "", fmt.Errorf("Repository has no default branch")
+	}
+	return repo.DefaultBranch, nil
+}
+
+// githubState maps the status and conclusion of a run onto the states of
+// Buildkite builds
+func githubState(run githubRun) string {
+	if run.Status != "completed" {
+		return "running"
+	}
+	switch run.Conclusion {
+	case "success":
+		return "passed"
+	case "cancelled":
+		return "canceled"
+	case "skipped", "neutral":
+		return run.Conclusion
+	default:
+		return "failed"
+	}
+}
+
+func (p *githubProvider) getBuildInfo() (*BuildkiteBuildInfo, error) {
+	bodyBytes, err := p.bd.getData(p.repoURL() + "/actions/runs/" + strconv.Itoa(p.bd.buildID))
+	if err != nil {
+		return nil, err
+	}
+	var run githubRun
+	if err := json.Unmarshal(bodyBytes, &run); err != nil {
+		return nil, fmt.Errorf("Cannot parse workflow run (%v)", err)
+	}
+	state := githubState(run)
+	return &BuildkiteBuildInfo{
+		State:    state,
+		CommitID: run.HeadSHA,
+		Branch:   run.HeadBranch,
+		Number:   run.RunNumber,
+		Message:  run.HeadCommit.Message,
+		WebURL:   run.HTMLURL,
+		Author: BuildkiteBuildAuthor{
+			Name:     run.HeadCommit.Author.Name,
+			Email:    run.HeadCommit.Author.Email,
+			Username: run.Actor.Login,
+		},
+		// artifacts belong to the run and not to its jobs
+		Jobs: []BuildkiteBuildJobInfo{{
+			ID:    strconv.Itoa(run.ID),
+			Name:  run.Name,
+			State: state,
+		}},
+	}, nil
+}
+
+func (p *githubProvider) getArtifactInfo(jobID string) ([]BuildkiteBuildArtifactInfo, error) {
+	listURL := p.repoURL() + "/actions/runs/" + jobID + "/artifacts?per_page=" + strconv.Itoa(artifactsPerPage)
+	var artifacts []githubArtifact
+	for page := 1; listURL != "" && page <= maxArtifactPages; page++ {
+		bodyBytes, next, err := p.bd.getPage(listURL)
+		if err != nil {
+			return nil, err
+		}
+		var pageResponse struct {
+			Artifacts []githubArtifact `json:"artifacts"`
+		}
+		if err := json.Unmarshal(bodyBytes, &pageResponse); err != nil {
+			return nil, fmt.Errorf("Cannot parse artifacts (%v)", err)
+		}
+		artifacts = append(artifacts, pageResponse.Artifacts...)
+		listURL = next
+	}
+
+	result := []BuildkiteBuildArtifactInfo{}
+	for _, artifact := range artifacts {
+		if artifact.Expired {
+			result = append(result, BuildkiteBuildArtifactInfo{
+				State:    "expired",
+				Filename: artifact.Name,
+				Path:     artifact.Name,
+			})
+			continue
+		}
+		result = append(result, archiveArtifact(artifact.Name, strconv.Itoa(artifact.ID), artifact.ArchiveDownloadURL, artifact.SizeInBytes))
+	}
+	return result, nil
+}
+
+// getJobLog concatenates the logs of the jobs of the workflow run
+func (p *githubProvider) getJobLog(jobID string) ([]byte, error) {
+	bodyBytes, err := p.bd.getData(p.repoURL() + "/actions/runs/" + jobID + "/jobs?per_page=" + strconv.Itoa(artifactsPerPage))
+	if err != nil {
+		return nil, err
+	}
+	var jobs struct {
+		Jobs []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"jobs"`
+	}
+	if err := json.Unmarshal(bodyBytes, &jobs); err != nil {
+		return nil, fmt.Errorf("Cannot parse jobs (%v)", err)
+	}
+	var logs []byte
+	for _, job := range jobs.Jobs {
+		// the API redirects to the log in the blob storage
+		jobLog, err := p.bd.getLogData(p.repoURL() + "/actions/jobs/" + strconv.Itoa(job.ID) + "/logs")
+		if err != nil {
+			return nil, fmt.Errorf("Cannot download log of job %s (%v)", job.Name, err)
+		}
+		logs = append(logs, "=== "+job.Name+" ===\n"...)
+		logs = append(logs, jobLog...)
+	}
+	return logs, nil
+}
+
+// authorize adds the token to requests for the API
+func (p *githubProvider) authorize(req *http.Request) {
+	if strings.HasPrefix(req.URL.String(), p.apiURL+"/") {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+		req.Header.Set("Accept", "application/vnd.github+json")
+	}
+}
@@ -0,0 +1,121 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SetSigningKey sets the GPG key (ID, fingerprint or user ID) which signs the
+// generated checksum manifests. An empty key disables signing
+func (bd *BuildkiteHandler) SetSigningKey(key string) {
+	bd.gpgKey = key
+}
+
+// SetGPGHome sets the GnuPG home directory. The default one of the user is
+// used when unset
+func (bd *BuildkiteHandler) SetGPGHome(gpgHome string) {
+	bd.gpgHome = gpgHome
+}
+
+// SetVerifySignatures enables the verification of detached signatures
+// (<artifact>.asc or <artifact>.sig) uploaded next to the artifacts.
+// Artifacts without a valid signature get rejected. The signatures are
+// checked against keyring or the keyring of the GnuPG home if it is empty
+func (bd *BuildkiteHandler) SetVerifySignatures(enabled bool, keyring string) error {
+	bd.verifySignatures = enabled
+	bd.keyring = ""
+	if keyring == "" {
+		return nil
+	}
+	// gpg resolves relative keyring paths against its home directory
+	absKeyring, err := filepath.Abs(keyring)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(absKeyring); err != nil {
+		return fmt.Errorf("Cannot use keyring (%v)", err)
+	}
+	bd.keyring = absKeyring
+	return nil
+}
+
+// isSignatureFile reports if filename is a detached signature
+func isSignatureFile(filename string) bool {
+	return strings.HasSuffix(filename, ".asc") || strings.HasSuffix(filename, ".sig")
+}
+
+// findSignature returns the detached signature of filename or nil
+func findSignature(signatures map[string]BuildkiteBuildArtifactInfo, filename string) *BuildkiteBuildArtifactInfo {
+	for _, suffix := range []string{".asc", ".sig"} {
+		if signature, ok := signatures[filename+suffix]; ok {
+			return &signature
+		}
+	}
+	return nil
+}
+
+// verifyArtifactSignature downloads the detached signature of the artifact
+// and verifies the downloaded file at path with it
+func (bd *BuildkiteHandler) verifyArtifactSignature(artifact BuildkiteBuildArtifactInfo, path string) error {
+	if artifact.signature == nil {
+		return fmt.Errorf("No signature uploaded for %s", artifact.Filename)
+	}
+	signaturePath, err := bd.downloadToTemp(*artifact.signature)
+	if err != nil {
+		return fmt.Errorf("Cannot download signature %s (%v)", artifact.signature.Filename, err)
+	}
+	defer os.Remove(signaturePath)
+
+	args := []string{}
+	if bd.keyring != "" {
+		args = append(args, "--no-default-keyring", "--keyring", bd.keyring)
+	}
+	args = append(args, "--verify", signaturePath, path)
+	if err := bd.runGPG(args...); err != nil {
+		return fmt.Errorf("Invalid signature %s for %s (%v)", artifact.signature.Filename, artifact.Filename, err)
+	}
+	log.WithFields(log.Fields{
+		"buildID":          bd.buildID,
+		"artifactFilename": artifact.Filename,
+		"signature":        artifact.signature.Filename,
+	}).Info("Signature verified")
+	return nil
+}
+
+// runGPG executes gpg non-interactively and includes its output in errors
+func (bd *BuildkiteHandler) runGPG(args ...string) error {
+	args = append([]string{"--batch", "--yes"}, args...)
+	if bd.gpgHome != "" {
+		args = append([]string{"--homedir", bd.gpgHome}, args...)
+	}
+	output, err := exec.Command("gpg", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("gpg failed (%v): %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// signFile creates an ASCII armored detached signature <path>.asc
+func (bd *BuildkiteHandler) signFile(path string) error {
+	signaturePath := path + ".asc"
+	err := bd.runGPG(
+		"--armor", "--detach-sign",
+		"--local-user", bd.gpgKey,
+		"--output", signaturePath,
+		path,
+	)
+	if err != nil {
+		return fmt.Errorf("Cannot sign %s (%v)", path, err)
+	}
+	log.WithFields(log.Fields{
+		"file":      path,
+		"signature": signaturePath,
+		"key":       bd.gpgKey,
+	}).Info("File signed")
+	return nil
+}
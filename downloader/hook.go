@@ -0,0 +1,95 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SetPostHook configures a shell command which runs after every downloaded
+// artifact. It gets the artifact and its build as BKAD_* variables, e.g.
+// BKAD_FILE (destination), BKAD_BUILD, BKAD_COMMIT and BKAD_SHA1
+func (bd *BuildkiteHandler) SetPostHook(command string) {
+	bd.postHook = command
+}
+
+// SetPostRunHook configures a shell command which runs once per build after
+// all artifacts got downloaded. BKAD_FILES lists the destinations (one per
+// line) and BKAD_COUNT their count
+func (bd *BuildkiteHandler) SetPostRunHook(command string) {
+	bd.postRunHook = command
+}
+
+// buildEnv returns the BKAD_* variables describing the build
+func (bd *BuildkiteHandler) buildEnv() []string {
+	env := []string{
+		"BKAD_ORG=" + bd.buildkiteOrg,
+		"BKAD_PIPELINE=" + bd.buildkitePipeline,
+		"BKAD_BUILD=" + strconv.Itoa(bd.buildID),
+	}
+	if bd.buildInfo != nil {
+		env = append(env,
+			"BKAD_COMMIT="+bd.buildInfo.CommitID,
+			"BKAD_BRANCH="+bd.buildInfo.Branch,
+		)
+	}
+	return env
+}
+
+// runHook runs command with sh and the given variables in addition to the
+// environment of the downloader
+func runHook(command string, env []string) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		log.WithFields(log.Fields{
+			"hook": command,
+		}).Info(strings.TrimSpace(string(output)))
+	}
+	if err != nil {
+		return fmt.Errorf("Hook '%s' failed (%v)", command, err)
+	}
+	return nil
+}
+
+// runPostHook runs the post hook for a downloaded artifact
+func (bd *BuildkiteHandler) runPostHook(metadata ArtifactMetadata) error {
+	env := append(bd.buildEnv(),
+		"BKAD_FILE="+metadata.Destination,
+		"BKAD_FILENAME="+metadata.Filename,
+		"BKAD_JOB="+metadata.JobName,
+		"BKAD_URL="+metadata.SourceURL,
+		"BKAD_SIZE="+strconv.FormatInt(metadata.Size, 10),
+		"BKAD_SHA1="+metadata.SHA1,
+		"BKAD_SHA256="+metadata.SHA256,
+	)
+	if metadata.APK != nil {
+		env = append(env,
+			"BKAD_APK_PACKAGE="+metadata.APK.PackageName,
+			"BKAD_APK_VERSION_NAME="+metadata.APK.VersionName,
+			"BKAD_APK_VERSION_CODE="+strconv.FormatInt(metadata.APK.VersionCode, 10),
+		)
+	}
+	if metadata.CID != "" {
+		env = append(env, "BKAD_CID="+metadata.CID)
+	}
+	return runHook(bd.postHook, env)
+}
+
+// runPostRunHook runs the post run hook for the downloads of the build
+func (bd *BuildkiteHandler) runPostRunHook() error {
+	files := make([]string, len(bd.results))
+	for i, result := range bd.results {
+		files[i] = result.Destination
+	}
+	env := append(bd.buildEnv(),
+		"BKAD_FILES="+strings.Join(files, "\n"),
+		"BKAD_COUNT="+strconv.Itoa(len(files)),
+	)
+	return runHook(bd.postRunHook, env)
+}
@@ -0,0 +1,174 @@
+package buildkiteArtifactDownloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ipfsTimeout limits adding a single artifact to the IPFS node
+const ipfsTimeout = 30 * time.Minute
+
+// ipfsConfig describes where downloads are added to IPFS
+type ipfsConfig struct {
+	// apiURL is the RPC API of the IPFS node (e.g. http://127.0.0.1:5001)
+	apiURL string
+	// pinService is the endpoint of a remote pinning service implementing
+	// the IPFS Pinning Service API. Empty if only the node pins
+	pinService string
+	pinToken   string
+	client     *http.Client
+}
+
+// SetIPFS adds every download to the IPFS node with the RPC API at apiURL
+// (e.g. http://127.0.0.1:5001) and pins it there. The CID gets recorded in
+// the download result. An empty apiURL disables IPFS
+func (bd *BuildkiteHandler) SetIPFS(apiURL string) error {
+	if apiURL == "" {
+		bd.ipfs = nil
+		return nil
+	}
+	parsed, err := url.Parse(apiURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return fmt.Errorf("IPFS API has to be a http(s) URL")
+	}
+	bd.ipfs = &ipfsConfig{
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		// the node is usually local and should not be reached via the proxy
+		// of the Buildkite requests
+		client: &http.Client{Timeout: ipfsTimeout},
+	}
+	return nil
+}
+
+// SetIPFSPinningService additionally asks the pinning service at endpoint
+// (e.g. https://api.pinata.cloud/psa) to pin the CIDs, so they stay
+// available when the node goes offline. Requires SetIPFS
+func (bd *BuildkiteHandler) SetIPFSPinningService(endpoint string, token string) error {
+	if endpoint == "" {
+		if bd.ipfs != nil {
+			bd.ipfs.pinService = ""
+		}
+		return nil
+	}
+	if bd.ipfs == nil {
+		return fmt.Errorf("Pinning service requires an IPFS node to add the artifacts to")
+	}
+	if token == "" {
+		return fmt.Errorf("Pinning service requires an access token")
+	}
+	bd.ipfs.pinService = strings.TrimSuffix(endpoint, "/")
+	bd.ipfs.pinToken = token
+	return nil
+}
+
+// ipfsAddResponse is one line of the response of /api/v0/add
+type ipfsAddResponse struct {
+	Name string
+	Hash string
+}
+
+// addToIPFS adds the file at path to the node and returns its CID
+func (bd *BuildkiteHandler) addToIPFS(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// stream the file instead of buffering APKs in memory
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		part, err := form.CreateFormFile("file", filepath.Base(path))
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	resp, err := bd.ipfs.client.Post(bd.ipfs.apiURL+"/api/v0/add?pin=true&cid-version=1", form.FormDataContentType(), body)
+	if err != nil {
+		return "", fmt.Errorf("Cannot reach IPFS node (%v)", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		return "", fmt.Errorf("IPFS node responded with %s (%s)", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	var added ipfsAddResponse
+	decoder := json.NewDecoder(resp.Body)
+	for decoder.More() {
+		if err := decoder.Decode(&added); err != nil {
+			return "", fmt.Errorf("Cannot decode response of IPFS node (%v)", err)
+		}
+	}
+	if added.Hash == "" {
+		return "", fmt.Errorf("IPFS node did not return a CID")
+	}
+	return added.Hash, nil
+}
+
+// pinRemote asks the pinning service to pin cid under name
+func (bd *BuildkiteHandler) pinRemote(cid string, name string) error {
+	payload, err := json.Marshal(map[string]string{"cid": cid, "name": name})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, bd.ipfs.pinService+"/pins", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+bd.ipfs.pinToken)
+	resp, err := bd.ipfs.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Cannot reach pinning service (%v)", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Pinning service responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// publishToIPFS adds the download to IPFS and records its CID. Failures
+// are logged only, like for the other outputs of a finished download
+func (bd *BuildkiteHandler) publishToIPFS(result *DownloadResult) {
+	cid, err := bd.addToIPFS(result.Destination)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"destination": result.Destination,
+			"error":       err,
+		}).Warn("Cannot add artifact to IPFS")
+		return
+	}
+	result.CID = cid
+	log.WithFields(log.Fields{
+		"destination": result.Destination,
+		"cid":         cid,
+	}).Info("Added artifact to IPFS")
+	if bd.ipfs.pinService == "" {
+		return
+	}
+	if err := bd.pinRemote(cid, filepath.Base(result.Destination)); err != nil {
+		log.WithFields(log.Fields{
+			"cid":   cid,
+			"error": err,
+		}).Warn("Cannot pin artifact at pinning service")
+	}
+}
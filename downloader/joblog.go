@@ -0,0 +1,89 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// JobLogSuffix is appended to the file names of downloaded job logs
+	JobLogSuffix = ".log"
+)
+
+// SetDownloadJobLogs enables storing the raw log of every job which
+// produced downloaded artifacts as <buildID>-<jobName>.log next to them.
+// The log helps with post-mortems when a published binary misbehaves
+func (bd *BuildkiteHandler) SetDownloadJobLogs(enabled bool) {
+	bd.downloadJobLogs = enabled
+}
+
+// jobLogName returns the file name of the log of job
+func (bd *BuildkiteHandler) jobLogName(job BuildkiteBuildJobInfo) string {
+	return strconv.Itoa(bd.buildID) + "-" + sanitizePathComponent(job.Name) + JobLogSuffix
+}
+
+// writeJobLogs stores the logs of the jobs of the downloads in every
+// directory they got downloaded to. Logs are fetched once per job
+func (bd *BuildkiteHandler) writeJobLogs() {
+	logs := make(map[string][]byte)
+	for i := range bd.results {
+		result := &bd.results[i]
+		job := result.job
+		if job.ID == "" {
+			continue
+		}
+		data, fetched := logs[job.ID]
+		if !fetched {
+			var err error
+			data, err = bd.provider.getJobLog(job.ID)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"buildID": bd.buildID,
+					"jobID":   job.ID,
+					"jobName": job.Name,
+					"error":   err,
+				}).Warn("Cannot download job log")
+			}
+			// failed logs are not retried for the other downloads
+			logs[job.ID] = data
+		}
+		if data == nil {
+			continue
+		}
+		logPath := filepath.Join(filepath.Dir(result.Destination), bd.jobLogName(job))
+		if err := bd.writeJobLog(logPath, data); err != nil {
+			log.WithFields(log.Fields{
+				"buildID": bd.buildID,
+				"jobID":   job.ID,
+				"error":   err,
+			}).Warn("Cannot write job log")
+			continue
+		}
+		result.Log = logPath
+	}
+}
+
+// writeJobLog stores data at logPath unless it got written for an earlier
+// download of the same directory already
+func (bd *BuildkiteHandler) writeJobLog(logPath string, data []byte) error {
+	for _, result := range bd.results {
+		if result.Log == logPath {
+			return nil
+		}
+	}
+	if err := writeFileAtomic(logPath, data, bd.fileMode); err != nil {
+		return fmt.Errorf("Cannot write %s (%v)", logPath, err)
+	}
+	if err := bd.chown(logPath); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"buildID": bd.buildID,
+		"log":     logPath,
+		"size":    len(data),
+	}).Info("Job log written")
+	return nil
+}
@@ -0,0 +1,105 @@
+package buildkiteArtifactDownloader
+
+import (
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SetJobFilter sets (or deletes when empty) a filter on the job name. Only
+// artifacts of matching jobs will be downloaded
+func (bd *BuildkiteHandler) SetJobFilter(jobFilter string) error {
+	if jobFilter == "" {
+		bd.jobFilter = nil
+		return nil
+	}
+	reJobFilter, err := regexp.Compile(jobFilter)
+	if err != nil {
+		return err
+	}
+	bd.jobFilter = reJobFilter
+	return nil
+}
+
+// AddStepKey only downloads artifacts of jobs of the step with the given key.
+// Jobs of any of the added steps are used
+func (bd *BuildkiteHandler) AddStepKey(stepKey string) {
+	bd.stepKeys = append(bd.stepKeys, stepKey)
+}
+
+// SetStepKeys replaces the step keys (deletes them when empty)
+func (bd *BuildkiteHandler) SetStepKeys(stepKeys []string) {
+	bd.stepKeys = nil
+	for _, stepKey := range stepKeys {
+		bd.AddStepKey(stepKey)
+	}
+}
+
+// SetOnlyPassedJobs skips jobs which did not pass (e.g. soft-failed or
+// canceled jobs of a passed build)
+func (bd *BuildkiteHandler) SetOnlyPassedJobs(onlyPassed bool) {
+	bd.onlyPassedJobs = onlyPassed
+}
+
+// matchesJob reports if the artifacts of job should be downloaded
+func (bd *BuildkiteHandler) matchesJob(job BuildkiteBuildJobInfo) bool {
+	if bd.jobFilter != nil && !bd.jobFilter.MatchString(job.Name) {
+		return false
+	}
+	if len(bd.stepKeys) == 0 {
+		return true
+	}
+	for _, stepKey := range bd.stepKeys {
+		if job.StepKey == stepKey {
+			return true
+		}
+	}
+	return false
+}
+
+// latestAttempts replaces retried jobs with one attempt of their retry
+// chain. The newest passed attempt is preferred, otherwise the newest one is
+// used
+func (bd *BuildkiteHandler) latestAttempts(jobs []BuildkiteBuildJobInfo) []BuildkiteBuildJobInfo {
+	byID := make(map[string]BuildkiteBuildJobInfo)
+	isRetry := make(map[string]bool)
+	for _, job := range jobs {
+		byID[job.ID] = job
+		if job.RetriedInJobID != "" {
+			isRetry[job.RetriedInJobID] = true
+		}
+	}
+
+	var result []BuildkiteBuildJobInfo
+	for _, job := range jobs {
+		if isRetry[job.ID] {
+			// handled with the first attempt
+			continue
+		}
+		chosen := job
+		passed := job.State == "passed"
+		// the length of jobs bounds the chain in case of malformed responses
+		next := job.RetriedInJobID
+		for i := 0; next != "" && i < len(jobs); i++ {
+			attempt, ok := byID[next]
+			if !ok {
+				break
+			}
+			if attempt.State == "passed" || !passed {
+				chosen = attempt
+				passed = attempt.State == "passed"
+			}
+			next = attempt.RetriedInJobID
+		}
+		if chosen.ID != job.ID {
+			log.WithFields(log.Fields{
+				"buildID": bd.buildID,
+				"jobName": job.Name,
+				"jobID":   chosen.ID,
+				"state":   chosen.State,
+			}).Debug("Use latest attempt of retried job")
+		}
+		result = append(result, chosen)
+	}
+	return result
+}
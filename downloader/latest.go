@@ -0,0 +1,105 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// latestLink describes a symlink which points to the newest download of
+// artifacts matching pattern
+type latestLink struct {
+	pattern *regexp.Regexp
+	name    string
+}
+
+// AddLatestLink maintains a symlink called name (e.g. riot-latest.apk) which
+// points to the newest download of an artifact whose filename matches
+// pattern. Relative names are placed next to the downloaded file
+func (bd *BuildkiteHandler) AddLatestLink(pattern string, name string) error {
+	if name == "" || strings.HasSuffix(name, string(os.PathSeparator)) {
+		return fmt.Errorf("Invalid link name '%s'", name)
+	}
+	rePattern, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	bd.latestLinks = append(bd.latestLinks, latestLink{
+		pattern: rePattern,
+		name:    name,
+	})
+	return nil
+}
+
+// updateLatestLinks points the configured symlinks to the matching results
+// of this run. If multiple results match, the last one wins
+func (bd *BuildkiteHandler) updateLatestLinks() {
+	for _, link := range bd.latestLinks {
+		var latest *DownloadResult
+		for i := range bd.results {
+			if link.pattern.MatchString(bd.results[i].Filename) {
+				latest = &bd.results[i]
+			}
+		}
+		if latest == nil {
+			continue
+		}
+		linkPath := link.name
+		if !filepath.IsAbs(linkPath) {
+			linkPath = filepath.Join(filepath.Dir(latest.Destination), linkPath)
+		}
+		err := replaceSymlink(latest.Destination, linkPath)
+		if err == nil {
+			err = bd.lchown(linkPath)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"buildID":     bd.buildID,
+				"link":        linkPath,
+				"destination": latest.Destination,
+				"error":       err,
+			}).Warn("Cannot update latest link")
+			continue
+		}
+		log.WithFields(log.Fields{
+			"buildID":     bd.buildID,
+			"link":        linkPath,
+			"destination": latest.Destination,
+		}).Info("Latest link updated")
+	}
+}
+
+// replaceSymlink atomically points linkPath to target. The link is relative
+// so the directory can be moved or served from a different mount point
+func replaceSymlink(target string, linkPath string) error {
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+	absLink, err := filepath.Abs(linkPath)
+	if err != nil {
+		return err
+	}
+	relTarget, err := filepath.Rel(filepath.Dir(absLink), absTarget)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Lstat(linkPath); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("%s exists and is no symlink", linkPath)
+	}
+
+	tmpLink := linkPath + ".tmp"
+	os.Remove(tmpLink)
+	if err := os.Symlink(relTarget, tmpLink); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpLink, linkPath); err != nil {
+		os.Remove(tmpLink)
+		return err
+	}
+	return nil
+}
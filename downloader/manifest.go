@@ -0,0 +1,105 @@
+package buildkiteArtifactDownloader
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ChecksumManifestName is the file name of the generated checksum manifest
+	ChecksumManifestName = "SHA256SUMS"
+)
+
+// SetWriteChecksumManifest enables writing a SHA256SUMS file into every
+// directory artifacts got downloaded to
+func (bd *BuildkiteHandler) SetWriteChecksumManifest(enabled bool) {
+	bd.writeManifest = enabled
+}
+
+// writeChecksumManifests merges the downloaded artifacts into the checksum
+// manifest of their destination directories. Entries of files which do not
+// exist anymore get dropped so the manifest always describes the directory
+func (bd *BuildkiteHandler) writeChecksumManifests() ([]string, error) {
+	byDir := make(map[string][]DownloadResult)
+	for _, result := range bd.results {
+		dir := filepath.Dir(result.Destination)
+		byDir[dir] = append(byDir[dir], result)
+	}
+
+	var manifests []string
+	for dir, results := range byDir {
+		manifestPath := filepath.Join(dir, ChecksumManifestName)
+		sums, err := readChecksumManifest(manifestPath)
+		if err != nil {
+			return manifests, err
+		}
+		for _, result := range results {
+			sums[filepath.Base(result.Destination)] = result.SHA256
+		}
+		for name := range sums {
+			if _, err := os.Stat(filepath.Join(dir, name)); os.IsNotExist(err) {
+				delete(sums, name)
+			}
+		}
+		if err := writeChecksumManifest(manifestPath, sums, bd.fileMode); err != nil {
+			return manifests, err
+		}
+		if err := bd.chown(manifestPath); err != nil {
+			return manifests, err
+		}
+		log.WithFields(log.Fields{
+			"buildID":  bd.buildID,
+			"manifest": manifestPath,
+			"entries":  len(sums),
+		}).Info("Checksum manifest written")
+		manifests = append(manifests, manifestPath)
+	}
+	return manifests, nil
+}
+
+// readChecksumManifest parses a manifest in the format of sha256sum
+func readChecksumManifest(path string) (map[string]string, error) {
+	sums := make(map[string]string)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return sums, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		// sha256sum marks binary mode with a leading '*'
+		name := strings.TrimPrefix(strings.TrimPrefix(fields[1], " "), "*")
+		sums[name] = fields[0]
+	}
+	return sums, scanner.Err()
+}
+
+// writeChecksumManifest replaces the manifest atomically so consumers never
+// see a partially written file
+func writeChecksumManifest(path string, sums map[string]string, mode os.FileMode) error {
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var content strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&content, "%s  %s\n", sums[name], name)
+	}
+
+	return writeFileAtomic(path, []byte(content.String()), mode)
+}
@@ -0,0 +1,80 @@
+package buildkiteArtifactDownloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// reMetaDataPlaceholder matches <meta:key> placeholders
+var reMetaDataPlaceholder = regexp.MustCompile(`<meta:([^>]+)>`)
+
+// AddMetaDataFilter only accepts builds whose meta-data key has value. All
+// filters have to match. Buildkite only exposes the meta-data of builds via
+// the REST API, so an API token is required
+func (bd *BuildkiteHandler) AddMetaDataFilter(key string, value string) error {
+	if key == "" {
+		return fmt.Errorf("Meta-data filter needs a key")
+	}
+	if bd.metaDataFilters == nil {
+		bd.metaDataFilters = make(map[string]string)
+	}
+	bd.metaDataFilters[key] = value
+	return nil
+}
+
+// needsMetaData reports if the meta-data of the build is used by a filter
+// or the destination pattern
+func (bd *BuildkiteHandler) needsMetaData() bool {
+	return len(bd.metaDataFilters) > 0 ||
+		reMetaDataPlaceholder.MatchString(bd.getDestinationPattern()) ||
+		(bd.destTemplate != nil && strings.Contains(bd.getDestinationPattern(), ".MetaData"))
+}
+
+// matchesMetaData reports if the build matches all meta-data filters
+func (bd *BuildkiteHandler) matchesMetaData(buildInfo BuildkiteBuildInfo) bool {
+	for key, value := range bd.metaDataFilters {
+		if actual, ok := buildInfo.MetaData[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// metaDataQuery adds the meta-data filters to a builds query of the REST
+// API so only matching builds are listed
+func (bd *BuildkiteHandler) metaDataQuery(query url.Values) {
+	for key, value := range bd.metaDataFilters {
+		query.Set("meta_data["+key+"]", value)
+	}
+}
+
+// getMetaData queries the meta-data of the build from the REST API
+func (bd *BuildkiteHandler) getMetaData() (map[string]string, error) {
+	if bd.apiToken == "" {
+		return nil, fmt.Errorf("Build meta-data requires an API token")
+	}
+	bodyBytes, err := bd.getData(buildkiteAPIURL + "/organizations/" + bd.buildkiteOrg + "/pipelines/" + bd.buildkitePipeline + "/builds/" + strconv.Itoa(bd.buildID))
+	if err != nil {
+		return nil, err
+	}
+	var build struct {
+		MetaData map[string]string `json:"meta_data"`
+	}
+	if err := json.Unmarshal(bodyBytes, &build); err != nil {
+		return nil, fmt.Errorf("Cannot parse build (%v)", err)
+	}
+	return build.MetaData, nil
+}
+
+// replaceMetaData resolves the <meta:key> placeholders of output. Missing
+// keys are replaced with "unknown"
+func replaceMetaData(output string, metaData map[string]string) string {
+	return reMetaDataPlaceholder.ReplaceAllStringFunc(output, func(placeholder string) string {
+		key := reMetaDataPlaceholder.FindStringSubmatch(placeholder)[1]
+		return sanitizePathComponent(metaData[key])
+	})
+}
@@ -0,0 +1,40 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+)
+
+// SetMirror downloads every artifact of every job of the build into
+// <dir>/<buildID>/<jobName>/<path>, keeping an unmodified copy of the
+// build. The artifact and job filters, the destination pattern,
+// decompression and extraction do not apply to mirrors. An empty dir
+// disables the mirror mode
+func (bd *BuildkiteHandler) SetMirror(dir string) {
+	bd.mirror = dir != ""
+	bd.mirrorDir = dir
+}
+
+// mirrorJobDir returns the directory name of job. Jobs sharing their name
+// (e.g. parallel jobs or retries) get their ID appended
+func (bd *BuildkiteHandler) mirrorJobDir(buildInfo BuildkiteBuildInfo, job BuildkiteBuildJobInfo) string {
+	name := sanitizePathComponent(job.Name)
+	for _, other := range buildInfo.Jobs {
+		if other.ID != job.ID && sanitizePathComponent(other.Name) == name {
+			return name + "-" + shortCommit(job.ID, defaultCommitLength)
+		}
+	}
+	return name
+}
+
+// getMirrorPath returns the destination of artifact in the mirror. Paths
+// escaping the directory of the job are refused
+func (bd *BuildkiteHandler) getMirrorPath(buildInfo BuildkiteBuildInfo, artifact BuildkiteBuildArtifactInfo) (string, error) {
+	jobDir := filepath.Join(bd.mirrorDir, strconv.Itoa(bd.buildID), bd.mirrorJobDir(buildInfo, artifact.job))
+	destPath, err := extractionTarget(jobDir, filepath.FromSlash(artifact.fullPath()))
+	if err != nil {
+		return "", fmt.Errorf("Cannot mirror %s (%v)", artifact.fullPath(), err)
+	}
+	return destPath, nil
+}
@@ -0,0 +1,48 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SetArtifactOrder sorts the artifacts of a build before they get
+// downloaded. Supported keys are name, path and size, a leading "-" reverses
+// the order. An empty order keeps the order of the API
+func (bd *BuildkiteHandler) SetArtifactOrder(order string) error {
+	switch strings.TrimPrefix(order, "-") {
+	case "", "name", "path", "size":
+	default:
+		return fmt.Errorf("Unknown artifact order %s", order)
+	}
+	bd.artifactOrder = order
+	return nil
+}
+
+// SetMaxArtifacts limits the count of artifacts downloaded per build. The
+// limit is applied after filtering and sorting. 0 disables the limit
+func (bd *BuildkiteHandler) SetMaxArtifacts(maxArtifacts int) {
+	bd.maxArtifacts = maxArtifacts
+}
+
+// sortArtifacts sorts artifacts in place according to the artifact order
+func (bd *BuildkiteHandler) sortArtifacts(artifacts []BuildkiteBuildArtifactInfo) {
+	descending := strings.HasPrefix(bd.artifactOrder, "-")
+	var less func(a, b BuildkiteBuildArtifactInfo) bool
+	switch strings.TrimPrefix(bd.artifactOrder, "-") {
+	case "name":
+		less = func(a, b BuildkiteBuildArtifactInfo) bool { return a.Filename < b.Filename }
+	case "path":
+		less = func(a, b BuildkiteBuildArtifactInfo) bool { return a.fullPath() < b.fullPath() }
+	case "size":
+		less = func(a, b BuildkiteBuildArtifactInfo) bool { return a.FileSize < b.FileSize }
+	default:
+		return
+	}
+	sort.SliceStable(artifacts, func(i, j int) bool {
+		if descending {
+			return less(artifacts[j], artifacts[i])
+		}
+		return less(artifacts[i], artifacts[j])
+	})
+}
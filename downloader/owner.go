@@ -0,0 +1,35 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+	"os"
+)
+
+// SetOwner changes the owner of written files and created directories to
+// uid and gid. A value of -1 keeps the corresponding id unchanged
+func (bd *BuildkiteHandler) SetOwner(uid int, gid int) {
+	bd.ownerUID = uid
+	bd.ownerGID = gid
+}
+
+// chown applies the configured owner to path
+func (bd *BuildkiteHandler) chown(path string) error {
+	if bd.ownerUID < 0 && bd.ownerGID < 0 {
+		return nil
+	}
+	if err := os.Chown(path, bd.ownerUID, bd.ownerGID); err != nil {
+		return fmt.Errorf("Cannot change owner of %s (%v)", path, err)
+	}
+	return nil
+}
+
+// lchown applies the configured owner to the symlink at path
+func (bd *BuildkiteHandler) lchown(path string) error {
+	if bd.ownerUID < 0 && bd.ownerGID < 0 {
+		return nil
+	}
+	if err := os.Lchown(path, bd.ownerUID, bd.ownerGID); err != nil {
+		return fmt.Errorf("Cannot change owner of %s (%v)", path, err)
+	}
+	return nil
+}
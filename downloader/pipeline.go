@@ -0,0 +1,22 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+)
+
+// ForPipeline returns a handler with the configuration of bd for another
+// pipeline. The handlers can download concurrently and share the rate limit
+// of bd. Only the Buildkite provider is supported
+func (bd *BuildkiteHandler) ForPipeline(org string, pipeline string) (*BuildkiteHandler, error) {
+	if _, ok := bd.provider.(buildkiteProvider); !ok {
+		return nil, fmt.Errorf("Multiple pipelines are only supported with Buildkite")
+	}
+	if org == "" || pipeline == "" {
+		return nil, fmt.Errorf("Pipeline requires an organisation and a name")
+	}
+	c := bd.clone()
+	c.buildkiteOrg = org
+	c.buildkitePipeline = pipeline
+	c.defaultBranch = ""
+	return c, nil
+}
@@ -0,0 +1,233 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// skippedError signals that an artifact was not downloaded on purpose
+// (e.g. because an identical file exists already)
+type skippedError struct {
+	reason string
+}
+
+func (e skippedError) Error() string {
+	return e.reason
+}
+
+// SetForceOverwrite replaces existing destinations unconditionally. The
+// artifact is still downloaded and verified before the file gets replaced
+func (bd *BuildkiteHandler) SetForceOverwrite(force bool) {
+	bd.forceOverwrite = force
+}
+
+// SetBackupExisting keeps a replaced destination as <name>.bak-<timestamp>
+// to allow a local rollback to the previous artifact
+func (bd *BuildkiteHandler) SetBackupExisting(backup bool) {
+	bd.backupExisting = backup
+}
+
+// SetFileMode sets the permissions of written files (0644 by default)
+func (bd *BuildkiteHandler) SetFileMode(mode os.FileMode) {
+	bd.fileMode = mode
+}
+
+// SetDirMode sets the permissions of created directories (0755 by default)
+func (bd *BuildkiteHandler) SetDirMode(mode os.FileMode) {
+	bd.dirMode = mode
+}
+
+// checkExistingDestination decides what happens with an artifact whose
+// destination does already exist. Identical files are skipped, files which
+// differ from the checksum of the API get replaced. Without a checksum the
+// existing file is kept and an error is returned
+func (bd *BuildkiteHandler) checkExistingDestination(artifact BuildkiteBuildArtifactInfo, destPath string) error {
+	if _, err := os.Stat(destPath); os.IsNotExist(err) || bd.forceOverwrite {
+		return nil
+	}
+	// decompressed files cannot be compared with the checksum of the
+	// compressed artifact
+	if bd.decompresses(artifact) {
+		return fmt.Errorf("Destination does already exist - do not download")
+	}
+
+	algorithm, expected := artifact.strongestChecksum()
+	if algorithm == "" {
+		return fmt.Errorf("Destination does already exist - do not download")
+	}
+	actual, err := fileDigest(destPath, algorithm)
+	if err != nil {
+		return fmt.Errorf("Cannot compare existing destination %s (%v)", destPath, err)
+	}
+	if actual == expected {
+		return skippedError{fmt.Sprintf("Destination %s is up to date", destPath)}
+	}
+
+	log.WithFields(log.Fields{
+		"buildID":          bd.buildID,
+		"artifactFilename": artifact.Filename,
+		"destination":      destPath,
+		"algorithm":        algorithm,
+		"expected":         expected,
+		"actual":           actual,
+	}).Warn("Destination differs from artifact. Download again")
+	return nil
+}
+
+// checkExistingDownload compares an existing destination with the already
+// downloaded content. This is used if the destination depends on the content
+func (bd *BuildkiteHandler) checkExistingDownload(artifact BuildkiteBuildArtifactInfo, destPath string, sha256sum string) error {
+	if _, err := os.Stat(destPath); os.IsNotExist(err) || bd.forceOverwrite {
+		return nil
+	}
+	actual, err := fileDigest(destPath, DigestSHA256)
+	if err != nil {
+		return fmt.Errorf("Cannot compare existing destination %s (%v)", destPath, err)
+	}
+	if actual == sha256sum {
+		return skippedError{fmt.Sprintf("Destination %s is up to date", destPath)}
+	}
+	log.WithFields(log.Fields{
+		"buildID":          bd.buildID,
+		"artifactFilename": artifact.Filename,
+		"destination":      destPath,
+	}).Warn("Destination differs from artifact. Replace it")
+	return nil
+}
+
+// placeArtifact moves the downloaded file at tmpPath to destPath. If enabled
+// an existing destination is kept as backup first and identical downloads of
+// previous builds are hardlinked. The path of the backup is returned
+func (bd *BuildkiteHandler) placeArtifact(tmpPath string, destPath string, size int64, sha256sum string) (string, error) {
+	if err := bd.makeDirs(filepath.Dir(destPath)); err != nil {
+		return "", fmt.Errorf("Cannot create destination directory (%v)", err)
+	}
+	backupPath := ""
+	if _, err := os.Stat(destPath); err == nil && bd.backupExisting {
+		backupPath = destPath + ".bak-" + time.Now().Format("20060102-150405")
+		// a hard link keeps the destination in place until it gets replaced
+		if err := os.Link(destPath, backupPath); err != nil {
+			if err := os.Rename(destPath, backupPath); err != nil {
+				return "", fmt.Errorf("Cannot backup %s (%v)", destPath, err)
+			}
+		}
+		log.WithFields(log.Fields{
+			"buildID":     bd.buildID,
+			"destination": destPath,
+			"backup":      backupPath,
+		}).Info("Existing destination backed up")
+	}
+	if bd.hardlinkDuplicates {
+		if duplicate := bd.findDuplicate(destPath, size, sha256sum); duplicate != "" {
+			err := linkFile(duplicate, destPath)
+			if err == nil {
+				log.WithFields(log.Fields{
+					"buildID":     bd.buildID,
+					"destination": destPath,
+					"duplicate":   duplicate,
+				}).Info("Hardlinked identical download of previous build")
+				return backupPath, bd.chown(destPath)
+			}
+			log.WithFields(log.Fields{
+				"buildID":     bd.buildID,
+				"destination": destPath,
+				"duplicate":   duplicate,
+				"error":       err,
+			}).Warn("Cannot hardlink duplicate. Store a copy")
+		}
+	}
+	if err := placeFile(tmpPath, destPath, bd.fileMode); err != nil {
+		if _, statErr := os.Stat(destPath); backupPath != "" && os.IsNotExist(statErr) {
+			os.Rename(backupPath, destPath)
+		}
+		return "", err
+	}
+	return backupPath, bd.chown(destPath)
+}
+
+// makeDirs creates dir and all missing parents with the configured mode
+// and owner. Other than os.MkdirAll the mode is applied regardless of the
+// umask
+func (bd *BuildkiteHandler) makeDirs(dir string) error {
+	info, err := os.Stat(dir)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s is no directory", dir)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := bd.makeDirs(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, bd.dirMode); err != nil && !os.IsExist(err) {
+		return err
+	}
+	if err := os.Chmod(dir, bd.dirMode); err != nil {
+		return err
+	}
+	return bd.chown(dir)
+}
+
+// placeFile copies the downloaded file at tmpPath to destPath. The content is
+// written to a temporary file next to the destination first which then
+// replaces the destination atomically. This way an existing destination is
+// never left in a partially written state
+func placeFile(tmpPath string, destPath string, mode os.FileMode) error {
+	src, err := os.Open(tmpPath)
+	if err != nil {
+		return fmt.Errorf("Cannot read tmpfile %s ('%s')", tmpPath, err)
+	}
+	defer src.Close()
+
+	dest, err := ioutil.TempFile(filepath.Dir(destPath), "."+filepath.Base(destPath)+"-")
+	if err != nil {
+		return fmt.Errorf("Cannot write to %s ('%s')", destPath, err)
+	}
+	defer os.Remove(dest.Name())
+	if _, err := io.Copy(dest, src); err != nil {
+		dest.Close()
+		return fmt.Errorf("Cannot write to %s ('%s')", destPath, err)
+	}
+	if err := dest.Close(); err != nil {
+		return fmt.Errorf("Cannot write to %s ('%s')", destPath, err)
+	}
+	if err := os.Chmod(dest.Name(), mode); err != nil {
+		return err
+	}
+	if err := os.Rename(dest.Name(), destPath); err != nil {
+		return fmt.Errorf("Cannot move download to %s ('%s')", destPath, err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data so readers never see a partially
+// written file
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
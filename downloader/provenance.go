@@ -0,0 +1,137 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultSLSAVerifier is the slsa-verifier binary used to check provenance
+	DefaultSLSAVerifier = "slsa-verifier"
+)
+
+// provenanceConfig describes how provenance attestations are verified
+type provenanceConfig struct {
+	attestationPattern *regexp.Regexp
+	subjectPattern     *regexp.Regexp
+	sourceURI          string
+	verifier           string
+}
+
+// SetProvenance requires artifacts matching subjectPattern to be covered by a
+// provenance attestation uploaded as artifact matching attestationPattern.
+// The attestation is checked with slsa-verifier against sourceURI (e.g.
+// github.com/org/repo). An empty attestationPattern disables the check
+func (bd *BuildkiteHandler) SetProvenance(attestationPattern string, subjectPattern string, sourceURI string) error {
+	if attestationPattern == "" {
+		bd.provenance = nil
+		return nil
+	}
+	if sourceURI == "" {
+		return fmt.Errorf("Provenance verification requires a source URI")
+	}
+	reAttestation, err := regexp.Compile(attestationPattern)
+	if err != nil {
+		return err
+	}
+	if subjectPattern == "" {
+		subjectPattern = ".*"
+	}
+	reSubject, err := regexp.Compile(subjectPattern)
+	if err != nil {
+		return err
+	}
+	bd.provenance = &provenanceConfig{
+		attestationPattern: reAttestation,
+		subjectPattern:     reSubject,
+		sourceURI:          sourceURI,
+		verifier:           DefaultSLSAVerifier,
+	}
+	return nil
+}
+
+// SetSLSAVerifier overrides the slsa-verifier binary
+func (bd *BuildkiteHandler) SetSLSAVerifier(verifier string) {
+	if bd.provenance != nil && verifier != "" {
+		bd.provenance.verifier = verifier
+	}
+}
+
+// isAttestation reports if the artifact is a provenance attestation
+func (bd *BuildkiteHandler) isAttestation(filename string) bool {
+	return bd.provenance != nil && bd.provenance.attestationPattern.MatchString(filename)
+}
+
+// needsProvenance reports if the artifact has to be covered by provenance
+func (bd *BuildkiteHandler) needsProvenance(filename string) bool {
+	return bd.provenance != nil &&
+		!bd.isAttestation(filename) &&
+		!isSignatureFile(filename) &&
+		bd.provenance.subjectPattern.MatchString(filename)
+}
+
+// attestationFiles downloads the attestations of the build once and returns
+// the paths of the temporary files
+func (bd *BuildkiteHandler) attestationFiles() ([]string, error) {
+	if bd.attestationPaths != nil {
+		return bd.attestationPaths, nil
+	}
+	if len(bd.attestations) == 0 {
+		return nil, fmt.Errorf("Build %d has no provenance attestation", bd.buildID)
+	}
+	paths := []string{}
+	for _, attestation := range bd.attestations {
+		path, err := bd.downloadToTemp(attestation)
+		if err != nil {
+			for _, path := range paths {
+				os.Remove(path)
+			}
+			return nil, fmt.Errorf("Cannot download attestation %s (%v)", attestation.Filename, err)
+		}
+		paths = append(paths, path)
+	}
+	bd.attestationPaths = paths
+	return paths, nil
+}
+
+// cleanupAttestations removes the downloaded attestations of the build
+func (bd *BuildkiteHandler) cleanupAttestations() {
+	for _, path := range bd.attestationPaths {
+		os.Remove(path)
+	}
+	bd.attestations = nil
+	bd.attestationPaths = nil
+}
+
+// verifyProvenance checks the downloaded file at path against the
+// attestations of the build. One matching attestation is sufficient
+func (bd *BuildkiteHandler) verifyProvenance(artifact BuildkiteBuildArtifactInfo, path string) error {
+	attestations, err := bd.attestationFiles()
+	if err != nil {
+		return err
+	}
+
+	var failures []string
+	for _, attestation := range attestations {
+		output, err := exec.Command(
+			bd.provenance.verifier, "verify-artifact", path,
+			"--provenance-path", attestation,
+			"--source-uri", bd.provenance.sourceURI,
+		).CombinedOutput()
+		if err == nil {
+			log.WithFields(log.Fields{
+				"buildID":          bd.buildID,
+				"artifactFilename": artifact.Filename,
+				"sourceURI":        bd.provenance.sourceURI,
+			}).Info("Provenance verified")
+			return nil
+		}
+		failures = append(failures, fmt.Sprintf("%v: %s", err, strings.TrimSpace(string(output))))
+	}
+	return fmt.Errorf("Provenance verification of %s failed (%s)", artifact.Filename, strings.Join(failures, "; "))
+}
@@ -0,0 +1,49 @@
+package buildkiteArtifactDownloader
+
+import (
+	"net/http"
+	"strings"
+)
+
+// provider fetches builds and their artifacts from a CI service. The builds
+// of other services are mapped onto the Buildkite types so filters,
+// verification and destination patterns work the same for all of them
+type provider interface {
+	// getLatestBuildID resolves the latest passed build of the branch
+	getLatestBuildID() (int, error)
+	// getDefaultBranch returns the branch used if none is configured
+	getDefaultBranch() (string, error)
+	// getBuildInfo returns the build bd.buildID with its jobs
+	getBuildInfo() (*BuildkiteBuildInfo, error)
+	// getArtifactInfo lists the artifacts of a job of the build
+	getArtifactInfo(jobID string) ([]BuildkiteBuildArtifactInfo, error)
+	// getJobLog returns the raw log of a job of the build
+	getJobLog(jobID string) ([]byte, error)
+	// authorize adds the credentials of the service to requests for its API
+	authorize(req *http.Request)
+	// cleanup removes temporary files of the build after Start
+	cleanup()
+}
+
+// buildkiteProvider is the default provider
+type buildkiteProvider struct {
+	*BuildkiteHandler
+}
+
+// getDefaultBranch returns DefaultBranch without API token as the web UI
+// does not expose the default branch
+func (p buildkiteProvider) getDefaultBranch() (string, error) {
+	if p.apiToken == "" {
+		return DefaultBranch, nil
+	}
+	return p.BuildkiteHandler.getDefaultBranch()
+}
+
+// authorize adds the API token to requests for the REST API
+func (p buildkiteProvider) authorize(req *http.Request) {
+	if p.apiToken != "" && strings.HasPrefix(req.URL.String(), buildkiteAPIURL) {
+		req.Header.Set("Authorization", "Bearer "+p.apiToken)
+	}
+}
+
+func (p buildkiteProvider) cleanup() {}
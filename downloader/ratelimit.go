@@ -0,0 +1,100 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rateLimiter is a token bucket which allows rate requests per second with
+// bursts of up to burst requests
+type rateLimiter struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	burst := math.Max(1, math.Ceil(rate))
+	return &rateLimiter{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token and returns how long the caller has to wait until
+// it may be used. Tokens are handed out in order, so waiting callers do not
+// overtake each other
+func (l *rateLimiter) reserve() time.Duration {
+	return l.reserveAt(time.Now())
+}
+
+// reserveAt takes a token at now
+func (l *rateLimiter) reserveAt(now time.Time) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	// callers which took their time before waiting for the lock must not
+	// drain the bucket
+	if now.Before(l.last) {
+		now = l.last
+	}
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// rateLimitedTransport delays requests to remote hosts according to the
+// limiter. Unpacked local artifacts are served without delay
+type rateLimitedTransport struct {
+	next    http.RoundTripper
+	limiter *rateLimiter
+}
+
+func (t rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != localFileScheme {
+		if delay := t.limiter.reserve(); delay > 0 {
+			log.WithFields(log.Fields{
+				"host":  req.URL.Host,
+				"delay": delay,
+			}).Debug("Delay request because of rate limit")
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-req.Context().Done():
+				timer.Stop()
+				return nil, req.Context().Err()
+			}
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// SetRateLimit limits the requests of the handler to requestsPerSecond
+// (bursts of up to the next integer are allowed). The limit is shared by
+// the concurrent handlers of a backfill. 0 disables the limit
+func (bd *BuildkiteHandler) SetRateLimit(requestsPerSecond float64) error {
+	if requestsPerSecond < 0 || math.IsNaN(requestsPerSecond) || math.IsInf(requestsPerSecond, 0) {
+		return fmt.Errorf("Invalid rate limit %v", requestsPerSecond)
+	}
+	if requestsPerSecond == 0 {
+		bd.netClient.Transport = bd.transport
+		return nil
+	}
+	bd.netClient.Transport = rateLimitedTransport{
+		next:    bd.transport,
+		limiter: newRateLimiter(requestsPerSecond),
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package buildkiteArtifactDownloader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	tests := []struct {
+		rate float64
+		// reservations are taken at the offsets (ms) after the creation of
+		// the limiter and have to wait for the delays (ms)
+		offsets []time.Duration
+		delays  []time.Duration
+	}{
+		// bursts up to the rate pass at once
+		{2, []time.Duration{0, 0, 0, 0}, []time.Duration{0, 0, 500, 1000}},
+		// the bucket refills while waiting callers are served
+		{2, []time.Duration{0, 0, 0, 0, 1000, 3000}, []time.Duration{0, 0, 500, 1000, 500, 0}},
+		// the bucket does not hold more than the burst
+		{2, []time.Duration{10000, 10000, 10000}, []time.Duration{0, 0, 500}},
+		{0.5, []time.Duration{0, 0, 4000, 4000}, []time.Duration{0, 2000, 0, 2000}},
+		// reservations taken before the previous one refill nothing
+		{1, []time.Duration{1000, 0}, []time.Duration{0, 1000}},
+	}
+	for _, test := range tests {
+		limiter := newRateLimiter(test.rate)
+		start := limiter.last
+		for i, offset := range test.offsets {
+			delay := limiter.reserveAt(start.Add(offset * time.Millisecond))
+			if delay != test.delays[i]*time.Millisecond {
+				t.Errorf("rate %v: reservation %d at %dms waits %v, want %dms", test.rate, i, offset, delay, test.delays[i])
+			}
+		}
+	}
+}
+
+func TestSetRateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	for _, rate := range []float64{-1, -0.5} {
+		if err := NewBuildkiteHandler("org", "pipe").SetRateLimit(rate); err == nil {
+			t.Errorf("rate limit %v accepted", rate)
+		}
+	}
+
+	bd := NewBuildkiteHandler("org", "pipe")
+	if err := bd.SetRateLimit(10); err != nil {
+		t.Fatal(err)
+	}
+	// clones share the limit
+	clone := bd.clone()
+	start := time.Now()
+	for i := 0; i < 15; i++ {
+		handler := bd
+		if i%2 == 1 {
+			handler = clone
+		}
+		if _, err := handler.getData(srv.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 10 requests of the burst and 5 at 10 requests per second
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("15 requests at 10 per second took %v", elapsed)
+	}
+
+	if err := bd.SetRateLimit(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, limited := bd.netClient.Transport.(rateLimitedTransport); limited {
+		t.Error("rate limit 0 did not disable the limit")
+	}
+}
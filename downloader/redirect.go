@@ -0,0 +1,83 @@
+package buildkiteArtifactDownloader
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultMaxRedirects is the count of redirects followed per request.
+	// Artifact downloads are redirected once to a presigned S3 URL
+	DefaultMaxRedirects = 10
+	// maxErrorBodySize limits how much of an error response is read
+	maxErrorBodySize = 4096
+)
+
+// SetMaxRedirects sets the count of redirects followed per request
+func (bd *BuildkiteHandler) SetMaxRedirects(maxRedirects int) {
+	bd.maxRedirects = maxRedirects
+}
+
+// credentialHeaders are the headers the providers authenticate with
+var credentialHeaders = []string{"Authorization", "Circle-Token"}
+
+// checkRedirect limits the redirects and removes the credential headers
+// when a redirect leaves the host of the original request. Presigned S3 URLs
+// carry their own credentials and reject additional ones
+func (bd *BuildkiteHandler) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > bd.maxRedirects {
+		return fmt.Errorf("Stopped after %d redirects", bd.maxRedirects)
+	}
+	// artifacts of other providers may be served from local files which
+	// must not be reachable via redirects
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("Refused redirect to %s URL", req.URL.Scheme)
+	}
+	if req.URL.Host == via[0].URL.Host {
+		return nil
+	}
+	for _, header := range credentialHeaders {
+		if req.Header.Get(header) != "" {
+			log.WithFields(log.Fields{
+				"host":   req.URL.Host,
+				"header": header,
+			}).Debug("Strip credentials on cross-host redirect")
+			req.Header.Del(header)
+		}
+	}
+	return nil
+}
+
+// s3Error is the XML error document returned by S3
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// responseError describes a response with an unexpected status. S3 error
+// documents are parsed so that e.g. expired presigned URLs can be told apart
+// from missing objects. Server errors are retryable
+func responseError(resp *http.Response, artifact BuildkiteBuildArtifactInfo) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	// drain the rest so the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+
+	detail := strings.TrimSpace(string(body))
+	var parsed s3Error
+	if xml.Unmarshal(body, &parsed) == nil && parsed.Code != "" {
+		detail = parsed.Code + ": " + parsed.Message
+	} else if len(detail) > 200 {
+		detail = detail[:200] + "..."
+	}
+	err := fmt.Errorf("Download of %s failed with status %s (%s)", artifact.Filename, resp.Status, detail)
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return retryableError{err}
+	}
+	return err
+}
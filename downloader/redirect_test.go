@@ -0,0 +1,103 @@
+package buildkiteArtifactDownloader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedirectStripsCredentialsOnHostChange(t *testing.T) {
+	received := map[string]http.Header{}
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received["storage"+r.URL.Path] = r.Header
+	}))
+	defer storage.Close()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received["api"+r.URL.Path] = r.Header
+		switch r.URL.Path {
+		case "/same-host":
+			http.Redirect(w, r, "/target", http.StatusFound)
+		case "/cross-host":
+			http.Redirect(w, r, storage.URL+"/target", http.StatusFound)
+		}
+	}))
+	defer api.Close()
+
+	tests := []struct {
+		path        string
+		target      string
+		credentials bool
+	}{
+		{"/same-host", "api/target", true},
+		{"/cross-host", "storage/target", false},
+	}
+	for _, test := range tests {
+		bd := NewBuildkiteHandler("org", "pipe")
+		req, err := http.NewRequest(http.MethodGet, api.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Circle-Token", "secret")
+		resp, err := bd.netClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", test.path, err)
+		}
+		resp.Body.Close()
+
+		if header := received["api"+test.path]; header.Get("Authorization") == "" || header.Get("Circle-Token") == "" {
+			t.Errorf("%s: credentials did not reach the first host", test.path)
+		}
+		header, ok := received[test.target]
+		if !ok {
+			t.Fatalf("%s: redirect to %s was not followed", test.path, test.target)
+		}
+		for _, name := range credentialHeaders {
+			if (header.Get(name) != "") != test.credentials {
+				t.Errorf("%s: %s sent to %s is %q", test.path, name, test.target, header.Get(name))
+			}
+		}
+	}
+}
+
+func TestRedirectRefusesOtherSchemes(t *testing.T) {
+	tests := []struct {
+		location string
+		refused  bool
+	}{
+		{"file:///etc/passwd", true},
+		{"ftp://example.org/app.apk", true},
+		{"gopher://example.org/", true},
+	}
+	for _, test := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Location", test.location)
+			w.WriteHeader(http.StatusFound)
+		}))
+		bd := NewBuildkiteHandler("org", "pipe")
+		_, err := bd.getData(srv.URL)
+		srv.Close()
+		if err == nil || !strings.Contains(err.Error(), "Refused redirect") {
+			t.Errorf("redirect to %s: got %v, want it refused", test.location, err)
+		}
+	}
+}
+
+func TestRedirectLimit(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Redirect(w, r, "/again", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	bd := NewBuildkiteHandler("org", "pipe")
+	bd.SetMaxRedirects(3)
+	if _, err := bd.getData(srv.URL); err == nil {
+		t.Error("endless redirects succeeded")
+	}
+	if requests != 4 {
+		t.Errorf("got %d requests, want the original one and 3 redirects", requests)
+	}
+}
@@ -0,0 +1,85 @@
+package buildkiteArtifactDownloader
+
+import (
+	"strconv"
+	"time"
+)
+
+// ArtifactOutcome describes an artifact which was skipped or failed
+type ArtifactOutcome struct {
+	Filename string `json:"filename"`
+	Reason   string `json:"reason"`
+}
+
+// RunReport summarizes the last call of Start
+type RunReport struct {
+	Org        string            `json:"org"`
+	Pipeline   string            `json:"pipeline"`
+	BuildID    int               `json:"buildID"`
+	CommitID   string            `json:"commitID,omitempty"`
+	Branch     string            `json:"branch,omitempty"`
+	State      string            `json:"state,omitempty"`
+	StartedAt  time.Time         `json:"startedAt"`
+	Downloaded []DownloadResult  `json:"downloaded"`
+	Skipped    []ArtifactOutcome `json:"skipped"`
+	Failed     []ArtifactOutcome `json:"failed"`
+	// Unfinished lists artifacts whose upload was not finished (e.g. new,
+	// error or deleted)
+	Unfinished []ArtifactOutcome `json:"unfinished"`
+	// Changelog lists the commits since the previous processed build
+	Changelog string `json:"changelog,omitempty"`
+	// WebURL links the build in the web UI of the provider (empty if it is
+	// unknown)
+	WebURL string `json:"webURL,omitempty"`
+	// SizeChanges compares the downloads with the artifacts of the same
+	// name of the previous processed build
+	SizeChanges []SizeChange `json:"sizeChanges,omitempty"`
+}
+
+// buildWebURL links the build in the web UI of the provider. Buildkite
+// builds can be linked without their details
+func (bd *BuildkiteHandler) buildWebURL() string {
+	if bd.buildInfo != nil && bd.buildInfo.WebURL != "" {
+		return bd.buildInfo.WebURL
+	}
+	if _, ok := bd.provider.(buildkiteProvider); ok && bd.buildID != 0 {
+		return buildkiteWebURL + "/" + bd.buildkiteOrg + "/" + bd.buildkitePipeline + "/builds/" + strconv.Itoa(bd.buildID)
+	}
+	return ""
+}
+
+// Report returns the summary of the last call of Start
+func (bd *BuildkiteHandler) Report() RunReport {
+	report := RunReport{
+		Org:        bd.buildkiteOrg,
+		Pipeline:   bd.buildkitePipeline,
+		BuildID:    bd.buildID,
+		StartedAt:  bd.startTime,
+		Downloaded: bd.results,
+		Skipped:    bd.skipped,
+		Failed:     bd.failed,
+		Unfinished: bd.unfinished,
+		Changelog:  bd.changelogText,
+		WebURL:     bd.buildWebURL(),
+	}
+	report.SizeChanges = bd.sizeChanges
+	if bd.buildInfo != nil {
+		report.CommitID = bd.buildInfo.CommitID
+		report.Branch = bd.buildInfo.Branch
+		report.State = bd.buildInfo.State
+	}
+	// wrapper scripts should not need to handle null
+	if report.Downloaded == nil {
+		report.Downloaded = []DownloadResult{}
+	}
+	if report.Skipped == nil {
+		report.Skipped = []ArtifactOutcome{}
+	}
+	if report.Failed == nil {
+		report.Failed = []ArtifactOutcome{}
+	}
+	if report.Unfinished == nil {
+		report.Unfinished = []ArtifactOutcome{}
+	}
+	return report
+}
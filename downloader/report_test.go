@@ -0,0 +1,84 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReportWebURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/org/repo/actions/runs/7":
+			fmt.Fprint(w, `{"id": 7, "run_number": 3, "status": "completed", "conclusion": "success", "html_url": "https://github.com/org/repo/actions/runs/7"}`)
+		case "/org/project/_apis/build/builds/9":
+			fmt.Fprint(w, `{"id": 9, "status": "completed", "result": "succeeded", "_links": {"web": {"href": "https://dev.azure.com/org/project/_build/results?buildId=9"}}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	github := NewBuildkiteHandler("org", "repo")
+	if err := github.SetGitHubActions(srv.URL, "", "token"); err != nil {
+		t.Fatal(err)
+	}
+	github.SetBuildID(7)
+	azure := NewBuildkiteHandler("org", "project")
+	if err := azure.SetAzureDevOps(srv.URL, "", "token"); err != nil {
+		t.Fatal(err)
+	}
+	azure.SetBuildID(9)
+
+	tests := []struct {
+		bd     *BuildkiteHandler
+		webURL string
+	}{
+		{github, "https://github.com/org/repo/actions/runs/7"},
+		{azure, "https://dev.azure.com/org/project/_build/results?buildId=9"},
+	}
+	for _, test := range tests {
+		buildInfo, err := test.bd.provider.getBuildInfo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.bd.buildInfo = buildInfo
+		if webURL := test.bd.Report().WebURL; webURL != test.webURL {
+			t.Errorf("got %s, want %s", webURL, test.webURL)
+		}
+	}
+
+	// builds of other providers are not linked to Buildkite without details
+	github.buildInfo = nil
+	if webURL := github.Report().WebURL; webURL != "" {
+		t.Errorf("GitHub run without details links %s", webURL)
+	}
+	buildkite := NewBuildkiteHandler("org", "pipe")
+	buildkite.SetBuildID(12)
+	if webURL := buildkite.Report().WebURL; webURL != "https://buildkite.com/org/pipe/builds/12" {
+		t.Errorf("got %s for a Buildkite build", webURL)
+	}
+}
+
+func TestCircleCIPipelineURL(t *testing.T) {
+	tests := []struct {
+		apiURL string
+		vcs    string
+		webURL string
+	}{
+		{"", "", "https://app.circleci.com/pipelines/github/org/project/42"},
+		{"", "bb", "https://app.circleci.com/pipelines/bitbucket/org/project/42"},
+		{"", "circleci", "https://app.circleci.com/pipelines/circleci/org/project/42"},
+		{"https://circleci.example.org/api/v2", "gh", "https://circleci.example.org/pipelines/github/org/project/42"},
+	}
+	for _, test := range tests {
+		bd := NewBuildkiteHandler("org", "project")
+		if err := bd.SetCircleCI(test.apiURL, test.vcs, "", "token"); err != nil {
+			t.Fatal(err)
+		}
+		if webURL := bd.provider.(*circleCIProvider).pipelineURL(42); webURL != test.webURL {
+			t.Errorf("%s with %s: got %s, want %s", test.apiURL, test.vcs, webURL, test.webURL)
+		}
+	}
+}
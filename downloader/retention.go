@@ -0,0 +1,264 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rePlaceholder matches all legacy placeholders of the destination pattern
+var rePlaceholder = regexp.MustCompile(`<[A-Za-z]+(?::[^>]+)?>`)
+
+// reBackupSuffix matches the suffix of backups of replaced destinations
+var reBackupSuffix = regexp.MustCompile(`\.bak-[0-9]{8}-[0-9]{6}$`)
+
+// companionOf returns the download path belongs to if it is one of the files
+// written next to downloads (sidecar, torrent or backup)
+func companionOf(path string) (string, bool) {
+	for _, suffix := range []string{SidecarSuffix, TorrentSuffix} {
+		if strings.HasSuffix(path, suffix) {
+			return strings.TrimSuffix(path, suffix), true
+		}
+	}
+	if loc := reBackupSuffix.FindStringIndex(path); loc != nil {
+		return path[:loc[0]], true
+	}
+	return "", false
+}
+
+// SetRetention prunes the downloads of older builds after new artifacts got
+// downloaded. Files of the newest keepBuilds builds and files younger than
+// keepDays days are kept. A value of 0 disables the corresponding rule. The
+// destination pattern, which has to be set before, has to contain <buildID>
+// or <buildNumber>
+func (bd *BuildkiteHandler) SetRetention(keepBuilds int, keepDays int) error {
+	if keepBuilds < 0 || keepDays < 0 {
+		return fmt.Errorf("Retention values must not be negative")
+	}
+	if keepBuilds > 0 || keepDays > 0 {
+		_, _, hasBuild, err := bd.destinationMatcher()
+		if err != nil {
+			return err
+		}
+		if !hasBuild {
+			return fmt.Errorf("Retention requires <buildID> or <buildNumber> in the destination pattern")
+		}
+	}
+	bd.keepBuilds = keepBuilds
+	bd.keepDays = keepDays
+	return nil
+}
+
+// destinationMatcher converts the destination pattern into a regular
+// expression which matches the destinations of all builds of the pipeline.
+// The first submatch is the build number (if the pattern contains it). The
+// returned directory is the deepest one which does not contain placeholders
+func (bd *BuildkiteHandler) destinationMatcher() (*regexp.Regexp, string, bool, error) {
+	if bd.destTemplate != nil {
+		return nil, "", false, fmt.Errorf("Retention is not supported for destination templates")
+	}
+	pattern := filepath.Clean(bd.getDestinationPattern())
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	hasBuild := false
+	last := 0
+	for _, loc := range rePlaceholder.FindAllStringIndex(pattern, -1) {
+		expr.WriteString(regexp.QuoteMeta(pattern[last:loc[0]]))
+		switch placeholder := pattern[loc[0]:loc[1]]; {
+		case placeholder == "<buildID>" || placeholder == "<buildNumber>":
+			if hasBuild {
+				expr.WriteString(`[0-9]+`)
+			} else {
+				expr.WriteString(`([0-9]+)`)
+				hasBuild = true
+			}
+		case placeholder == "<pipeline>":
+			expr.WriteString(regexp.QuoteMeta(bd.buildkitePipeline))
+		case placeholder == "<org>":
+			expr.WriteString(regexp.QuoteMeta(bd.buildkiteOrg))
+		case placeholder == "<commitFull>" || reCommitPlaceholder.MatchString(placeholder):
+			expr.WriteString(`(?:[0-9a-f]+|unknown)`)
+		case reDatePlaceholder.MatchString(placeholder):
+			// layouts may contain separators
+			expr.WriteString(`.+`)
+		default:
+			expr.WriteString(`[^/]+`)
+		}
+		last = loc[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(pattern[last:]))
+	expr.WriteString("$")
+
+	reDestination, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	root := pattern
+	if loc := rePlaceholder.FindStringIndex(pattern); loc != nil {
+		root = filepath.Dir(pattern[:loc[0]] + "x")
+	} else {
+		root = filepath.Dir(pattern)
+	}
+	return reDestination, root, hasBuild, nil
+}
+
+// CheckSharedRetention refuses retention for pipelines which share a
+// destination pattern without <pipeline> as pruning one of them would remove
+// the downloads of the others
+func CheckSharedRetention(handlers []*BuildkiteHandler) error {
+	pipelines := map[string][]string{}
+	retention := map[string]bool{}
+	for _, bd := range handlers {
+		pattern := filepath.Clean(bd.getDestinationPattern())
+		if strings.Contains(pattern, "<pipeline>") {
+			continue
+		}
+		pipelines[pattern] = append(pipelines[pattern], bd.buildkiteOrg+"/"+bd.buildkitePipeline)
+		if bd.keepBuilds > 0 || bd.keepDays > 0 {
+			retention[pattern] = true
+		}
+	}
+	for pattern, names := range pipelines {
+		if retention[pattern] && len(names) > 1 {
+			return fmt.Errorf("Retention requires <pipeline> in the destination pattern %s as it is shared by %s", pattern, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// retainedFile is a download of a previous run found by applyRetention
+type retainedFile struct {
+	path    string
+	build   int
+	modTime time.Time
+}
+
+// applyRetention removes files matching the destination pattern which are
+// neither part of the newest builds nor young enough to be kept. Files of
+// the current build are never removed. The pattern has to contain the build
+// number as other files below its root could be matched otherwise. Sidecars,
+// torrents and backups are removed together with their download
+func (bd *BuildkiteHandler) applyRetention() error {
+	if bd.keepBuilds == 0 && bd.keepDays == 0 {
+		return nil
+	}
+	reDestination, root, hasBuild, err := bd.destinationMatcher()
+	if err != nil {
+		return err
+	}
+	if !hasBuild {
+		return fmt.Errorf("Retention requires <buildID> or <buildNumber> in the destination pattern")
+	}
+
+	var files []retainedFile
+	companions := map[string][]string{}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if download, ok := companionOf(path); ok {
+			companions[download] = append(companions[download], path)
+			return nil
+		}
+		match := reDestination.FindStringSubmatch(path)
+		if match == nil {
+			return nil
+		}
+		build, _ := strconv.Atoi(match[1])
+		files = append(files, retainedFile{path: path, build: build, modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Cannot scan %s for old downloads (%v)", root, err)
+	}
+
+	for _, file := range bd.expiredFiles(files, time.Now()) {
+		if err := os.Remove(file.path); err != nil {
+			log.WithFields(log.Fields{
+				"buildID": bd.buildID,
+				"file":    file.path,
+				"error":   err,
+			}).Warn("Cannot remove old download")
+			continue
+		}
+		for _, companion := range companions[file.path] {
+			if err := os.Remove(companion); err != nil {
+				log.WithFields(log.Fields{
+					"buildID": bd.buildID,
+					"file":    companion,
+					"error":   err,
+				}).Warn("Cannot remove file of old download")
+			}
+		}
+		log.WithFields(log.Fields{
+			"buildID": bd.buildID,
+			"file":    file.path,
+			"build":   file.build,
+		}).Info("Old download removed")
+		removeEmptyParents(filepath.Dir(file.path), root)
+	}
+	return nil
+}
+
+// expiredFiles returns the files which are neither part of the current
+// build, the newest keepBuilds builds nor younger than keepDays at now
+func (bd *BuildkiteHandler) expiredFiles(files []retainedFile, now time.Time) []retainedFile {
+	keptBuilds := map[int]bool{}
+	if bd.keepBuilds > 0 {
+		builds := map[int]bool{}
+		var numbers []int
+		for _, file := range files {
+			if !builds[file.build] {
+				builds[file.build] = true
+				numbers = append(numbers, file.build)
+			}
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(numbers)))
+		for i := 0; i < len(numbers) && i < bd.keepBuilds; i++ {
+			keptBuilds[numbers[i]] = true
+		}
+	}
+	cutoff := now.AddDate(0, 0, -bd.keepDays)
+
+	var expired []retainedFile
+	for _, file := range files {
+		if file.build == bd.buildID {
+			continue
+		}
+		if bd.keepBuilds > 0 && keptBuilds[file.build] {
+			continue
+		}
+		if bd.keepDays > 0 && file.modTime.After(cutoff) {
+			continue
+		}
+		expired = append(expired, file)
+	}
+	return expired
+}
+
+// removeEmptyParents removes dir and its parents up to root as long as they
+// are empty
+func removeEmptyParents(dir string, root string) {
+	for {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			return
+		}
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,221 @@
+package buildkiteArtifactDownloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestDestinationMatcher(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		root     string
+		hasBuild bool
+		path     string
+		match    bool
+		build    string
+	}{
+		{"repo/<buildID>-<commitID>-<artifactFilename>", "repo", true, "repo/42-abcdef1-app.apk", true, "42"},
+		{"repo/<buildID>-<commitID>-<artifactFilename>", "repo", true, "repo/index-v1.json", false, ""},
+		{"repo/<buildID>-<commitID>-<artifactFilename>", "repo", true, "repo/42-unknown-app.apk", true, "42"},
+		{"repo/<pipeline>/<buildNumber>/<artifactFilename>", "repo", true, "repo/pipe/7/app.apk", true, "7"},
+		{"repo/<pipeline>/<buildNumber>/<artifactFilename>", "repo", true, "repo/other/7/app.apk", false, ""},
+		{"repo/<org>-<buildID>/<date:2006>/<artifactFilename>", "repo", true, "repo/org-3/2024/a/b.apk", true, "3"},
+		{"repo/<buildID>/<branch>/<artifactFilename>", "repo", true, "repo/3/main/app.apk", true, "3"},
+		{"repo/<buildID>/<branch>/<artifactFilename>", "repo", true, "repo/main/app.apk", false, ""},
+		// the file name does not span directories
+		{"repo/<buildID>/<artifactFilename>", "repo", true, "repo/3/sub/app.apk", false, ""},
+		{"repo/<buildID>-<artifactFilename>", "repo", true, "repo/3-app.apk/nested", false, ""},
+		{"repo/<artifactFilename>", "repo", false, "repo/SHA256SUMS", true, ""},
+		{"/srv/dl/app-<buildID>.apk", "/srv/dl", true, "/srv/dl/app-12.apk", true, "12"},
+	}
+	for _, test := range tests {
+		bd := NewBuildkiteHandler("org", "pipe")
+		if err := bd.SetDestinationPattern(test.pattern); err != nil {
+			t.Fatal(err)
+		}
+		reDestination, root, hasBuild, err := bd.destinationMatcher()
+		if err != nil {
+			t.Fatalf("%s: %v", test.pattern, err)
+		}
+		if root != test.root || hasBuild != test.hasBuild {
+			t.Errorf("%s: got root %s and build %v, want %s and %v", test.pattern, root, hasBuild, test.root, test.hasBuild)
+		}
+		match := reDestination.FindStringSubmatch(test.path)
+		if (match != nil) != test.match {
+			t.Errorf("%s: match of %s is %v, want %v", test.pattern, test.path, match != nil, test.match)
+			continue
+		}
+		if match != nil && hasBuild && match[1] != test.build {
+			t.Errorf("%s: build of %s is %s, want %s", test.pattern, test.path, match[1], test.build)
+		}
+	}
+}
+
+func TestDestinationMatcherTemplate(t *testing.T) {
+	bd := NewBuildkiteHandler("org", "pipe")
+	if err := bd.SetDestinationPattern("repo/{{.BuildID}}/{{.Artifact.Filename}}"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := bd.destinationMatcher(); err == nil {
+		t.Error("templates must not be supported")
+	}
+}
+
+func TestSetRetentionRequiresBuild(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		keepBuilds int
+		keepDays   int
+		valid      bool
+	}{
+		{"repo/<buildID>-<artifactFilename>", 3, 0, true},
+		{"repo/<buildID>-<artifactFilename>", 0, 7, true},
+		{"repo/<artifactFilename>", 3, 0, false},
+		{"repo/<artifactFilename>", 0, 7, false},
+		{"repo/<artifactFilename>", 0, 0, true},
+		{"repo/<buildID>-<artifactFilename>", -1, 0, false},
+	}
+	for _, test := range tests {
+		bd := NewBuildkiteHandler("org", "pipe")
+		if err := bd.SetDestinationPattern(test.pattern); err != nil {
+			t.Fatal(err)
+		}
+		err := bd.SetRetention(test.keepBuilds, test.keepDays)
+		if (err == nil) != test.valid {
+			t.Errorf("%s with keepBuilds %d and keepDays %d: got error %v", test.pattern, test.keepBuilds, test.keepDays, err)
+		}
+	}
+}
+
+func TestExpiredFiles(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	files := []retainedFile{
+		{path: "10", build: 10, modTime: now.AddDate(0, 0, -1)},
+		{path: "9", build: 9, modTime: now.AddDate(0, 0, -3)},
+		{path: "8", build: 8, modTime: now.AddDate(0, 0, -10)},
+		{path: "8-sidecar", build: 8, modTime: now.AddDate(0, 0, -10)},
+		{path: "7", build: 7, modTime: now.AddDate(0, 0, -20)},
+		// the current build is kept even if it is old
+		{path: "5", build: 5, modTime: now.AddDate(0, 0, -30)},
+	}
+	tests := []struct {
+		keepBuilds int
+		keepDays   int
+		expired    []string
+	}{
+		{2, 0, []string{"8", "8-sidecar", "7"}},
+		{0, 5, []string{"8", "8-sidecar", "7"}},
+		{0, 15, []string{"7"}},
+		// files are kept if any rule keeps them
+		{1, 5, []string{"8", "8-sidecar", "7"}},
+		{4, 5, nil},
+		{10, 0, nil},
+	}
+	for _, test := range tests {
+		bd := NewBuildkiteHandler("org", "pipe")
+		bd.keepBuilds = test.keepBuilds
+		bd.keepDays = test.keepDays
+		bd.SetBuildID(5)
+		var expired []string
+		for _, file := range bd.expiredFiles(files, now) {
+			expired = append(expired, file.path)
+		}
+		if !reflect.DeepEqual(expired, test.expired) {
+			t.Errorf("keepBuilds %d and keepDays %d: got %v, want %v", test.keepBuilds, test.keepDays, expired, test.expired)
+		}
+	}
+}
+
+func TestApplyRetentionRemovesCompanions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retention-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []string{
+		"1-app.apk", "1-app.apk" + SidecarSuffix, "1-app.apk" + TorrentSuffix, "1-app.apk.bak-20240101-120000",
+		"2-app.apk", "2-app.apk" + SidecarSuffix,
+		"3-app.apk", "3-app.apk" + SidecarSuffix, "3-app.apk.bak-20240101-120000",
+		// not written by the pipeline
+		"notes/1-readme.txt", "index-v1.json",
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bd := NewBuildkiteHandler("org", "pipe")
+	if err := bd.SetDestinationPattern(dir + "/<buildID>-<artifactFilename>"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bd.SetRetention(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	bd.SetBuildID(3)
+	if err := bd.applyRetention(); err != nil {
+		t.Fatal(err)
+	}
+
+	var remaining []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			rel, _ := filepath.Rel(dir, path)
+			remaining = append(remaining, rel)
+		}
+		return nil
+	})
+	want := []string{"3-app.apk", "3-app.apk" + SidecarSuffix, "3-app.apk.bak-20240101-120000", "index-v1.json", "notes/1-readme.txt"}
+	sort.Strings(remaining)
+	sort.Strings(want)
+	if !reflect.DeepEqual(remaining, want) {
+		t.Errorf("got %v, want %v", remaining, want)
+	}
+}
+
+func TestCheckSharedRetention(t *testing.T) {
+	type pipeline struct {
+		name    string
+		pattern string
+		keep    int
+	}
+	tests := []struct {
+		pipelines []pipeline
+		valid     bool
+	}{
+		{[]pipeline{{"a", "repo/<buildID>-<artifactFilename>", 3}}, true},
+		{[]pipeline{{"a", "repo/<buildID>-<artifactFilename>", 3}, {"b", "repo/<buildID>-<artifactFilename>", 3}}, false},
+		// one pipeline without retention still loses its downloads
+		{[]pipeline{{"a", "repo/<buildID>-<artifactFilename>", 3}, {"b", "repo/<buildID>-<artifactFilename>", 0}}, false},
+		{[]pipeline{{"a", "repo/<buildID>-<artifactFilename>", 0}, {"b", "repo/<buildID>-<artifactFilename>", 0}}, true},
+		{[]pipeline{{"a", "repo/<pipeline>/<buildID>-<artifactFilename>", 3}, {"b", "repo/<pipeline>/<buildID>-<artifactFilename>", 3}}, true},
+		{[]pipeline{{"a", "repo/a/<buildID>-<artifactFilename>", 3}, {"b", "repo/b/<buildID>-<artifactFilename>", 3}}, true},
+		{[]pipeline{{"a", "repo/<buildID>-<artifactFilename>", 3}, {"b", "./repo/<buildID>-<artifactFilename>", 3}}, false},
+	}
+	for i, test := range tests {
+		var handlers []*BuildkiteHandler
+		for _, p := range test.pipelines {
+			bd := NewBuildkiteHandler("org", p.name)
+			if err := bd.SetDestinationPattern(p.pattern); err != nil {
+				t.Fatal(err)
+			}
+			if err := bd.SetRetention(p.keep, 0); err != nil {
+				t.Fatal(err)
+			}
+			handlers = append(handlers, bd)
+		}
+		if err := CheckSharedRetention(handlers); (err == nil) != test.valid {
+			t.Errorf("case %d: got %v", i, err)
+		}
+	}
+}
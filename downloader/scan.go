@@ -0,0 +1,90 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SetScanCommand configures a command (e.g. "clamscan --no-summary") which
+// gets the downloaded file appended as last argument. Artifacts for which
+// the command exits with a non-zero code are rejected
+func (bd *BuildkiteHandler) SetScanCommand(command string) {
+	bd.scanCommand = strings.Fields(command)
+}
+
+// SetQuarantineDir keeps rejected artifacts of the scan in dir instead of
+// deleting them
+func (bd *BuildkiteHandler) SetQuarantineDir(dir string) error {
+	if dir == "" {
+		bd.quarantineDir = ""
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("Cannot create quarantine directory (%v)", err)
+	}
+	bd.quarantineDir = dir
+	return nil
+}
+
+// scanArtifact runs the scan command on the downloaded file at path
+func (bd *BuildkiteHandler) scanArtifact(artifact BuildkiteBuildArtifactInfo, path string) error {
+	if len(bd.scanCommand) == 0 {
+		return nil
+	}
+	args := append(append([]string{}, bd.scanCommand[1:]...), path)
+	output, err := exec.Command(bd.scanCommand[0], args...).CombinedOutput()
+	if err == nil {
+		log.WithFields(log.Fields{
+			"buildID":          bd.buildID,
+			"artifactFilename": artifact.Filename,
+			"scanner":          bd.scanCommand[0],
+		}).Info("Scan passed")
+		return nil
+	}
+
+	scanErr := fmt.Errorf("Scan of %s failed (%v): %s", artifact.Filename, err, strings.TrimSpace(string(output)))
+	if bd.quarantineDir != "" {
+		quarantinePath := filepath.Join(bd.quarantineDir, strconv.Itoa(bd.buildID)+"-"+filepath.Base(artifact.Filename))
+		if err := copyFile(path, quarantinePath); err != nil {
+			log.WithFields(log.Fields{
+				"buildID":          bd.buildID,
+				"artifactFilename": artifact.Filename,
+				"quarantine":       quarantinePath,
+				"error":            err,
+			}).Error("Cannot quarantine artifact")
+		} else {
+			log.WithFields(log.Fields{
+				"buildID":          bd.buildID,
+				"artifactFilename": artifact.Filename,
+				"quarantine":       quarantinePath,
+			}).Warn("Artifact quarantined")
+		}
+	}
+	return scanErr
+}
+
+// copyFile copies src to the new file dest
+func copyFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,80 @@
+package buildkiteArtifactDownloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	common "github.com/krombel/buildkite-artifact-downloader/common"
+)
+
+const (
+	// SidecarSuffix is appended to the destination of an artifact to get the
+	// path of its metadata file
+	SidecarSuffix = ".meta.json"
+)
+
+// ArtifactMetadata describes where a downloaded artifact came from
+type ArtifactMetadata struct {
+	Org          string          `json:"org"`
+	Pipeline     string          `json:"pipeline"`
+	BuildNumber  int             `json:"buildNumber"`
+	CommitID     string          `json:"commitID"`
+	Branch       string          `json:"branch"`
+	JobID        string          `json:"jobID,omitempty"`
+	JobName      string          `json:"jobName,omitempty"`
+	Filename     string          `json:"filename"`
+	Destination  string          `json:"destination"`
+	SourceURL    string          `json:"sourceURL"`
+	Size         int64           `json:"size"`
+	SHA1         string          `json:"sha1"`
+	SHA256       string          `json:"sha256"`
+	APK          *common.APKInfo `json:"apk,omitempty"`
+	CID          string          `json:"cid,omitempty"`
+	DownloadedAt time.Time       `json:"downloadedAt"`
+}
+
+// SetWriteSidecars enables writing <destination>.meta.json next to every
+// downloaded artifact
+func (bd *BuildkiteHandler) SetWriteSidecars(enabled bool) {
+	bd.writeSidecars = enabled
+}
+
+// artifactMetadata collects the metadata of a finished download
+func (bd *BuildkiteHandler) artifactMetadata(buildInfo BuildkiteBuildInfo, artifact BuildkiteBuildArtifactInfo, result *DownloadResult) ArtifactMetadata {
+	buildNumber := buildInfo.Number
+	if buildNumber == 0 {
+		buildNumber = bd.buildID
+	}
+	return ArtifactMetadata{
+		Org:          bd.buildkiteOrg,
+		Pipeline:     bd.buildkitePipeline,
+		BuildNumber:  buildNumber,
+		CommitID:     buildInfo.CommitID,
+		Branch:       buildInfo.Branch,
+		JobID:        artifact.job.ID,
+		JobName:      artifact.job.Name,
+		Filename:     artifact.Filename,
+		Destination:  result.Destination,
+		SourceURL:    artifact.downloadURL(),
+		Size:         result.Size,
+		SHA1:         result.SHA1,
+		SHA256:       result.SHA256,
+		APK:          result.APK,
+		CID:          result.CID,
+		DownloadedAt: time.Now().UTC(),
+	}
+}
+
+// writeSidecar stores the metadata of the download next to its destination
+func (bd *BuildkiteHandler) writeSidecar(metadata ArtifactMetadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	sidecarPath := metadata.Destination + SidecarSuffix
+	if err := writeFileAtomic(sidecarPath, append(data, '\n'), bd.fileMode); err != nil {
+		return fmt.Errorf("Cannot write %s (%v)", sidecarPath, err)
+	}
+	return bd.chown(sidecarPath)
+}
@@ -0,0 +1,105 @@
+package buildkiteArtifactDownloader
+
+import (
+	"fmt"
+
+	common "github.com/krombel/buildkite-artifact-downloader/common"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultSizeGrowthWarning is the growth in percent which gets flagged
+	DefaultSizeGrowthWarning = 10.0
+)
+
+// SizeChange compares a download with the artifact of the same name of the
+// previous processed build
+type SizeChange struct {
+	Filename     string `json:"filename"`
+	PreviousSize int64  `json:"previousSize"`
+	Size         int64  `json:"size"`
+	// Growth is the change of the size in percent
+	Growth float64 `json:"growth"`
+	// Changed is set if the checksums differ
+	Changed bool `json:"changed"`
+	// Flagged is set if the growth exceeds the warning threshold
+	Flagged bool `json:"flagged"`
+}
+
+// String describes the change, e.g. "app.apk: 10.0 MiB -> 11.5 MiB (+15.0%)"
+func (c SizeChange) String() string {
+	return fmt.Sprintf("%s: %s -> %s (%+.1f%%)", c.Filename, common.FormatSize(c.PreviousSize), common.FormatSize(c.Size), c.Growth)
+}
+
+// SetSizeDiff compares the downloads with the artifacts of the same name of
+// the previous processed build (from the state file) and flags downloads
+// which grew by more than warnGrowth percent. The comparison is added to the
+// run report
+func (bd *BuildkiteHandler) SetSizeDiff(enabled bool, warnGrowth float64) error {
+	if !enabled {
+		bd.sizeDiff = false
+		return nil
+	}
+	if bd.stateFile == "" {
+		return fmt.Errorf("Comparing sizes requires a state file")
+	}
+	if warnGrowth < 0 {
+		return fmt.Errorf("Invalid size growth warning %.1f%%", warnGrowth)
+	}
+	bd.sizeDiff = true
+	bd.sizeGrowthWarning = warnGrowth
+	return nil
+}
+
+// compareSizes compares the downloads with the previous processed build
+func (bd *BuildkiteHandler) compareSizes() {
+	previous, err := bd.previousBuild()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"buildID": bd.buildID,
+			"error":   err,
+		}).Warn("Cannot read previous build for size comparison")
+		return
+	}
+	if previous == nil {
+		log.WithFields(log.Fields{
+			"buildID": bd.buildID,
+		}).Info("No previous build to compare sizes with")
+		return
+	}
+	previousArtifacts := make(map[string]ProcessedArtifact)
+	for _, artifact := range previous.Artifacts {
+		previousArtifacts[artifact.Filename] = artifact
+	}
+
+	for _, result := range bd.results {
+		artifact, ok := previousArtifacts[result.Filename]
+		if !ok {
+			continue
+		}
+		change := SizeChange{
+			Filename:     result.Filename,
+			PreviousSize: artifact.Size,
+			Size:         result.Size,
+			Changed:      artifact.SHA256 != result.SHA256,
+		}
+		if artifact.Size > 0 {
+			change.Growth = float64(result.Size-artifact.Size) * 100 / float64(artifact.Size)
+		}
+		change.Flagged = change.Growth > bd.sizeGrowthWarning
+		fields := log.Fields{
+			"buildID":          bd.buildID,
+			"previousBuildID":  previous.BuildNumber,
+			"artifactFilename": result.Filename,
+			"previousSize":     artifact.Size,
+			"size":             result.Size,
+			"growth":           fmt.Sprintf("%+.1f%%", change.Growth),
+		}
+		if change.Flagged {
+			log.WithFields(fields).Warn("Artifact grew unexpectedly")
+		} else {
+			log.WithFields(fields).Info("Artifact size compared")
+		}
+		bd.sizeChanges = append(bd.sizeChanges, change)
+	}
+}
@@ -0,0 +1,222 @@
+package buildkiteArtifactDownloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxStateBuilds is the count of builds remembered per pipeline. The
+	// newest builds by number are kept
+	maxStateBuilds = 100
+)
+
+// stateLock serializes updates of the state file by concurrent handlers
+// (e.g. of a backfill)
+var stateLock sync.Mutex
+
+// ErrNothingNew is returned by Start if only new builds are processed and the
+// build was processed already
+var ErrNothingNew = errors.New("Build was processed already")
+
+// ProcessedArtifact is an artifact of a processed build
+type ProcessedArtifact struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+// ProcessedBuild is a build whose artifacts got downloaded completely
+type ProcessedBuild struct {
+	BuildNumber int                 `json:"buildNumber"`
+	CommitID    string              `json:"commitID"`
+	Branch      string              `json:"branch"`
+	ProcessedAt time.Time           `json:"processedAt"`
+	Artifacts   []ProcessedArtifact `json:"artifacts"`
+}
+
+// pipelineState holds the processed builds of a pipeline, ordered by build
+// number with the newest first
+type pipelineState struct {
+	Builds []ProcessedBuild `json:"builds"`
+}
+
+// stateStore is the content of the state file. Pipelines are keyed by
+// <org>/<pipeline>
+type stateStore struct {
+	Pipelines map[string]*pipelineState `json:"pipelines"`
+}
+
+// SetStateFile records processed builds in the JSON file at path. An empty
+// path disables the state store
+func (bd *BuildkiteHandler) SetStateFile(path string) {
+	bd.stateFile = path
+}
+
+// SetOnlyNew skips builds which are recorded in the state file already.
+// Start returns ErrNothingNew for them
+func (bd *BuildkiteHandler) SetOnlyNew(onlyNew bool) error {
+	if onlyNew && bd.stateFile == "" {
+		return fmt.Errorf("Processing only new builds requires a state file")
+	}
+	bd.onlyNew = onlyNew
+	return nil
+}
+
+func (bd *BuildkiteHandler) stateKey() string {
+	return bd.buildkiteOrg + "/" + bd.buildkitePipeline
+}
+
+// readState loads the state file. A missing file is an empty state
+func readState(path string) (*stateStore, error) {
+	state := &stateStore{Pipelines: make(map[string]*pipelineState)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("Cannot parse state file %s (%v)", path, err)
+	}
+	if state.Pipelines == nil {
+		state.Pipelines = make(map[string]*pipelineState)
+	}
+	return state, nil
+}
+
+// ProcessedBuilds returns the processed builds of the pipeline, newest first
+func (bd *BuildkiteHandler) ProcessedBuilds() ([]ProcessedBuild, error) {
+	if bd.stateFile == "" {
+		return nil, nil
+	}
+	state, err := readState(bd.stateFile)
+	if err != nil {
+		return nil, err
+	}
+	if pipeline, ok := state.Pipelines[bd.stateKey()]; ok {
+		return pipeline.Builds, nil
+	}
+	return nil, nil
+}
+
+// processedBuild returns the state of buildNumber or nil if it was not
+// processed yet
+func (bd *BuildkiteHandler) processedBuild(buildNumber int) (*ProcessedBuild, error) {
+	builds, err := bd.ProcessedBuilds()
+	if err != nil {
+		return nil, err
+	}
+	for i := range builds {
+		if builds[i].BuildNumber == buildNumber {
+			return &builds[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// previousBuild returns the processed build with downloads which precedes
+// the current one by build number or nil if there is none. Builds can be
+// processed in any order (e.g. by a backfill), so the order of the state
+// file does not tell the predecessor
+func (bd *BuildkiteHandler) previousBuild() (*ProcessedBuild, error) {
+	builds, err := bd.ProcessedBuilds()
+	if err != nil {
+		return nil, err
+	}
+	var previous *ProcessedBuild
+	for i := range builds {
+		if builds[i].BuildNumber < bd.buildID && len(builds[i].Artifacts) > 0 &&
+			(previous == nil || builds[i].BuildNumber > previous.BuildNumber) {
+			previous = &builds[i]
+		}
+	}
+	return previous, nil
+}
+
+// mergeProcessedBuild replaces or adds build in builds and returns them
+// ordered by build number, newest first. Only the newest limit builds are
+// kept unless limit is 0
+func mergeProcessedBuild(builds []ProcessedBuild, build ProcessedBuild, limit int) []ProcessedBuild {
+	merged := []ProcessedBuild{build}
+	for _, existing := range builds {
+		if existing.BuildNumber != build.BuildNumber {
+			merged = append(merged, existing)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].BuildNumber > merged[j].BuildNumber
+	})
+	if limit > 0 && len(merged) > limit {
+		merged = merged[:limit]
+	}
+	return merged
+}
+
+// recordProcessedBuild stores the current build with its downloads in the
+// state file. An older entry of the same build gets replaced unless nothing
+// was downloaded this time
+func (bd *BuildkiteHandler) recordProcessedBuild() error {
+	if bd.stateFile == "" || bd.buildInfo == nil {
+		return nil
+	}
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	state, err := readState(bd.stateFile)
+	if err != nil {
+		return err
+	}
+
+	build := ProcessedBuild{
+		BuildNumber: bd.buildID,
+		CommitID:    bd.buildInfo.CommitID,
+		Branch:      bd.buildInfo.Branch,
+		ProcessedAt: time.Now().UTC(),
+		Artifacts:   []ProcessedArtifact{},
+	}
+	for _, result := range bd.results {
+		build.Artifacts = append(build.Artifacts, ProcessedArtifact{
+			Filename: result.Filename,
+			Size:     result.Size,
+			SHA256:   result.SHA256,
+		})
+	}
+
+	pipeline, ok := state.Pipelines[bd.stateKey()]
+	if !ok {
+		pipeline = &pipelineState{}
+		state.Pipelines[bd.stateKey()] = pipeline
+	}
+	for _, existing := range pipeline.Builds {
+		// keep the artifacts of the run which actually downloaded them
+		if existing.BuildNumber == build.BuildNumber && len(bd.results) == 0 {
+			return nil
+		}
+	}
+	limit := maxStateBuilds
+	if bd.uncappedState {
+		limit = 0
+	}
+	pipeline.Builds = mergeProcessedBuild(pipeline.Builds, build, limit)
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(bd.stateFile, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("Cannot write state file %s (%v)", bd.stateFile, err)
+	}
+	log.WithFields(log.Fields{
+		"buildID":   bd.buildID,
+		"stateFile": bd.stateFile,
+	}).Debug("Build recorded as processed")
+	return nil
+}
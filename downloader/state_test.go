@@ -0,0 +1,95 @@
+package buildkiteArtifactDownloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func buildNumbers(builds []ProcessedBuild) []int {
+	var numbers []int
+	for _, build := range builds {
+		numbers = append(numbers, build.BuildNumber)
+	}
+	return numbers
+}
+
+func TestMergeProcessedBuild(t *testing.T) {
+	tests := []struct {
+		existing []int
+		build    int
+		limit    int
+		want     []int
+	}{
+		{nil, 5, 3, []int{5}},
+		{[]int{9, 8, 7}, 10, 3, []int{10, 9, 8}},
+		// builds of a backfill are recorded newest first
+		{[]int{9, 8, 7}, 3, 3, []int{9, 8, 7}},
+		{[]int{9, 8, 7}, 3, 0, []int{9, 8, 7, 3}},
+		{[]int{9, 8, 7}, 8, 3, []int{9, 8, 7}},
+		// states written in the order of processing get sorted
+		{[]int{4, 9, 6}, 7, 0, []int{9, 7, 6, 4}},
+	}
+	for _, test := range tests {
+		var existing []ProcessedBuild
+		for _, number := range test.existing {
+			existing = append(existing, ProcessedBuild{BuildNumber: number})
+		}
+		got := buildNumbers(mergeProcessedBuild(existing, ProcessedBuild{BuildNumber: test.build}, test.limit))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("adding %d to %v with limit %d: got %v, want %v", test.build, test.existing, test.limit, got, test.want)
+		}
+	}
+}
+
+func TestPreviousBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bd := NewBuildkiteHandler("org", "pipe")
+	bd.SetStateFile(filepath.Join(dir, "state.json"))
+	bd.uncappedState = true
+	// processed in the order of a backfill
+	for _, number := range []int{20, 12, 15, 10} {
+		bd.SetBuildID(number)
+		bd.buildInfo = &BuildkiteBuildInfo{}
+		bd.results = []DownloadResult{{Filename: "app.apk"}}
+		if number == 15 {
+			// builds without downloads are no predecessors
+			bd.results = nil
+		}
+		if err := bd.recordProcessedBuild(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		build    int
+		previous int
+	}{
+		{21, 20},
+		{20, 12},
+		{16, 12},
+		{12, 10},
+		{10, 0},
+	}
+	for _, test := range tests {
+		bd.SetBuildID(test.build)
+		previous, err := bd.previousBuild()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := 0
+		if previous != nil {
+			got = previous.BuildNumber
+		}
+		if got != test.previous {
+			t.Errorf("previous build of %d is %d, want %d", test.build, got, test.previous)
+		}
+	}
+}
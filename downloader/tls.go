@@ -0,0 +1,48 @@
+package buildkiteArtifactDownloader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// tlsConfig returns the TLS config of the transport and creates it on first use
+func (bd *BuildkiteHandler) tlsConfig() *tls.Config {
+	if bd.transport.TLSClientConfig == nil {
+		bd.transport.TLSClientConfig = &tls.Config{}
+	}
+	return bd.transport.TLSClientConfig
+}
+
+// AddCABundle trusts the PEM encoded certificates of the given file in
+// addition to the system roots, e.g. the CA of a TLS-intercepting proxy
+func (bd *BuildkiteHandler) AddCABundle(path string) error {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Cannot read CA bundle (%v)", err)
+	}
+	config := bd.tlsConfig()
+	if config.RootCAs == nil {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		config.RootCAs = pool
+	}
+	if !config.RootCAs.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("CA bundle %s does not contain any PEM certificate", path)
+	}
+	return nil
+}
+
+// SetClientCertificate presents the given PEM encoded certificate and key to
+// servers which require TLS client authentication
+func (bd *BuildkiteHandler) SetClientCertificate(certFile string, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("Cannot load client certificate (%v)", err)
+	}
+	bd.tlsConfig().Certificates = []tls.Certificate{cert}
+	return nil
+}
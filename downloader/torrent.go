@@ -0,0 +1,178 @@
+package buildkiteArtifactDownloader
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// TorrentSuffix is appended to the destination of an artifact to get the
+	// path of its torrent file
+	TorrentSuffix = ".torrent"
+
+	minPieceLength = 256 * 1024
+	maxPieceLength = 16 * 1024 * 1024
+	// targetPieces is the number of pieces the piece length is chosen for
+	targetPieces = 1500
+)
+
+// torrentConfig describes the torrent files created for downloads
+type torrentConfig struct {
+	trackers []string
+	webSeeds []string
+	minSize  int64
+}
+
+// SetTorrent enables writing <destination>.torrent for every download of
+// at least minSize bytes. trackers are announced in the given order (the
+// torrent relies on DHT without trackers). webSeeds are HTTP URLs serving
+// the download; URLs ending with a slash get the file name appended by the
+// clients
+func (bd *BuildkiteHandler) SetTorrent(trackers []string, webSeeds []string, minSize int64) {
+	bd.torrent = &torrentConfig{
+		trackers: trackers,
+		webSeeds: webSeeds,
+		minSize:  minSize,
+	}
+}
+
+// bencode appends the bencoding of value to buf. Supported are strings,
+// integers, lists and dictionaries as used by torrent files
+func bencode(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		buf.WriteString(strconv.Itoa(len(v)) + ":" + v)
+	case []byte:
+		buf.WriteString(strconv.Itoa(len(v)) + ":")
+		buf.Write(v)
+	case int64:
+		buf.WriteString("i" + strconv.FormatInt(v, 10) + "e")
+	case []interface{}:
+		buf.WriteByte('l')
+		for _, item := range v {
+			bencode(buf, item)
+		}
+		buf.WriteByte('e')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		// dictionaries have to be sorted by their keys
+		sort.Strings(keys)
+		buf.WriteByte('d')
+		for _, key := range keys {
+			bencode(buf, key)
+			bencode(buf, v[key])
+		}
+		buf.WriteByte('e')
+	default:
+		panic(fmt.Sprintf("bencode: unsupported type %T", value))
+	}
+}
+
+// pieceLength returns a power of two which splits size into roughly
+// targetPieces pieces
+func pieceLength(size int64) int64 {
+	length := int64(minPieceLength)
+	for length < maxPieceLength && size/length > targetPieces {
+		length *= 2
+	}
+	return length
+}
+
+// torrentPieces returns the concatenated SHA1 hashes of the pieces of the
+// file at path
+func torrentPieces(path string, length int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var pieces []byte
+	piece := make([]byte, length)
+	for {
+		n, err := io.ReadFull(file, piece)
+		if n > 0 {
+			sum := sha1.Sum(piece[:n])
+			pieces = append(pieces, sum[:]...)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return pieces, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// writeTorrent writes the torrent file of the download and returns its
+// path
+func (bd *BuildkiteHandler) writeTorrent(result *DownloadResult) (string, error) {
+	length := pieceLength(result.Size)
+	pieces, err := torrentPieces(result.Destination, length)
+	if err != nil {
+		return "", fmt.Errorf("Cannot hash pieces of %s (%v)", result.Destination, err)
+	}
+	torrent := map[string]interface{}{
+		"created by":    "buildkite-artifact-downloader",
+		"creation date": time.Now().Unix(),
+		"info": map[string]interface{}{
+			"name":         filepath.Base(result.Destination),
+			"length":       result.Size,
+			"piece length": length,
+			"pieces":       pieces,
+		},
+	}
+	if len(bd.torrent.trackers) > 0 {
+		torrent["announce"] = bd.torrent.trackers[0]
+		var tiers []interface{}
+		for _, tracker := range bd.torrent.trackers {
+			tiers = append(tiers, []interface{}{tracker})
+		}
+		torrent["announce-list"] = tiers
+	}
+	if len(bd.torrent.webSeeds) > 0 {
+		var seeds []interface{}
+		for _, seed := range bd.torrent.webSeeds {
+			seeds = append(seeds, seed)
+		}
+		torrent["url-list"] = seeds
+	}
+	var buf bytes.Buffer
+	bencode(&buf, torrent)
+	torrentPath := result.Destination + TorrentSuffix
+	if err := writeFileAtomic(torrentPath, buf.Bytes(), bd.fileMode); err != nil {
+		return "", fmt.Errorf("Cannot write %s (%v)", torrentPath, err)
+	}
+	return torrentPath, bd.chown(torrentPath)
+}
+
+// createTorrent writes the torrent file for large enough downloads and
+// records its path. Failures are logged only
+func (bd *BuildkiteHandler) createTorrent(result *DownloadResult) {
+	if result.Size < bd.torrent.minSize {
+		return
+	}
+	torrentPath, err := bd.writeTorrent(result)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"destination": result.Destination,
+			"error":       err,
+		}).Warn("Cannot create torrent")
+		return
+	}
+	result.Torrent = torrentPath
+	log.WithFields(log.Fields{
+		"torrent": torrentPath,
+	}).Info("Torrent created")
+}
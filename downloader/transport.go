@@ -0,0 +1,142 @@
+package buildkiteArtifactDownloader
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxIdleConnsPerHost is sized so that all artifact downloads of a build
+	// (which all go to buildkite.com and the same S3 bucket) can reuse
+	// already established TLS connections
+	maxIdleConnsPerHost = 16
+	// DefaultReadTimeout aborts requests whose server did not send anything
+	// for this long. Downloads may take longer as long as data arrives
+	DefaultReadTimeout = 60 * time.Second
+)
+
+// newTransport builds the transport shared by all requests of a handler.
+// It keeps connections alive and prefers HTTP/2 so that downloading many
+// artifacts does not require a new TLS handshake for every file
+func newTransport(dial func(ctx context.Context, network, addr string) (net.Conn, error)) *http.Transport {
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dial,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: DefaultReadTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// SetReadTimeout aborts requests once the server did not send anything for
+// timeout (e.g. stalled downloads). There is no limit for the total duration
+// of a request so large artifacts can be downloaded over slow connections.
+// 0 disables the timeout
+func (bd *BuildkiteHandler) SetReadTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("Invalid read timeout %v", timeout)
+	}
+	bd.readTimeout = timeout
+	bd.transport.ResponseHeaderTimeout = timeout
+	return nil
+}
+
+// idleTimeoutConn fails reads which do not receive anything within timeout
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c idleTimeoutConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+// SetProxy routes all requests through the given proxy URL instead of the
+// proxy configured with HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+func (bd *BuildkiteHandler) SetProxy(proxy string) error {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return fmt.Errorf("Cannot parse proxy URL (%v)", err)
+	}
+	if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" {
+		return fmt.Errorf("Unsupported proxy scheme '%s' (use http or https)", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return fmt.Errorf("Proxy URL '%s' has no host", proxy)
+	}
+	bd.transport.Proxy = http.ProxyURL(proxyURL)
+	return nil
+}
+
+// SetSocks5Proxy tunnels all requests through the SOCKS5 proxy at
+// [user[:password]@]host:port (e.g. an SSH dynamic forward or Tor). Host
+// names are resolved by the proxy
+func (bd *BuildkiteHandler) SetSocks5Proxy(address string) error {
+	proxyURL, err := url.Parse("socks5://" + address)
+	if err != nil {
+		return fmt.Errorf("Cannot parse SOCKS5 proxy address (%v)", err)
+	}
+	if proxyURL.Hostname() == "" || proxyURL.Port() == "" || proxyURL.Path != "" || proxyURL.RawQuery != "" {
+		return fmt.Errorf("SOCKS5 proxy address '%s' has to be host:port", address)
+	}
+	bd.transport.Proxy = http.ProxyURL(proxyURL)
+	return nil
+}
+
+// dialContext connects with the configured address family and resolver
+func (bd *BuildkiteHandler) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if bd.ipVersion != "" && network == "tcp" {
+		network += bd.ipVersion
+	}
+	conn, err := bd.dialer.DialContext(ctx, network, addr)
+	if err != nil || bd.readTimeout <= 0 {
+		return conn, err
+	}
+	return idleTimeoutConn{Conn: conn, timeout: bd.readTimeout}, nil
+}
+
+// SetIPVersion restricts connections to IPv4 (4) or IPv6 (6). 0 uses both,
+// which may hang until the timeout on hosts with broken IPv6 routes
+func (bd *BuildkiteHandler) SetIPVersion(version int) error {
+	switch version {
+	case 0:
+		bd.ipVersion = ""
+	case 4, 6:
+		bd.ipVersion = strconv.Itoa(version)
+	default:
+		return fmt.Errorf("Unsupported IP version %d (use 4 or 6)", version)
+	}
+	return nil
+}
+
+// SetResolver resolves host names with the DNS server at host[:port]
+// instead of the resolver of the system
+func (bd *BuildkiteHandler) SetResolver(address string) error {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "53")
+	}
+	host, _, _ := net.SplitHostPort(address)
+	if net.ParseIP(host) == nil {
+		return fmt.Errorf("Resolver '%s' has to be an IP address", address)
+	}
+	server := &net.Dialer{Timeout: 5 * time.Second}
+	bd.dialer.Resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return server.DialContext(ctx, network, address)
+		},
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package buildkiteArtifactDownloader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadTimeout(t *testing.T) {
+	// the server sends a chunk of the body after every pause
+	tests := []struct {
+		pauses  []time.Duration
+		timeout time.Duration
+		fails   bool
+	}{
+		// slow downloads succeed as long as data arrives
+		{[]time.Duration{150, 150, 150, 150, 150}, 400, false},
+		{[]time.Duration{0, 600}, 300, true},
+		// stalled response headers
+		{[]time.Duration{600}, 300, true},
+		{[]time.Duration{0, 600}, 0, false},
+	}
+	for _, test := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for i, pause := range test.pauses {
+				time.Sleep(pause * time.Millisecond)
+				if i == 0 {
+					w.WriteHeader(http.StatusOK)
+				}
+				w.Write([]byte("chunk"))
+				w.(http.Flusher).Flush()
+			}
+		}))
+		bd := NewBuildkiteHandler("org", "pipe")
+		if err := bd.SetReadTimeout(test.timeout * time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		body, err := bd.getData(srv.URL)
+		srv.Close()
+		if test.fails && err == nil {
+			t.Errorf("pauses %v with timeout %v: got %q", test.pauses, test.timeout, body)
+		}
+		if !test.fails && (err != nil || len(body) != 5*len(test.pauses)) {
+			t.Errorf("pauses %v with timeout %v: got %q (%v)", test.pauses, test.timeout, body, err)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package buildkiteArtifactDownloader
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxUnpackedSize bounds the uncompressed size of an artifact archive if
+	// no smaller download budget applies
+	maxUnpackedSize = 16 << 30
+)
+
+// unpackedArtifacts holds the artifacts of providers which serve them as zip
+// archives. The archives are listed as single artifacts so the filters and
+// size limits apply before anything is downloaded. They get unpacked once
+// they are downloaded and the contained files are served via file:// URLs
+// to pass the regular download pipeline
+type unpackedArtifacts struct {
+	bd *BuildkiteHandler
+	// dir holds the unpacked artifacts of the current build
+	dir string
+}
+
+// archiveProvider is implemented by providers whose artifacts are zip
+// archives which have to be unpacked before their files are downloaded
+type archiveProvider interface {
+	unwrap(archive BuildkiteBuildArtifactInfo, limit int64) ([]BuildkiteBuildArtifactInfo, error)
+}
+
+// newUnpackedArtifacts registers the unpacked artifacts for file:// URLs
+func newUnpackedArtifacts(bd *BuildkiteHandler) *unpackedArtifacts {
+	u := &unpackedArtifacts{bd: bd}
+	bd.transport.RegisterProtocol(localFileScheme, u)
+	return u
+}
+
+// archiveArtifact describes the zip archive of the artifact name. key
+// identifies the archive within the build and size is the size reported by
+// the API (0 if unknown)
+func archiveArtifact(name string, key string, archiveURL string, size int64) BuildkiteBuildArtifactInfo {
+	return BuildkiteBuildArtifactInfo{
+		State:      "finished",
+		Filename:   name,
+		Path:       name,
+		URL:        archiveURL,
+		MimeType:   "application/zip",
+		FileSize:   size,
+		archiveKey: key,
+	}
+}
+
+// unwrap downloads the zip archive and unpacks it into the directory of its
+// key. It returns the contained files as artifacts. Archives whose content
+// exceeds limit bytes are refused
+func (u *unpackedArtifacts) unwrap(archive BuildkiteBuildArtifactInfo, limit int64) ([]BuildkiteBuildArtifactInfo, error) {
+	if u.dir == "" {
+		dir, err := ioutil.TempDir("", "unpacked-artifacts-")
+		if err != nil {
+			return nil, err
+		}
+		u.dir = dir
+	}
+	archivePath := filepath.Join(u.dir, archive.archiveKey+".zip")
+	if err := u.downloadArchive(archive, archivePath); err != nil {
+		return nil, err
+	}
+	defer os.Remove(archivePath)
+
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	artifactDir := filepath.Join(u.dir, archive.archiveKey)
+	var files []BuildkiteBuildArtifactInfo
+	var unpacked int64
+	for _, file := range reader.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		if file.UncompressedSize64 > uint64(limit-unpacked) {
+			return nil, fmt.Errorf("Content of %s exceeds %d bytes", archive.Filename, limit)
+		}
+		target, err := extractionTarget(artifactDir, file.Name)
+		if err != nil {
+			return nil, err
+		}
+		size, sha256sum, err := unpackFile(file, target, limit-unpacked)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot unpack %s from %s (%v)", file.Name, archive.Filename, err)
+		}
+		unpacked += size
+		fileName := path.Clean(file.Name)
+		files = append(files, BuildkiteBuildArtifactInfo{
+			State:     "finished",
+			Filename:  path.Base(fileName),
+			Path:      archive.Filename + "/" + fileName,
+			URL:       localFileScheme + ":///" + (&url.URL{Path: archive.archiveKey + "/" + fileName}).EscapedPath(),
+			MimeType:  mime.TypeByExtension(path.Ext(fileName)),
+			FileSize:  size,
+			SHA256sum: sha256sum,
+			job:       archive.job,
+		})
+	}
+	log.WithFields(log.Fields{
+		"buildID":  u.bd.buildID,
+		"artifact": archive.Filename,
+		"files":    len(files),
+		"bytes":    unpacked,
+	}).Info("Artifact archive unpacked")
+	return files, nil
+}
+
+// unwrapArchive unpacks the archive artifact and returns its files which
+// pass the mime type and size filters. The content of the archive may not
+// exceed the remaining download budget
+func (bd *BuildkiteHandler) unwrapArchive(archive BuildkiteBuildArtifactInfo, downloadedBytes int64) ([]BuildkiteBuildArtifactInfo, error) {
+	provider, ok := bd.provider.(archiveProvider)
+	if !ok {
+		return nil, fmt.Errorf("Cannot unpack artifact %s", archive.Filename)
+	}
+	limit := int64(maxUnpackedSize)
+	if bd.maxTotalSize > 0 && bd.maxTotalSize-downloadedBytes < limit {
+		limit = bd.maxTotalSize - downloadedBytes
+	}
+	files, err := provider.unwrap(archive, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unpack artifact %s (%v)", archive.Filename, err)
+	}
+
+	signatures := make(map[string]BuildkiteBuildArtifactInfo)
+	for _, file := range files {
+		if isSignatureFile(file.Filename) {
+			signatures[file.Filename] = file
+		}
+		if bd.isAttestation(file.Filename) {
+			bd.attestations = append(bd.attestations, file)
+			// download the attestations again including the new one
+			for _, path := range bd.attestationPaths {
+				os.Remove(path)
+			}
+			bd.attestationPaths = nil
+		}
+	}
+	var result []BuildkiteBuildArtifactInfo
+	for _, file := range files {
+		file.signature = findSignature(signatures, file.Filename)
+		if bd.mirror || bd.matchesContentFilters(file) {
+			result = append(result, file)
+		}
+	}
+	return result, nil
+}
+
+// downloadArchive stores the zip archive at target. Interrupted downloads
+// are retried like regular artifacts
+func (u *unpackedArtifacts) downloadArchive(archive BuildkiteBuildArtifactInfo, target string) error {
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	// the reported size is not always the one of the archive, so only the
+	// Content-Length is checked
+	archive.FileSize = 0
+	for attempt := 1; ; attempt++ {
+		_, err = u.bd.fetchArtifact(archive, out, newArtifactDigests(), nil)
+		if err == nil {
+			return out.Close()
+		}
+		if _, retryable := err.(retryableError); !retryable || attempt >= u.bd.downloadAttempts {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"buildID":  u.bd.buildID,
+			"artifact": archive.Filename,
+			"attempt":  attempt,
+			"error":    err,
+		}).Warn("Download of artifact archive incomplete. Retry")
+		if _, err := out.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := out.Truncate(0); err != nil {
+			return err
+		}
+	}
+}
+
+// unpackFile writes the zip entry to target and returns its size and
+// SHA-256 checksum. Entries larger than limit are refused as the size in
+// the zip header cannot be trusted
+func unpackFile(file *zip.File, target string, limit int64) (int64, string, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return 0, "", err
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return 0, "", err
+	}
+	defer reader.Close()
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, "", err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), io.LimitReader(reader, limit+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size > limit {
+		err = fmt.Errorf("Content exceeds %d bytes", limit)
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), err
+}
+
+// RoundTrip serves the unpacked artifacts of the build for file:// URLs
+func (u *unpackedArtifacts) RoundTrip(req *http.Request) (*http.Response, error) {
+	if u.dir == "" {
+		return nil, fmt.Errorf("No unpacked artifacts")
+	}
+	return http.NewFileTransport(http.Dir(u.dir)).RoundTrip(req)
+}
+
+func (u *unpackedArtifacts) cleanup() {
+	if u.dir != "" {
+		os.RemoveAll(u.dir)
+		u.dir = ""
+	}
+}
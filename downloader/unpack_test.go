@@ -0,0 +1,163 @@
+package buildkiteArtifactDownloader
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// zipArchive returns a zip archive with the given files
+func zipArchive(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUnwrapLimit(t *testing.T) {
+	archive := zipArchive(t, map[string][]byte{
+		"app.apk":      bytes.Repeat([]byte{'a'}, 600),
+		"dir/notes.md": bytes.Repeat([]byte{'b'}, 400),
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		limit int64
+		files []string
+	}{
+		{1000, []string{"apks/app.apk", "apks/dir/notes.md"}},
+		{maxUnpackedSize, []string{"apks/app.apk", "apks/dir/notes.md"}},
+		{999, nil},
+		{100, nil},
+	}
+	for i, test := range tests {
+		bd := NewBuildkiteHandler("org", "pipe")
+		u := newUnpackedArtifacts(bd)
+		files, err := u.unwrap(archiveArtifact("apks", strconv.Itoa(i), srv.URL, 0), test.limit)
+		u.cleanup()
+		if test.files == nil {
+			if err == nil {
+				t.Errorf("limit %d: content of 1000 bytes got unpacked", test.limit)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("limit %d: %v", test.limit, err)
+		}
+		var paths []string
+		for _, file := range files {
+			paths = append(paths, file.Path)
+		}
+		sort.Strings(paths)
+		if strings.Join(paths, ",") != strings.Join(test.files, ",") {
+			t.Errorf("limit %d: got %v, want %v", test.limit, paths, test.files)
+		}
+	}
+}
+
+func TestUnwrapRefusesTraversal(t *testing.T) {
+	archive := zipArchive(t, map[string][]byte{"../escape.txt": []byte("x")})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer srv.Close()
+
+	bd := NewBuildkiteHandler("org", "pipe")
+	u := newUnpackedArtifacts(bd)
+	defer u.cleanup()
+	if _, err := u.unwrap(archiveArtifact("evil", "1", srv.URL, 0), maxUnpackedSize); err == nil {
+		t.Error("entry escaping the archive directory got unpacked")
+	}
+}
+
+// TestGitHubArchivesAreDownloadedLazily checks that archives are filtered by
+// their name before they are downloaded and that truncated archives are
+// downloaded again
+func TestGitHubArchivesAreDownloadedLazily(t *testing.T) {
+	apks := zipArchive(t, map[string][]byte{"app.txt": []byte("artifact content")})
+	var lock sync.Mutex
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests[r.URL.Path]++
+		count := requests[r.URL.Path]
+		lock.Unlock()
+		switch r.URL.Path {
+		case "/repos/org/repo/actions/runs/7":
+			fmt.Fprint(w, `{"id": 7, "run_number": 7, "name": "CI", "status": "completed", "conclusion": "success", "head_sha": "abcdef1234567890", "head_branch": "main"}`)
+		case "/repos/org/repo/actions/runs/7/artifacts":
+			fmt.Fprintf(w, `{"artifacts": [
+				{"id": 1, "name": "apks", "size_in_bytes": %d, "archive_download_url": "%s/zip/1"},
+				{"id": 2, "name": "logs", "size_in_bytes": 5368709120, "archive_download_url": "%s/zip/2"}
+			]}`, len(apks), "http://"+r.Host, "http://"+r.Host)
+		case "/zip/1":
+			w.Header().Set("Content-Length", strconv.Itoa(len(apks)))
+			if count == 1 {
+				// the connection breaks after half of the archive
+				w.Write(apks[:len(apks)/2])
+				return
+			}
+			w.Write(apks)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "unpack-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bd := NewBuildkiteHandler("org", "repo")
+	if err := bd.SetGitHubActions(srv.URL, "", "token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bd.SetDestinationPattern(dir + "/<artifactFilename>"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bd.AddArtifactFilter("^apks$"); err != nil {
+		t.Fatal(err)
+	}
+	bd.SetBuildID(7)
+	downloads, err := bd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if downloads != 1 {
+		t.Fatalf("got %d downloads, want 1", downloads)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, "app.txt"))
+	if err != nil || string(content) != "artifact content" {
+		t.Errorf("got %q (%v), want the file of the archive", content, err)
+	}
+	if requests["/zip/2"] != 0 {
+		t.Error("filtered archive got downloaded")
+	}
+	if requests["/zip/1"] != 2 {
+		t.Errorf("archive got requested %d times, want 2", requests["/zip/1"])
+	}
+}
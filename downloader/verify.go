@@ -0,0 +1,233 @@
+package buildkiteArtifactDownloader
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/avast/apkverifier"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// VerifierAPK validates the APK signature and the configured APK policies
+	VerifierAPK = "apk"
+	// VerifierZip checks the integrity of all entries of a zip archive
+	VerifierZip = "zip"
+	// VerifierNone skips the content verification
+	VerifierNone = "none"
+)
+
+// verificationRule selects the verifier for artifacts whose filename
+// matches pattern
+type verificationRule struct {
+	pattern  *regexp.Regexp
+	verifier string
+}
+
+// defaultVerificationRules apply after the configured rules
+var defaultVerificationRules = []verificationRule{
+	{pattern: regexp.MustCompile(`\.apk$`), verifier: VerifierAPK},
+}
+
+// AddVerificationRule verifies artifacts whose filename matches pattern with
+// the given verifier (apk, zip or none). Rules are evaluated in the order
+// they were added and the first match wins. APKs are verified by default
+func (bd *BuildkiteHandler) AddVerificationRule(pattern string, verifier string) error {
+	switch verifier {
+	case VerifierAPK, VerifierZip, VerifierNone:
+	default:
+		return fmt.Errorf("Unknown verifier %s", verifier)
+	}
+	rePattern, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	bd.verificationRules = append(bd.verificationRules, verificationRule{
+		pattern:  rePattern,
+		verifier: verifier,
+	})
+	return nil
+}
+
+// verifierFor returns the verifier of the first rule matching filename
+func (bd *BuildkiteHandler) verifierFor(filename string) string {
+	for _, rules := range [][]verificationRule{bd.verificationRules, defaultVerificationRules} {
+		for _, rule := range rules {
+			if rule.pattern.MatchString(filename) {
+				return rule.verifier
+			}
+		}
+	}
+	return VerifierNone
+}
+
+// verifyContent runs the verifier selected for the artifact on the file at
+// path
+func (bd *BuildkiteHandler) verifyContent(artifact BuildkiteBuildArtifactInfo, path string) error {
+	switch bd.verifierFor(artifact.Filename) {
+	case VerifierAPK:
+		return bd.verifyAPK(artifact, path)
+	case VerifierZip:
+		return verifyZip(artifact, path)
+	}
+	return nil
+}
+
+// verifyZip reads all entries of the archive which makes archive/zip
+// validate their CRC-32 checksums
+func verifyZip(artifact BuildkiteBuildArtifactInfo, path string) error {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("%s is no valid zip archive (%v)", artifact.Filename, err)
+	}
+	defer archive.Close()
+
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("Cannot open %s in %s (%v)", file.Name, artifact.Filename, err)
+		}
+		_, err = io.Copy(ioutil.Discard, reader)
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("Entry %s of %s is corrupt (%v)", file.Name, artifact.Filename, err)
+		}
+	}
+	return nil
+}
+
+// apkSignerPin restricts the accepted signing certificates of APKs whose
+// filename matches pattern
+type apkSignerPin struct {
+	pattern      *regexp.Regexp
+	fingerprints []string
+}
+
+// AddAPKSignerPin only accepts APKs matching pattern if all of their signers
+// use a certificate with one of the given SHA-256 fingerprints. Fingerprints may be
+// written with or without colons. Multiple pins matching the same APK are
+// combined
+func (bd *BuildkiteHandler) AddAPKSignerPin(pattern string, fingerprints []string) error {
+	rePattern, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	pin := apkSignerPin{pattern: rePattern}
+	for _, fingerprint := range fingerprints {
+		fingerprint = normalizeFingerprint(fingerprint)
+		if len(fingerprint) != sha256.Size*2 {
+			return fmt.Errorf("%s is no SHA-256 fingerprint", fingerprint)
+		}
+		if _, err := hex.DecodeString(fingerprint); err != nil {
+			return fmt.Errorf("%s is no SHA-256 fingerprint (%v)", fingerprint, err)
+		}
+		pin.fingerprints = append(pin.fingerprints, fingerprint)
+	}
+	bd.apkSignerPins = append(bd.apkSignerPins, pin)
+	return nil
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(fingerprint), ":", "", -1))
+}
+
+// SetRejectDebugAPKs refuses APKs which are unsigned or signed with the
+// Android debug keystore
+func (bd *BuildkiteHandler) SetRejectDebugAPKs(reject bool) {
+	bd.rejectDebugAPKs = reject
+}
+
+// isDebugCertificate reports if cert got generated by the Android SDK for
+// its debug keystore
+func isDebugCertificate(cert *x509.Certificate) bool {
+	return cert.Subject.CommonName == "Android Debug"
+}
+
+// expectedAPKSigners returns the pinned fingerprints for filename
+func (bd *BuildkiteHandler) expectedAPKSigners(filename string) []string {
+	var fingerprints []string
+	for _, pin := range bd.apkSignerPins {
+		if pin.pattern.MatchString(filename) {
+			fingerprints = append(fingerprints, pin.fingerprints...)
+		}
+	}
+	return fingerprints
+}
+
+// verifyAPK validates the signature of the APK at path and checks the
+// signing certificate against the configured pins
+func (bd *BuildkiteHandler) verifyAPK(artifact BuildkiteBuildArtifactInfo, path string) error {
+	log.WithFields(log.Fields{
+		"buildID":          bd.buildID,
+		"artifactFilename": artifact.Filename,
+		"tmpFile":          path,
+	}).Info("Validate APK")
+	res, err := apkverifier.Verify(path, nil)
+	if err != nil {
+		return fmt.Errorf("Verification of APK failed: %s", err.Error())
+	}
+
+	if bd.rejectDebugAPKs {
+		if len(res.SignerCerts) == 0 {
+			return fmt.Errorf("APK %s is not signed", artifact.Filename)
+		}
+		for _, chain := range res.SignerCerts {
+			if len(chain) > 0 && isDebugCertificate(chain[0]) {
+				return fmt.Errorf("APK %s is signed with a debug key (%s)", artifact.Filename, chain[0].Subject.String())
+			}
+		}
+	}
+
+	expected := bd.expectedAPKSigners(artifact.Filename)
+	if len(expected) == 0 {
+		return nil
+	}
+	if err := checkPinnedSigners(artifact.Filename, res.SignerCerts, expected); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"buildID":          bd.buildID,
+		"artifactFilename": artifact.Filename,
+		"signers":          len(res.SignerCerts),
+	}).Info("APK signers match pinned certificates")
+	return nil
+}
+
+// checkPinnedSigners requires the signing certificate of every signer of the
+// APK to be one of the expected fingerprints. A single unpinned signer (e.g.
+// a co-signer of a v2/v3 signature) rejects the APK
+func checkPinnedSigners(filename string, signerCerts [][]*x509.Certificate, expected []string) error {
+	if len(signerCerts) == 0 {
+		return fmt.Errorf("APK %s is not signed by a pinned certificate (unsigned)", filename)
+	}
+	var unpinned []string
+	for _, chain := range signerCerts {
+		if len(chain) == 0 {
+			unpinned = append(unpinned, "signer without certificate")
+			continue
+		}
+		sum := sha256.Sum256(chain[0].Raw)
+		fingerprint := hex.EncodeToString(sum[:])
+		pinned := false
+		for _, pin := range expected {
+			if fingerprint == pin {
+				pinned = true
+				break
+			}
+		}
+		if !pinned {
+			unpinned = append(unpinned, fingerprint)
+		}
+	}
+	if len(unpinned) > 0 {
+		return fmt.Errorf("APK %s is signed by a certificate which is not pinned (%s)", filename, strings.Join(unpinned, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package buildkiteArtifactDownloader
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// signingCertificate returns a self-signed certificate for name
+func signingCertificate(t *testing.T, name string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func fingerprintOf(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestCheckPinnedSigners(t *testing.T) {
+	release := signingCertificate(t, "Release")
+	rotated := signingCertificate(t, "Rotated")
+	other := signingCertificate(t, "Other")
+	pins := []string{fingerprintOf(release), fingerprintOf(rotated)}
+
+	tests := []struct {
+		name    string
+		signers [][]*x509.Certificate
+		valid   bool
+	}{
+		{"pinned signer", [][]*x509.Certificate{{release}}, true},
+		{"two pinned signers", [][]*x509.Certificate{{release}, {rotated}}, true},
+		{"unpinned signer", [][]*x509.Certificate{{other}}, false},
+		{"co-signed by an unpinned key", [][]*x509.Certificate{{release}, {other}}, false},
+		{"unpinned key signs first", [][]*x509.Certificate{{other}, {release}}, false},
+		{"signer without certificate", [][]*x509.Certificate{{release}, {}}, false},
+		{"unsigned", nil, false},
+	}
+	for _, test := range tests {
+		err := checkPinnedSigners("app.apk", test.signers, pins)
+		if test.valid && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: APK got accepted", test.name)
+		}
+	}
+}
+
+func TestAddAPKSignerPinNormalizesFingerprints(t *testing.T) {
+	cert := signingCertificate(t, "Release")
+	fingerprint := fingerprintOf(cert)
+	var colons string
+	for i := 0; i < len(fingerprint); i += 2 {
+		if i > 0 {
+			colons += ":"
+		}
+		colons += fingerprint[i : i+2]
+	}
+
+	bd := NewBuildkiteHandler("org", "pipe")
+	if err := bd.AddAPKSignerPin(`\.apk$`, []string{" " + colons + " "}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bd.AddAPKSignerPin(`^app`, []string{"not-a-fingerprint"}); err == nil {
+		t.Error("invalid fingerprint got accepted")
+	}
+	expected := bd.expectedAPKSigners("app.apk")
+	if err := checkPinnedSigners("app.apk", [][]*x509.Certificate{{cert}}, expected); err != nil {
+		t.Error(err)
+	}
+	if expected := bd.expectedAPKSigners("app.aab"); len(expected) != 0 {
+		t.Errorf("pins of APKs apply to app.aab: %v", expected)
+	}
+}
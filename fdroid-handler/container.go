@@ -0,0 +1,89 @@
+package fdroidHandler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultContainerImage ships fdroidserver with fdroid as entrypoint
+	DefaultContainerImage = "registry.gitlab.com/fdroid/docker-executable-fdroidserver:master"
+	// containerRepoDir is where the repo gets mounted in the container
+	containerRepoDir = "/repo"
+	// containerKillTimeout limits how long "<runtime> kill" may take
+	containerKillTimeout = 30 * time.Second
+)
+
+// containerCount numbers the containers started by this process
+var containerCount uint64
+
+// containerName returns a unique name for the next fdroid container
+func containerName() string {
+	return "fdroid-" + strconv.Itoa(os.Getpid()) + "-" + strconv.FormatUint(atomic.AddUint64(&containerCount, 1), 10)
+}
+
+// SetContainer runs fdroid via "<runtime> run" (docker or podman) in the
+// given image with the repo directory bind-mounted instead of using a local
+// fdroidserver installation. The venv is not used then
+func (fh *FdroidHandler) SetContainer(runtime string, image string) error {
+	if base := filepath.Base(runtime); base != "docker" && base != "podman" {
+		return fmt.Errorf("Unsupported container runtime '%s' (use docker or podman)", runtime)
+	}
+	if image == "" {
+		image = DefaultContainerImage
+	}
+	fh.containerRuntime = runtime
+	fh.containerImage = image
+	return nil
+}
+
+// containerArgs returns the arguments of "<runtime> run" which runs fdroid
+// with args in the container name. Variables of env are passed by name so
+// that their values do not show up in the process list
+func (fh *FdroidHandler) containerArgs(name string, env []string, args []string) ([]string, error) {
+	repoDir := fh.repoDir
+	if repoDir == "" {
+		repoDir = "."
+	}
+	repoDir, err := filepath.Abs(repoDir)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot resolve repo dir (%v)", err)
+	}
+	runArgs := []string{"run", "--rm", "--name", name,
+		"-v", repoDir + ":" + containerRepoDir,
+		"-w", containerRepoDir,
+	}
+	if uid, gid := os.Getuid(), os.Getgid(); uid >= 0 && gid >= 0 {
+		// keep the files written in the repo owned by the caller
+		runArgs = append(runArgs, "-u", strconv.Itoa(uid)+":"+strconv.Itoa(gid))
+	}
+	for _, variable := range env {
+		runArgs = append(runArgs, "-e", variable[:strings.Index(variable, "=")])
+	}
+	runArgs = append(runArgs, fh.containerImage)
+	return append(runArgs, args...), nil
+}
+
+// killContainer stops the container name. Killing "<runtime> run" only
+// stops the client while the container keeps running
+func (fh *FdroidHandler) killContainer(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), containerKillTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, fh.containerRuntime, "kill", name).CombinedOutput()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"container": name,
+			"output":    strings.TrimSpace(string(output)),
+			"error":     err,
+		}).Warn("Cannot kill fdroid container")
+	}
+}
@@ -0,0 +1,74 @@
+package fdroidHandler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContainerGetsKilledOnTimeout(t *testing.T) {
+	defer func(delay time.Duration) { commandWaitDelay = delay }(commandWaitDelay)
+	commandWaitDelay = 500 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "container-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	calls := filepath.Join(dir, "calls")
+	runtime := filepath.Join(dir, "docker")
+	// "run" behaves like the client of a container which never finishes
+	script := "#!/bin/sh\necho \"$@\" >> " + calls + "\nif [ \"$1\" = run ]; then sleep 30; fi\n"
+	if err := ioutil.WriteFile(runtime, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fh := NewFdroidHandler()
+	if err := fh.SetContainer(runtime, "fdroidserver"); err != nil {
+		t.Fatal(err)
+	}
+	fh.SetTimeout(200 * time.Millisecond)
+	start := time.Now()
+	if err := fh.RunFdroidCommand("update"); err == nil {
+		t.Fatal("fdroid did not time out")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("returned after %v", elapsed)
+	}
+
+	content, err := ioutil.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got calls %q, want run and kill", lines)
+	}
+	run := strings.Fields(lines[0])
+	var name string
+	for i, arg := range run {
+		if arg == "--name" && i+1 < len(run) {
+			name = run[i+1]
+		}
+	}
+	if name == "" || run[0] != "run" {
+		t.Fatalf("container got started without a name: %q", lines[0])
+	}
+	if lines[1] != "kill "+name {
+		t.Errorf("got %q, want %q", lines[1], "kill "+name)
+	}
+}
+
+func TestContainerNamesAreUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		name := containerName()
+		if seen[name] {
+			t.Fatalf("%s got used twice", name)
+		}
+		seen[name] = true
+	}
+}
@@ -1,21 +1,73 @@
 package fdroidHandler
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
 
 	common "github.com/krombel/buildkite-artifact-downloader/common"
 	log "github.com/sirupsen/logrus"
 )
 
+// CommandError describes a fdroid command which failed
+type CommandError struct {
+	Command string
+	Args    []string
+	// ExitCode is the exit status of fdroid or -1 if it could not be started
+	ExitCode int
+	Err      error
+	// Failure is one of the Failure constants if the output of fdroid
+	// contained a known failure. Message is the line reporting it
+	Failure string
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	if e.Failure != "" {
+		return fmt.Sprintf("Command fdroid %s failed with exit code %d (%s: %s)", e.Command, e.ExitCode, e.Failure, e.Message)
+	}
+	return fmt.Sprintf("Command fdroid %s failed with exit code %d (%v)", e.Command, e.ExitCode, e.Err)
+}
+
+const (
+	// DefaultTimeout limits how long a single fdroid command may run
+	DefaultTimeout = time.Hour
+	// KeystorePassEnv and KeyPassEnv hold the passwords of the repo keystore.
+	// Reference them in config.yml with "keystorepass: {env: FDROID_KEY_STORE_PASS}"
+	// and "keypass: {env: FDROID_KEY_PASS}"
+	KeystorePassEnv = "FDROID_KEY_STORE_PASS"
+	KeyPassEnv      = "FDROID_KEY_PASS"
+)
+
 type FdroidHandler struct {
 	virtualEnv string
+	repoDir    string
+	timeout    time.Duration
+	dryRun     bool
+	// keystorePass and keyPass are passed to fdroid via KeystorePassEnv
+	// and KeyPassEnv
+	keystorePass string
+	keyPass      string
+	// env holds additional variables per command ("" applies to all)
+	env              map[string][]string
+	metadataTemplate *template.Template
+	// containerRuntime and containerImage are set to run fdroid in a container
+	containerRuntime string
+	containerImage   string
 }
 
 func NewFdroidHandler() *FdroidHandler {
 	return &FdroidHandler{
 		virtualEnv: "",
+		timeout:    DefaultTimeout,
+		env:        make(map[string][]string),
 	}
 }
 
@@ -40,41 +92,288 @@ func (fh *FdroidHandler) SetFdroidVENV(venv string) error {
 	return nil
 }
 
-// RunFdroidCommand executes "fdroid <command>" while setting venv if setup
-func (fh *FdroidHandler) RunFdroidCommand(fdroidCommand string) {
-	//cmd := exec.Command("fdroid", fdroidCommand)
-	var backupPath string
-	if fh.virtualEnv != "" {
-		backupPath := os.Getenv("PATH")
+// SetRepoDir sets the directory of the fdroid repository the commands run
+// in. It has to contain the config.yml of the repository
+func (fh *FdroidHandler) SetRepoDir(repoDir string) error {
+	if ret, err := common.StringIsDirectory(repoDir); !ret {
+		return fmt.Errorf("Repo dir is no directory (%v)", err)
+	}
+	if _, err := os.Stat(filepath.Join(repoDir, "config.yml")); err != nil {
+		return fmt.Errorf("Repo dir does not contain config.yml (%v)", err)
+	}
+	fh.repoDir = repoDir
+	return nil
+}
+
+// SetTimeout sets how long a single fdroid command may run before it gets
+// killed (e.g. when it waits for a keystore password). 0 disables the limit
+func (fh *FdroidHandler) SetTimeout(timeout time.Duration) {
+	fh.timeout = timeout
+}
+
+// SetKeystorePasswords sets the passwords of the repo keystore which are
+// needed to sign with "fdroid publish" and "fdroid update". Passwords are
+// given as "pass:<password>", "file:<path>" or "env:<variable>"
+func (fh *FdroidHandler) SetKeystorePasswords(keystorePass string, keyPass string) error {
+	var err error
+	if fh.keystorePass, err = resolvePassword(keystorePass); err != nil {
+		return fmt.Errorf("Cannot read keystore password (%v)", err)
+	}
+	if fh.keyPass, err = resolvePassword(keyPass); err != nil {
+		return fmt.Errorf("Cannot read key password (%v)", err)
+	}
+	return nil
+}
+
+// resolvePassword reads a password given as "pass:<password>",
+// "file:<path>" or "env:<variable>". Empty strings stay empty
+func resolvePassword(password string) (string, error) {
+	switch {
+	case password == "":
+		return "", nil
+	case strings.HasPrefix(password, "pass:"):
+		return strings.TrimPrefix(password, "pass:"), nil
+	case strings.HasPrefix(password, "file:"):
+		content, err := ioutil.ReadFile(strings.TrimPrefix(password, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	case strings.HasPrefix(password, "env:"):
+		value, ok := os.LookupEnv(strings.TrimPrefix(password, "env:"))
+		if !ok {
+			return "", fmt.Errorf("Variable %s is not set", strings.TrimPrefix(password, "env:"))
+		}
+		return value, nil
+	}
+	return "", fmt.Errorf("Password has to start with pass:, file: or env:")
+}
+
+// AddEnv passes the variable NAME=value to the fdroid command (e.g. "update")
+// or to all commands if command is empty. The environment of the downloader
+// itself is not changed
+func (fh *FdroidHandler) AddEnv(command string, variable string) error {
+	if strings.Index(variable, "=") < 1 {
+		return fmt.Errorf("Variable '%s' has to be NAME=value", variable)
+	}
+	fh.env[command] = append(fh.env[command], variable)
+	return nil
+}
+
+// commandEnv returns the variables which are added to the environment of
+// the given command. Later entries take precedence
+func (fh *FdroidHandler) commandEnv(fdroidCommand string) []string {
+	var env []string
+	if fh.virtualEnv != "" && fh.containerRuntime == "" {
+		env = append(env,
+			`VIRTUAL_ENV=`+fh.virtualEnv,
+			`PATH=`+fh.virtualEnv+`/bin:`+os.Getenv("PATH"),
+		)
+	}
+	if fh.keystorePass != "" {
+		env = append(env, KeystorePassEnv+"="+fh.keystorePass)
+	}
+	if fh.keyPass != "" {
+		env = append(env, KeyPassEnv+"="+fh.keyPass)
+	}
+	env = append(env, fh.env[""]...)
+	if fdroidCommand != "" {
+		env = append(env, fh.env[fdroidCommand]...)
+	}
+	return env
+}
+
+// reSecretEnv matches names of variables whose values are not logged
+var reSecretEnv = regexp.MustCompile(`(?i)pass|secret|token`)
+
+// redactEnv hides the secrets of env for logging
+func redactEnv(env []string) []string {
+	redacted := make([]string, len(env))
+	for i, entry := range env {
+		if reSecretEnv.MatchString(entry[:strings.Index(entry, "=")]) {
+			entry = entry[:strings.Index(entry, "=")+1] + "***"
+		}
+		redacted[i] = entry
+	}
+	return redacted
+}
+
+// SetDryRun only logs the commands, their environment and working directory
+// instead of running them
+func (fh *FdroidHandler) SetDryRun(dryRun bool) {
+	fh.dryRun = dryRun
+}
+
+// RunFdroidCommand executes "fdroid <command> [args...]" while setting venv
+// if setup
+func (fh *FdroidHandler) RunFdroidCommand(fdroidCommand string, args ...string) error {
+	return fh.RunFdroidCommandContext(context.Background(), fdroidCommand, args...)
+}
+
+// RunFdroidCommandContext is RunFdroidCommand which kills fdroid when the
+// context is done or the timeout is exceeded
+func (fh *FdroidHandler) RunFdroidCommandContext(ctx context.Context, fdroidCommand string, args ...string) error {
+	if fh.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, fh.timeout)
+		defer cancel()
+	}
+	cmd, env, err := fh.command(ctx, append([]string{fdroidCommand}, args...)...)
+	if err != nil {
+		return &CommandError{Command: fdroidCommand, Args: args, ExitCode: -1, Err: err}
+	}
+
+	stdout := &outputScanner{level: log.InfoLevel}
+	stderr := &outputScanner{level: log.WarnLevel}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if fh.dryRun {
+		dir := cmd.Dir
+		if dir == "" {
+			dir, _ = os.Getwd()
+		}
+		log.WithFields(log.Fields{
+			"command":    strings.Join(cmd.Args, " "),
+			"executable": cmd.Path,
+			"dir":        dir,
+			"env":        redactEnv(env),
+		}).Info("Dry run. Skip fdroid " + fdroidCommand)
+	} else {
 		log.WithFields(log.Fields{
-			"path":       backupPath,
 			"virtualenv": fh.virtualEnv,
-		}).Info("Set virtualenv for execution")
-		os.Setenv("PATH", fh.virtualEnv+`/bin:`+backupPath)
+			"container":  fh.containerImage,
+			"repoDir":    fh.repoDir,
+			"args":       args,
+		}).Info("Runs fdroid " + fdroidCommand)
+		err = cmd.Run()
+		stdout.Close()
+		stderr.Close()
+	}
+
+	if err != nil {
+		cmdErr := &CommandError{
+			Command:  fdroidCommand,
+			Args:     args,
+			ExitCode: -1,
+			Err:      err,
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			cmdErr.ExitCode = exitErr.ExitCode()
+		}
+		// errors are usually reported on stderr
+		for _, output := range []*outputScanner{stderr, stdout} {
+			if output.failure != "" {
+				cmdErr.Failure = output.failure
+				cmdErr.Message = output.message
+				break
+			}
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			cmdErr.Err = fmt.Errorf("Timed out after %v", fh.timeout)
+		} else if ctx.Err() != nil {
+			cmdErr.Err = ctx.Err()
+		}
+		return cmdErr
 	}
+	return nil
+}
+
+// versionTimeout limits how long "fdroid --version" may take
+const versionTimeout = 30 * time.Second
+
+// commandWaitDelay is how long Wait waits for the output of a killed
+// command. Children of fdroid (e.g. a keystore helper) inherit its output
+// pipes and would block Wait forever otherwise
+var commandWaitDelay = 10 * time.Second
 
-	cmd := exec.Command("fdroid", fdroidCommand)
+// executable resolves the fdroid binary the commands would run. The venv is
+// preferred if setup
+func (fh *FdroidHandler) executable() (string, error) {
 	if fh.virtualEnv != "" {
-		cmd.Env = append(os.Environ(),
-			`VIRTUAL_ENV=`+fh.virtualEnv,
-		)
+		venvFdroid := filepath.Join(fh.virtualEnv, "bin", "fdroid")
+		if fi, err := os.Stat(venvFdroid); err == nil && !fi.IsDir() && fi.Mode()&0111 != 0 {
+			return venvFdroid, nil
+		}
 	}
+	return exec.LookPath("fdroid")
+}
 
-	cmd.Stdout = log.WithFields(log.Fields{
-		"cmd": "fdroid",
-	}).Writer()
-	cmd.Stderr = log.WithFields(log.Fields{
-		"cmd": "fdroid",
-	}).WriterLevel(log.WarnLevel)
+// command builds the command which runs fdroid with the given arguments,
+// either directly or in a container. It returns the variables added to the
+// environment as well
+func (fh *FdroidHandler) command(ctx context.Context, args ...string) (*exec.Cmd, []string, error) {
+	env := fh.commandEnv(args[0])
+	if fh.containerRuntime != "" {
+		name := containerName()
+		runArgs, err := fh.containerArgs(name, env, args)
+		if err != nil {
+			return nil, nil, err
+		}
+		cmd := exec.CommandContext(ctx, fh.containerRuntime, runArgs...)
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Cancel = func() error {
+			fh.killContainer(name)
+			return cmd.Process.Kill()
+		}
+		cmd.WaitDelay = commandWaitDelay
+		return cmd, env, nil
+	}
 
-	log.WithFields(log.Fields{
-		"virtualenv": fh.virtualEnv,
-	}).Info("Runs fdroid " + fdroidCommand)
-	if err := cmd.Run(); err != nil {
-		log.Fatal(err)
+	executable, err := fh.executable()
+	if err != nil {
+		// let exec report the missing binary
+		executable = "fdroid"
+	}
+	cmd := exec.CommandContext(ctx, executable, args...)
+	cmd.Dir = fh.repoDir
+	cmd.WaitDelay = commandWaitDelay
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
 	}
+	return cmd, env, nil
+}
+
+// CheckFdroid verifies that fdroid can be run and returns its version. It is
+// meant to be called before downloading so that a missing fdroidserver does
+// not get noticed only after all downloads
+func (fh *FdroidHandler) CheckFdroid() (string, error) {
+	if fh.containerRuntime != "" {
+		if _, err := exec.LookPath(fh.containerRuntime); err != nil {
+			return "", fmt.Errorf("Cannot find container runtime (%v)", err)
+		}
+	} else if _, err := fh.executable(); err != nil {
+		return "", fmt.Errorf("Cannot find fdroid (%v)", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), versionTimeout)
+	defer cancel()
+	cmd, _, err := fh.command(ctx, "--version")
+	if err != nil {
+		return "", err
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Cannot run %s (%v)", strings.Join(cmd.Args, " "), err)
+	}
+	version := strings.TrimSpace(string(output))
+	log.WithFields(log.Fields{
+		"executable": cmd.Path,
+		"container":  fh.containerImage,
+		"version":    version,
+	}).Info("Found fdroid")
+	return version, nil
+}
+
+// reDeployKey matches the settings of config.yml which configure a target of
+// "fdroid deploy"
+var reDeployKey = regexp.MustCompile(`(?m)^(serverwebroot|servergitmirrors|awsbucket|local_copy_dir|androidobservatory|binary_transparency_remote|virustotal_apikey)\s*:`)
 
-	if backupPath != "" {
-		os.Setenv("PATH", backupPath)
+// HasDeployTargets checks whether config.yml of the repo configures any
+// target "fdroid deploy" could publish to
+func (fh *FdroidHandler) HasDeployTargets() (bool, error) {
+	config, err := ioutil.ReadFile(filepath.Join(fh.repoDir, "config.yml"))
+	if err != nil {
+		return false, fmt.Errorf("Cannot read fdroid config (%v)", err)
 	}
+	return reDeployKey.Match(config), nil
 }
@@ -0,0 +1,73 @@
+package fdroidHandler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeFdroid installs script as bin/fdroid of a venv and returns a handler
+// using it
+func fakeFdroid(t *testing.T, script string) (*FdroidHandler, string) {
+	dir, err := ioutil.TempDir("", "fdroid-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "bin", "fdroid"), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	fh := NewFdroidHandler()
+	if err := fh.SetFdroidVENV(dir); err != nil {
+		t.Fatal(err)
+	}
+	return fh, dir
+}
+
+func TestRunFdroidCommandTimeout(t *testing.T) {
+	defer func(delay time.Duration) { commandWaitDelay = delay }(commandWaitDelay)
+	commandWaitDelay = 500 * time.Millisecond
+
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{"sleeping fdroid", "echo started\nsleep 30\n"},
+		// the child keeps the output pipes open after fdroid got killed
+		{"child holding the output", "sleep 30 &\necho started\nwait\n"},
+	}
+	for _, test := range tests {
+		fh, dir := fakeFdroid(t, test.script)
+		defer os.RemoveAll(dir)
+		fh.SetTimeout(200 * time.Millisecond)
+
+		start := time.Now()
+		err := fh.RunFdroidCommand("update")
+		elapsed := time.Since(start)
+		if elapsed > 5*time.Second {
+			t.Errorf("%s: returned after %v", test.name, elapsed)
+		}
+		cmdErr, ok := err.(*CommandError)
+		if !ok {
+			t.Fatalf("%s: got %v, want a CommandError", test.name, err)
+		}
+		if !strings.Contains(cmdErr.Err.Error(), "Timed out") {
+			t.Errorf("%s: got %v, want a timeout", test.name, cmdErr.Err)
+		}
+	}
+}
+
+func TestRunFdroidCommandExitCode(t *testing.T) {
+	fh, dir := fakeFdroid(t, "exit 2\n")
+	defer os.RemoveAll(dir)
+
+	err := fh.RunFdroidCommand("update")
+	if cmdErr, ok := err.(*CommandError); !ok || cmdErr.ExitCode != 2 {
+		t.Errorf("got %v, want exit code 2", err)
+	}
+}
@@ -0,0 +1,152 @@
+package fdroidHandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	common "github.com/krombel/buildkite-artifact-downloader/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// IndexError lists APKs which are missing in the repo index after an update
+type IndexError struct {
+	Index   string
+	Missing []string
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("%s does not contain %s", e.Index, strings.Join(e.Missing, ", "))
+}
+
+// indexV1 is the part of repo/index-v1.json which is used here
+type indexV1 struct {
+	Packages map[string][]struct {
+		VersionCode int64  `json:"versionCode"`
+		Hash        string `json:"hash"`
+		HashType    string `json:"hashType"`
+	} `json:"packages"`
+}
+
+// indexV2 is the part of repo/index-v2.json which is used here
+type indexV2 struct {
+	Packages map[string]struct {
+		Versions map[string]struct {
+			File struct {
+				SHA256 string `json:"sha256"`
+			} `json:"file"`
+			Manifest struct {
+				VersionCode int64 `json:"versionCode"`
+			} `json:"manifest"`
+		} `json:"versions"`
+	} `json:"packages"`
+}
+
+// repoIndex holds the version codes per package and the SHA-256 checksums
+// of the APKs of the repo index
+type repoIndex struct {
+	versions map[string][]int64
+	sha256   map[string]bool
+}
+
+// readIndex reads the repo index. The v2 index is preferred if the repo has
+// one
+func (fh *FdroidHandler) readIndex() (string, *repoIndex, error) {
+	repo := &repoIndex{
+		versions: make(map[string][]int64),
+		sha256:   make(map[string]bool),
+	}
+	path := filepath.Join(fh.repoDir, "repo", "index-v2.json")
+	content, err := ioutil.ReadFile(path)
+	if err == nil {
+		index := indexV2{}
+		if err := json.Unmarshal(content, &index); err != nil {
+			return path, nil, fmt.Errorf("Cannot parse %s (%v)", path, err)
+		}
+		for name, pkg := range index.Packages {
+			for _, version := range pkg.Versions {
+				repo.versions[name] = append(repo.versions[name], version.Manifest.VersionCode)
+				repo.sha256[strings.ToLower(version.File.SHA256)] = true
+			}
+		}
+		return path, repo, nil
+	} else if !os.IsNotExist(err) {
+		return path, nil, fmt.Errorf("Cannot read %s (%v)", path, err)
+	}
+
+	path = filepath.Join(fh.repoDir, "repo", "index-v1.json")
+	content, err = ioutil.ReadFile(path)
+	if err != nil {
+		return path, nil, fmt.Errorf("Cannot read %s (%v)", path, err)
+	}
+	index := indexV1{}
+	if err := json.Unmarshal(content, &index); err != nil {
+		return path, nil, fmt.Errorf("Cannot parse %s (%v)", path, err)
+	}
+	for name, pkg := range index.Packages {
+		for _, version := range pkg {
+			repo.versions[name] = append(repo.versions[name], version.VersionCode)
+			if version.HashType == "sha256" {
+				repo.sha256[strings.ToLower(version.Hash)] = true
+			}
+		}
+	}
+	return path, repo, nil
+}
+
+// VerifyIndex checks that the APKs are listed with their versionCode in the
+// repo index. "fdroid update" skips APKs it cannot handle without failing,
+// so this detects APKs which were not published
+func (fh *FdroidHandler) VerifyIndex(apks []common.APKInfo) error {
+	if fh.dryRun {
+		log.Info("Dry run. Skip verifying the repo index")
+		return nil
+	}
+	path, repo, err := fh.readIndex()
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, apk := range apks {
+		found := false
+		for _, versionCode := range repo.versions[apk.PackageName] {
+			if versionCode == apk.VersionCode {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, fmt.Sprintf("%s (versionCode %d)", apk.PackageName, apk.VersionCode))
+		}
+	}
+	if len(missing) > 0 {
+		return &IndexError{Index: path, Missing: missing}
+	}
+	log.WithFields(log.Fields{
+		"index": path,
+		"apks":  len(apks),
+	}).Info("Verified repo index")
+	return nil
+}
+
+// HasNewAPKs checks whether any of the APKs (given by their SHA-256
+// checksum) is missing in the repo index, i.e. "fdroid update" would change
+// the repo. A repo without index counts as changed
+func (fh *FdroidHandler) HasNewAPKs(sha256sums []string) (bool, error) {
+	path, repo, err := fh.readIndex()
+	if err != nil {
+		if _, statErr := os.Stat(path); os.IsNotExist(statErr) {
+			return true, nil
+		}
+		return true, err
+	}
+	for _, sum := range sha256sums {
+		if !repo.sha256[strings.ToLower(sum)] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
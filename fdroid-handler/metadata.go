@@ -0,0 +1,90 @@
+package fdroidHandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	common "github.com/krombel/buildkite-artifact-downloader/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultMetadataTemplate is used for metadata stubs if no template is set.
+// Values are quoted so that they are valid YAML
+const DefaultMetadataTemplate = `License: Unknown
+Name: {{quote .PackageName}}
+CurrentVersion: {{quote .VersionName}}
+CurrentVersionCode: {{.VersionCode}}
+`
+
+// metadataFuncs are available in metadata templates
+var metadataFuncs = template.FuncMap{
+	"quote": func(value string) string {
+		// JSON strings are valid YAML scalars
+		quoted, _ := json.Marshal(value)
+		return string(quoted)
+	},
+}
+
+// SetMetadataTemplate sets the text/template file metadata stubs are created
+// from. It gets the common.APKInfo of the APK (.PackageName, .VersionName,
+// .VersionCode, .ABIs) and the function quote
+func (fh *FdroidHandler) SetMetadataTemplate(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Cannot read metadata template (%v)", err)
+	}
+	tmpl, err := template.New(filepath.Base(path)).Funcs(metadataFuncs).Parse(string(content))
+	if err != nil {
+		return fmt.Errorf("Cannot parse metadata template (%v)", err)
+	}
+	fh.metadataTemplate = tmpl
+	return nil
+}
+
+// CreateMetadataStub writes metadata/<package>.yml for APKs of applications
+// the repo has no metadata for yet, so that "fdroid update" does not reject
+// them. It returns the path of the created file or "" if metadata exists
+func (fh *FdroidHandler) CreateMetadataStub(apk common.APKInfo) (string, error) {
+	if apk.PackageName == "" {
+		return "", fmt.Errorf("APK has no package name")
+	}
+	metadataDir := filepath.Join(fh.repoDir, "metadata")
+	for _, ext := range []string{".yml", ".txt"} {
+		if _, err := os.Stat(filepath.Join(metadataDir, apk.PackageName+ext)); err == nil {
+			return "", nil
+		}
+	}
+
+	tmpl := fh.metadataTemplate
+	if tmpl == nil {
+		tmpl = template.Must(template.New("metadata").Funcs(metadataFuncs).Parse(DefaultMetadataTemplate))
+	}
+	var stub bytes.Buffer
+	if err := tmpl.Execute(&stub, apk); err != nil {
+		return "", fmt.Errorf("Cannot render metadata of %s (%v)", apk.PackageName, err)
+	}
+
+	path := filepath.Join(metadataDir, apk.PackageName+".yml")
+	if fh.dryRun {
+		log.WithFields(log.Fields{
+			"path":     path,
+			"metadata": stub.String(),
+		}).Info("Dry run. Skip creating metadata stub")
+		return path, nil
+	}
+	if err := os.MkdirAll(metadataDir, 0755); err != nil {
+		return "", fmt.Errorf("Cannot create metadata directory (%v)", err)
+	}
+	if err := ioutil.WriteFile(path, stub.Bytes(), 0644); err != nil {
+		return "", fmt.Errorf("Cannot write metadata of %s (%v)", apk.PackageName, err)
+	}
+	log.WithFields(log.Fields{
+		"path": path,
+	}).Info("Created metadata stub")
+	return path, nil
+}
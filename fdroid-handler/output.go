@@ -0,0 +1,79 @@
+package fdroidHandler
+
+import (
+	"bytes"
+	"regexp"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Known failures of fdroid detected in its output
+const (
+	FailureBadSignature         = "badSignature"
+	FailureDuplicateVersionCode = "duplicateVersionCode"
+	FailureMissingKeystore      = "missingKeystore"
+)
+
+// knownFailures maps the failures to patterns of the messages fdroid prints
+// for them. The first matching pattern wins
+var knownFailures = []struct {
+	failure string
+	pattern *regexp.Regexp
+}{
+	{FailureMissingKeystore, regexp.MustCompile(`(?i)keystore.*(not found|does not exist|no such file|missing)|(no|missing) keystore`)},
+	{FailureBadSignature, regexp.MustCompile(`(?i)signature.*(mismatch|invalid|not verif|did not verify|does not match)|(invalid|bad|unsigned) .*signature|signer.*(mismatch|does not match)`)},
+	{FailureDuplicateVersionCode, regexp.MustCompile(`(?i)(duplicate|already exists|already in).*version ?code|version ?code.*(duplicate|already exists|already in)`)},
+}
+
+// outputScanner logs the lines fdroid writes and remembers the first line
+// which matches a known failure
+type outputScanner struct {
+	level   log.Level
+	mutex   sync.Mutex
+	partial []byte
+	failure string
+	message string
+}
+
+func (s *outputScanner) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.partial = append(s.partial, p...)
+	for {
+		end := bytes.IndexByte(s.partial, '\n')
+		if end < 0 {
+			break
+		}
+		s.line(string(bytes.TrimRight(s.partial[:end], "\r")))
+		s.partial = s.partial[end+1:]
+	}
+	return len(p), nil
+}
+
+// Close handles output which did not end with a newline
+func (s *outputScanner) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.partial) > 0 {
+		s.line(string(s.partial))
+		s.partial = nil
+	}
+	return nil
+}
+
+func (s *outputScanner) line(line string) {
+	log.WithFields(log.Fields{
+		"cmd": "fdroid",
+	}).Log(s.level, line)
+	if s.failure != "" {
+		return
+	}
+	for _, known := range knownFailures {
+		if known.pattern.MatchString(line) {
+			s.failure = known.failure
+			s.message = line
+			return
+		}
+	}
+}
@@ -3,6 +3,7 @@ module github.com/krombel/buildkite-artifact-downloader
 go 1.12
 
 require (
+	github.com/avast/apkparser v0.0.0-20200924103028-30471fa5618f
 	github.com/avast/apkverifier v0.0.0-20200924121739-e6e2d5946aaf
 	github.com/sirupsen/logrus v1.4.2
 )
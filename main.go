@@ -1,28 +1,290 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	bundletoolHandler "github.com/krombel/buildkite-artifact-downloader/bundletool-handler"
+	common "github.com/krombel/buildkite-artifact-downloader/common"
 	downloader "github.com/krombel/buildkite-artifact-downloader/downloader"
 	fdroidHandler "github.com/krombel/buildkite-artifact-downloader/fdroid-handler"
+	notifier "github.com/krombel/buildkite-artifact-downloader/notifier"
+	publisher "github.com/krombel/buildkite-artifact-downloader/publisher"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// exitNothingNew is used with -onlyNew if the build was processed already
+	exitNothingNew = 3
+	// exitFdroidFailed is used if artifacts got downloaded but fdroid failed
+	exitFdroidFailed = 4
+	// exitFdroidBadSignature, exitFdroidDuplicateVersionCode and
+	// exitFdroidMissingKeystore are used for known failures of fdroid
+	exitFdroidBadSignature         = 5
+	exitFdroidDuplicateVersionCode = 6
+	exitFdroidMissingKeystore      = 7
+
+	// defaultPipelineConcurrency is the count of pipelines of -pipelines
+	// which are processed at once
+	defaultPipelineConcurrency = 4
+)
+
 var (
-	artifactFilter      *string = flag.String("artifactFilter", "", "only download file which matches this regexp")
+	onlyPassedJobs      *bool   = flag.Bool("onlyPassedJobs", false, "skip artifacts of jobs which did not pass (e.g. soft-failed jobs of a passed build)")
+	sortArtifacts       *string = flag.String("sortArtifacts", "", "download artifacts ordered by name, path or size (prefix with - to reverse; default is the order of the API)")
+	maxArtifacts        *int    = flag.Int("maxArtifacts", 0, "download at most this many artifacts per build (applied after filtering and sorting)")
+	jobFilter           *string = flag.String("jobFilter", "", "only download artifacts of jobs whose name matches this regexp")
+	messageFilter       *string = flag.String("messageFilter", "", "resolve the latest build among the builds whose commit message matches this regexp, e.g. ^Release (requires apiToken with the buildkite provider)")
+	authorFilter        *string = flag.String("authorFilter", "", "resolve the latest build among the builds whose commit author (name, email or user name) matches this regexp, e.g. ^release-bot$")
+	artifactExclude     *string = flag.String("artifactExclude", "", "do not download files which match this regexp (applied after artifactFilter)")
+	pathFilter          *string = flag.String("pathFilter", "", "only download files whose full relative path (e.g. outputs/apk/release/app.apk) matches this regexp")
 	artifactsDownloaded         = false
 	buildkiteOrg        *string = flag.String("org", "matrix-dot-org", "BuildKite Organisation")
 	buildkitePipeline   *string = flag.String("pipeline", "riot-android", "BuildKite Pipeline")
+	pipelinesFile       *string = flag.String("pipelines", "", "JSON file listing the pipelines to download ({\"concurrency\": 4, \"pipelines\": [{\"org\": ..., \"pipeline\": ..., \"branch\": ...}]}) instead of org and pipeline; they are processed concurrently")
+	provider            *string = flag.String("provider", "buildkite", "CI service the artifacts are downloaded from: buildkite, github (GitHub Actions; org and pipeline are the owner and name of the repository, authenticated with githubToken; the artifact and path filters select the uploaded artifacts by name, the mime type filter their files), circleci (org and pipeline are the organization and project of the project slug; buildId is the pipeline number) or azure (Azure DevOps; org and pipeline are the organization and project; artifacts are filtered like with github)")
+	githubWorkflow      *string = flag.String("githubWorkflow", "", "workflow (file name or ID) whose latest successful run is fetched with -provider github")
+	circleciToken       *string = flag.String("circleciToken", "", "CircleCI API token for -provider circleci (defaults to $CIRCLECI_TOKEN)")
+	circleciVCS         *string = flag.String("circleciVCS", downloader.DefaultCircleCIVCS, "VCS prefix of the CircleCI project slug (gh, bb or circleci)")
+	circleciWorkflow    *string = flag.String("circleciWorkflow", "", "only consider this workflow of the CircleCI pipelines")
+	circleciAPI         *string = flag.String("circleciAPI", downloader.DefaultCircleCIAPIURL, "API of CircleCI server installations")
+	azureDevOpsToken    *string = flag.String("azureDevOpsToken", "", "personal access token with Build (Read) scope for -provider azure (defaults to $AZURE_DEVOPS_TOKEN)")
+	azureDevOpsPipeline *string = flag.String("azureDevOpsPipeline", "", "name or ID of the Azure DevOps pipeline whose latest successful build is fetched")
+	azureDevOpsURL      *string = flag.String("azureDevOpsURL", downloader.DefaultAzureDevOpsURL, "URL of Azure DevOps Server collections")
 	buildID             *int    = flag.Int("buildId", 0, "build ID which should be fetched")
+	branch              *string = flag.String("branch", "", "branch whose latest passed build is fetched if buildId is not set (defaults to the default branch of the pipeline, or "+downloader.DefaultBranch+" without apiToken)")
 	destPath            *string = flag.String("dest", downloader.DefaultDestinationPattern, "Destination directory of artifact")
+	apiToken            *string = flag.String("apiToken", "", "BuildKite REST API token (defaults to $BUILDKITE_API_TOKEN)")
+	proxy               *string = flag.String("proxy", "", "proxy URL for all requests (defaults to $HTTPS_PROXY / $HTTP_PROXY)")
+	socks5Proxy         *string = flag.String("socks5", "", "tunnel all requests through the SOCKS5 proxy at [user[:password]@]host:port")
+	caBundle            *string = flag.String("caBundle", "", "PEM file with CA certificates which are trusted in addition to the system roots")
+	clientCert          *string = flag.String("clientCert", "", "PEM client certificate for servers which require TLS client authentication")
+	clientKey           *string = flag.String("clientKey", "", "PEM key of the client certificate (defaults to clientCert)")
+	ipVersion           *int    = flag.Int("ipVersion", 0, "only connect via IPv4 (4) or IPv6 (6)")
+	resolver            *string = flag.String("resolver", "", "resolve host names with the DNS server at <ip>[:port] instead of the system resolver")
+	maxRedirects        *int    = flag.Int("maxRedirects", downloader.DefaultMaxRedirects, "count of redirects followed per request")
+	readTimeout                 = flag.Duration("readTimeout", downloader.DefaultReadTimeout, "abort requests and downloads once the server did not send anything for this long (0 disables the timeout)")
+	rateLimit                   = flag.Float64("rateLimit", 0, "maximum requests per second to Buildkite and the artifact storage, shared by all builds of a backfill (0 for no limit)")
+	downloadAttempts    *int    = flag.Int("downloadAttempts", downloader.DefaultDownloadAttempts, "how often an interrupted or truncated download is tried")
+	force               *bool   = flag.Bool("force", false, "overwrite existing destination files")
+	backup              *bool   = flag.Bool("backup", false, "keep replaced destination files as <name>.bak-<timestamp>")
+	keepBuilds          *int    = flag.Int("keepBuilds", 0, "remove downloads of older builds matching the destination pattern except for the newest N builds (0 keeps all; dest has to contain <buildID> and <pipeline> if pipelines share it)")
+	keepDays            *int    = flag.Int("keepDays", 0, "remove downloads of older builds matching the destination pattern which are older than N days (0 keeps all; dest has to contain <buildID> and <pipeline> if pipelines share it)")
+	hardlinkDuplicates  *bool   = flag.Bool("hardlinkDuplicates", false, "hardlink downloads identical to the download of a previous build instead of storing a copy")
+	fileMode            *string = flag.String("fileMode", "0644", "permissions of written files (octal)")
+	dirMode             *string = flag.String("dirMode", "0755", "permissions of created directories (octal)")
+	owner               *string = flag.String("owner", "", "change the owner of written files to <user>[:<group>] (names or numeric ids)")
+	writeSidecars       *bool   = flag.Bool("writeMetadata", false, "write <destination>"+downloader.SidecarSuffix+" with the build, commit, job and checksums next to every download")
+	changelogRemote     *string = flag.String("changelogRemote", "", "git remote of the pipeline; writes the commits since the previous build of the stateFile as <buildID>"+downloader.ChangelogSuffix+" next to the downloads and adds them to notifications")
+	changelogCache      *string = flag.String("changelogCache", "", "directory of the bare clone of changelogRemote (defaults to the user cache directory)")
+	sizeDiff            *bool   = flag.Bool("sizeDiff", false, "compare the sizes of the downloads with the artifacts of the same name of the previous build of the stateFile and report them")
+	sizeGrowthWarn              = flag.Float64("sizeGrowthWarn", downloader.DefaultSizeGrowthWarning, "growth in percent which -sizeDiff flags as unexpected")
+	mirror              *bool   = flag.Bool("mirror", false, "download every artifact of every job into <dest>/<buildID>/<jobName>/<path> (dest is a directory then, the current one or fdroidRepoDir by default) ignoring the artifact and job filters")
+	jobLogs             *bool   = flag.Bool("jobLogs", false, "store the raw log of the jobs which produced the downloads as <buildID>-<jobName>"+downloader.JobLogSuffix+" next to them")
+	output              *string = flag.String("output", "text", "text (log output only) or json (print one JSON document describing every run to stdout)")
+	auditLog            *string = flag.String("auditLog", "", "append one JSON line per downloaded artifact to this file")
+	torrent             *bool   = flag.Bool("torrent", false, "write <destination>"+downloader.TorrentSuffix+" for downloads to distribute them peer-to-peer")
+	torrentMinSize      *string = flag.String("torrentMinSize", "", "only write torrent files for downloads of at least this size (e.g. 100MiB)")
+	ipfsAPI             *string = flag.String("ipfsAPI", "", "RPC API of an IPFS node (e.g. http://127.0.0.1:5001) every download gets added to and pinned at; the CID is recorded in the report")
+	ipfsPinService      *string = flag.String("ipfsPinService", "", "endpoint of an IPFS Pinning Service API which additionally pins the CIDs (requires -ipfsAPI)")
+	ipfsPinToken        *string = flag.String("ipfsPinToken", "", "access token of ipfsPinService (defaults to $IPFS_PIN_TOKEN)")
+	stateFile           *string = flag.String("stateFile", "", "JSON file which records the processed builds")
+	onlyNew             *bool   = flag.Bool("onlyNew", false, "skip builds which are recorded in the state file already and exit with code 3 (requires -stateFile)")
+	maxTotalSize        *string = flag.String("maxTotalSize", "", "skip the remaining artifacts of a build once this many bytes would be exceeded (e.g. 2GiB)")
+	maxArtifactSize     *string = flag.String("maxArtifactSize", "", "skip artifacts which are larger than this (e.g. 200MiB)")
+	extract             *bool   = flag.Bool("extract", false, "unpack downloaded .zip and .tar.gz artifacts into the destination directory")
+	decompressGzip      *bool   = flag.Bool("decompressGzip", false, "decompress single .gz artifacts while downloading them")
+	writeChecksums      *bool   = flag.Bool("writeChecksums", false, "write a "+downloader.ChecksumManifestName+" file into the destination directory")
+	gpgKey              *string = flag.String("gpgKey", "", "GPG key which signs the "+downloader.ChecksumManifestName+" file (implies -writeChecksums)")
+	gpgHome             *string = flag.String("gpgHome", "", "optionaly declare the GnuPG home directory")
+	verifySignatures    *bool   = flag.Bool("verifySignatures", false, "only accept artifacts with a valid detached signature (<artifact>.asc or .sig)")
+	keyring             *string = flag.String("keyring", "", "keyring to verify signatures with (defaults to the keyring of the GnuPG home)")
+	provenance          *string = flag.String("provenance", "", "regexp of provenance attestation artifacts which have to cover the downloads (checked with slsa-verifier)")
+	provenanceSubjects  *string = flag.String("provenanceSubjects", "", "regexp of artifacts which require provenance (defaults to all)")
+	provenanceSource    *string = flag.String("provenanceSource", "", "expected source repository of the provenance (e.g. github.com/org/repo)")
+	slsaVerifier        *string = flag.String("slsaVerifier", downloader.DefaultSLSAVerifier, "slsa-verifier binary")
+	scanCommand         *string = flag.String("scanCommand", "", "command which scans every download (e.g. \"clamscan --no-summary\"); non-zero exit codes reject the artifact")
+	preDownloadHook     *string = flag.String("preDownloadHook", "", "shell command which gets every artifact as JSON on stdin before it is downloaded; non-zero exit codes skip the artifact")
+	postHook            *string = flag.String("postHook", "", "shell command run after every download with BKAD_FILE, BKAD_BUILD, BKAD_COMMIT, BKAD_BRANCH, BKAD_SHA1, BKAD_SHA256, BKAD_SIZE, BKAD_APK_* and more set")
+	postRunHook         *string = flag.String("postRunHook", "", "shell command run once per build with downloads with BKAD_FILES (one destination per line), BKAD_COUNT, BKAD_BUILD and BKAD_COMMIT set")
+	quarantineDir       *string = flag.String("quarantineDir", "", "keep artifacts rejected by the scan in this directory instead of deleting them")
+	rejectDebugAPKs     *bool   = flag.Bool("rejectDebugAPKs", false, "refuse APKs which are unsigned or signed with the Android debug key")
+
+	bundletool             *string = flag.String("bundletool", "", "bundletool jar or executable which converts downloaded .aab files into universal APKs")
+	bundletoolKeystore     *string = flag.String("bundletoolKeystore", "", "keystore to sign universal APKs with (bundletool uses the debug key otherwise)")
+	bundletoolKeystorePass *string = flag.String("bundletoolKeystorePass", "", "keystore password (pass:<password> or file:<path>)")
+	bundletoolKeyAlias     *string = flag.String("bundletoolKeyAlias", "", "alias of the signing key in the keystore")
+	bundletoolKeyPass      *string = flag.String("bundletoolKeyPass", "", "key password (pass:<password> or file:<path>)")
+
+	runFdroidUpdate        *bool          = flag.Bool("runFdroidUpdate", false, "if downloader should run \"fdroid update\" after download")
+	runFdroidDeploy        *bool          = flag.Bool("runFdroidDeploy", false, "if downloader should run \"fdroid deploy\" after a successful update (requires runFdroidUpdate and deploy targets in config.yml)")
+	runFdroidPublish       *bool          = flag.Bool("runFdroidPublish", false, "if downloader should run \"fdroid publish\" between update and deploy to sign APKs with the repo key")
+	fdroidKeystorePass     *string        = flag.String("fdroidKeystorePass", "", "password of the repo keystore (pass:<password>, file:<path> or env:<variable>; passed as $"+fdroidHandler.KeystorePassEnv+")")
+	fdroidKeyPass          *string        = flag.String("fdroidKeyPass", "", "password of the repo key (pass:<password>, file:<path> or env:<variable>; passed as $"+fdroidHandler.KeyPassEnv+")")
+	fdroidUpdateArgs       *string        = flag.String("fdroidUpdateArgs", "", "additional arguments of \"fdroid update\" (e.g. \"--create-metadata --pretty\")")
+	fdroidDeployArgs       *string        = flag.String("fdroidDeployArgs", "", "additional arguments of \"fdroid deploy\"")
+	fdroidTimeout          *time.Duration = flag.Duration("fdroidTimeout", fdroidHandler.DefaultTimeout, "kill fdroid commands which run longer than this (0 disables the limit)")
+	fdroidCreateMetadata   *bool          = flag.Bool("fdroidCreateMetadata", false, "create metadata/<package>.yml for downloaded APKs of applications the repo has no metadata for")
+	fdroidMetadataTemplate *string        = flag.String("fdroidMetadataTemplate", "", "text/template file the metadata stubs are created from (gets .PackageName, .VersionName, .VersionCode and quote)")
+	fdroidVerifyIndex      *bool          = flag.Bool("fdroidVerifyIndex", false, "fail if the downloaded APKs are missing in the repo index after \"fdroid update\"")
+	fdroidSkipUnchanged    *bool          = flag.Bool("fdroidSkipUnchanged", false, "skip fdroid if all downloaded APKs are in the repo index already (compared by checksum)")
+	fdroidDryRun           *bool          = flag.Bool("fdroidDryRun", false, "only log the fdroid commands with their environment and working directory instead of running them")
+	fdroidRepoDir          *string        = flag.String("fdroidRepoDir", "", "directory of the fdroid repository fdroid runs in (relative destinations are placed in it as well)")
+	fdroidContainer        *string        = flag.String("fdroidContainer", "", "run fdroid via docker or podman with the repository bind-mounted instead of a local fdroidserver")
+	fdroidImage            *string        = flag.String("fdroidImage", fdroidHandler.DefaultContainerImage, "image of fdroidContainer (its entrypoint has to be fdroid)")
+	fdroidVirtualEnv       *string        = flag.String("fdroidVENV", "", "optionaly declare the virtualenv the downloader should use")
+
+	azureContainer        *string = flag.String("azureContainer", "", "Azure Blob Storage container the downloads get uploaded to")
+	azurePrefix           *string = flag.String("azurePrefix", "", "prefix of the blob names (e.g. nightly/)")
+	azureConnectionString *string = flag.String("azureConnectionString", "", "connection string of the storage account (defaults to $AZURE_STORAGE_CONNECTION_STRING; the managed identity is used without)")
+	azureAccount          *string = flag.String("azureAccount", "", "storage account to upload to with the managed identity")
+	azureClientID         *string = flag.String("azureClientID", "", "client ID of a user-assigned managed identity (defaults to $AZURE_CLIENT_ID)")
+	sftpTarget            *string = flag.String("sftpTarget", "", "host ([user@]host[:port], [user@]host:dir or sftp://[user@]host[:port]/dir) the downloads get uploaded to via sftp")
+	sftpKey               *string = flag.String("sftpKey", "", "private key to authenticate at sftpTarget with")
+	sftpKnownHosts        *string = flag.String("sftpKnownHosts", "", "known_hosts file to verify sftpTarget against")
+	sftpPath              *string = flag.String("sftpPath", publisher.DefaultPathPattern, "remote path of the uploads; supports <org>, <pipeline>, <buildID>, <commitID>, <branch>, <fileName>, <apkPackage>, <apkVersionName> and <apkVersionCode>")
+	rsyncSource           *string = flag.String("rsyncSource", "", "directory which gets synchronized to rsyncTarget after downloads and fdroid (e.g. repo/)")
+	rsyncTarget           *string = flag.String("rsyncTarget", "", "rsync destination (e.g. user@host:/srv/fdroid/repo/)")
+	rsyncArgs             *string = flag.String("rsyncArgs", "", "additional arguments of rsync, split at whitespace (e.g. \"--delete --chmod=F644\")")
+	githubRepo            *string = flag.String("githubRepo", "", "GitHub repository (owner/name) whose release gets the downloads as assets")
+	githubToken           *string = flag.String("githubToken", "", "token for -provider github and githubRepo, which needs write access (defaults to $GITHUB_TOKEN)")
+	githubTag             *string = flag.String("githubTag", publisher.DefaultReleaseTag, "tag of the release; supports the placeholders of sftpPath, the APK ones refer to the first APK")
+	githubAPI             *string = flag.String("githubAPI", publisher.DefaultGitHubAPI, "API of GitHub Enterprise servers for -provider github and githubRepo")
+	githubPrerelease      *bool   = flag.Bool("githubPrerelease", false, "mark created releases as pre-release")
+	gitlabProject         *string = flag.String("gitlabProject", "", "GitLab project (ID or group/name) whose generic package registry gets the downloads")
+	gitlabToken           *string = flag.String("gitlabToken", "", "token with write access to the package registry of gitlabProject (defaults to $GITLAB_TOKEN)")
+	gitlabURL             *string = flag.String("gitlabURL", publisher.DefaultGitLabURL, "URL of the GitLab server")
+	gitlabPackage         *string = flag.String("gitlabPackage", publisher.DefaultPackageName, "name of the package; supports the placeholders of sftpPath")
+	gitlabVersion         *string = flag.String("gitlabVersion", publisher.DefaultPackageVersion, "version of the package; supports the placeholders of sftpPath (e.g. <apkVersionName>)")
+	rawRepository         *string = flag.String("rawRepository", "", "URL of an Artifactory or Nexus raw repository the downloads get uploaded to")
+	rawRepositoryPath     *string = flag.String("rawRepositoryPath", publisher.DefaultRawRepositoryPath, "path of the uploads in rawRepository; supports the placeholders of sftpPath")
+	rawRepositoryUser     *string = flag.String("rawRepositoryUser", "", "user to authenticate at rawRepository with")
+	rawRepositoryPassword *string = flag.String("rawRepositoryPassword", "", "password of rawRepositoryUser (defaults to $RAW_REPOSITORY_PASSWORD)")
+	rawRepositoryToken    *string = flag.String("rawRepositoryToken", "", "access token for rawRepository, used instead of user and password (defaults to $RAW_REPOSITORY_TOKEN)")
+	ociReference          *string = flag.String("ociReference", "", "experimental: push the downloads as OCI artifact to <registry>/<repository>[:<tag>]; the tag supports the placeholders of sftpPath (default <buildID>)")
+	ociUser               *string = flag.String("ociUser", "", "user to authenticate at the registry with")
+	ociPassword           *string = flag.String("ociPassword", "", "password or token of ociUser (defaults to $OCI_PASSWORD)")
+	ociInsecure           *bool   = flag.Bool("ociInsecure", false, "talk to the registry via http instead of https")
+	playServiceAccount    *string = flag.String("playServiceAccount", "", "JSON key of a Google service account; downloaded APKs and app bundles get released on Google Play with it")
+	playPackage           *string = flag.String("playPackage", "", "application ID of downloaded app bundles (APKs provide their own)")
+	playTrack             *string = flag.String("playTrack", publisher.DefaultPlayTrack, "Google Play track the releases are created on")
+
+	notifyLinkBase   *string = flag.String("notifyLinkBase", "", "URL the downloads are served at; notifications link <notifyLinkBase>/<file name>")
+	matrixHomeserver *string = flag.String("matrixHomeserver", "https://matrix.org", "homeserver of matrixRoom")
+	matrixRoom       *string = flag.String("matrixRoom", "", "ID of a Matrix room (!<id>:<server>) which gets notified about downloads and failures")
+	matrixToken      *string = flag.String("matrixToken", "", "access token of the Matrix account which posts (defaults to $MATRIX_ACCESS_TOKEN)")
+	webhook          *string = flag.String("webhook", "", "URL which gets the run summary POSTed as JSON")
+	webhookSecret    *string = flag.String("webhookSecret", "", "secret the webhook body is signed with (HMAC-SHA256 in the "+notifier.SignatureHeader+" header; defaults to $BKAD_WEBHOOK_SECRET)")
+	smtpServer       *string = flag.String("smtpServer", "", "SMTP server (host:port) which sends mails about failed runs and rejected artifacts")
+	smtpFrom         *string = flag.String("smtpFrom", "", "sender of the mails")
+	smtpTo           *string = flag.String("smtpTo", "", "comma separated recipients of the mails")
+	smtpUser         *string = flag.String("smtpUser", "", "user to authenticate at the SMTP server with")
+	smtpPassword     *string = flag.String("smtpPassword", "", "password of smtpUser (defaults to $SMTP_PASSWORD)")
+	ntfyTopic        *string = flag.String("ntfyTopic", "", "URL of a ntfy topic (e.g. https://ntfy.sh/mytopic) which gets notified about downloads and failures")
+	ntfyToken        *string = flag.String("ntfyToken", "", "access token of protected ntfy topics (defaults to $NTFY_TOKEN)")
+	gotifyServer     *string = flag.String("gotifyServer", "", "URL of a Gotify server which gets notified about downloads and failures")
+	gotifyToken      *string = flag.String("gotifyToken", "", "application token of the Gotify server (defaults to $GOTIFY_TOKEN)")
+	telegramChat     *string = flag.String("telegramChat", "", "Telegram chat (ID or @channel) which gets new downloads announced")
+	telegramToken    *string = flag.String("telegramToken", "", "token of the Telegram bot which posts (defaults to $TELEGRAM_BOT_TOKEN)")
 
-	runFdroidUpdate  *bool   = flag.Bool("runFdroidUpdate", false, "if downloader should run \"fdroid update\" after download")
-	fdroidVirtualEnv *string = flag.String("fdroidVENV", "", "optionaly declare the virtualenv the downloader should use")
+	watchInterval *time.Duration = flag.Duration("watch", 0, "keep running and poll for new builds in this interval (e.g. 5m)")
 
 	logLevel *string = flag.String("log", "WARN", "One of DEBUG,INFO,WARN,ERROR")
 )
 
+// stringList collects the values of a flag which can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+var (
+	apkSignerPins     stringList
+	verificationRules stringList
+	latestLinks       stringList
+	artifactFilters   stringList
+	artifactGlobs     stringList
+	mimeTypes         stringList
+	stepKeys          stringList
+	metaDataFilters   stringList
+	fdroidEnv         stringList
+	publisherCommands stringList
+	githubAssets      stringList
+	torrentTrackers   stringList
+	torrentWebSeeds   stringList
+)
+
+func init() {
+	flag.Var(&artifactFilters, "artifactFilter", "only download files which match this regexp (can be repeated, files matching any of them are downloaded)")
+	flag.Var(&mimeTypes, "mimeType", "only download artifacts with this mime type, e.g. application/vnd.android.package-archive or image/* (can be repeated)")
+	flag.Var(&metaDataFilters, "metaData", "only download builds whose meta-data has this value (<key>=<value>, e.g. release=true; can be repeated, all have to match; requires apiToken). The values are available as <meta:key> in dest")
+	flag.Var(&stepKeys, "stepKey", "only download artifacts of jobs of the step with this key (can be repeated)")
+	flag.Var(&artifactGlobs, "artifactGlob", "only download files which match this shell glob, e.g. '*.apk' (can be repeated and combined with artifactFilter)")
+	flag.Var(&verificationRules, "verify", "verify artifacts matching <regexp> with a verifier (<regexp>=apk|zip|none; can be repeated, first match wins)")
+	flag.Var(&latestLinks, "latest", "maintain a symlink to the newest download of artifacts matching <regexp> (<regexp>=<link name>, e.g. 'riot-.*\\.apk$=riot-latest.apk'; can be repeated)")
+	flag.Var(&publisherCommands, "publisher", "plugin binary (with arguments) which gets the run report as JSON on stdin after every build with downloads (can be repeated)")
+	flag.Var(&torrentTrackers, "torrentTracker", "announce URL of a tracker for the torrent files (can be repeated)")
+	flag.Var(&torrentWebSeeds, "torrentWebSeed", "HTTP URL serving the downloads for the torrent files; the file name is appended to URLs ending with / (can be repeated)")
+	flag.Var(&githubAssets, "githubAsset", "glob of the downloads which are uploaded to the GitHub release (can be repeated; all if not given)")
+	flag.Var(&fdroidEnv, "fdroidEnv", "pass [<command>:]NAME=value to all fdroid commands or only to the given one, e.g. update:ANDROID_HOME=/opt/android (can be repeated)")
+	flag.Var(&apkSignerPins, "apkSignerPin", "only accept APKs matching <regexp> when every signer uses one of the certificates (<regexp>=<sha256>[,<sha256>...]; can be repeated)")
+}
+
+// parseMode parses the octal permissions given with the flag name
+func parseMode(name string, value string) os.FileMode {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		log.WithFields(log.Fields{
+			name: value,
+		}).Fatal("Mode has to be given in octal notation (e.g. 0644)")
+	}
+	return os.FileMode(mode)
+}
+
+// parseOwner resolves <user>[:<group>] to numeric ids. Without a group the
+// primary group of the user is used
+func parseOwner(owner string) (int, int, error) {
+	parts := strings.SplitN(owner, ":", 2)
+	uid, err := strconv.Atoi(parts[0])
+	primaryGID := "-1"
+	if err != nil {
+		account, err := user.Lookup(parts[0])
+		if err != nil {
+			return 0, 0, err
+		}
+		uid, _ = strconv.Atoi(account.Uid)
+		primaryGID = account.Gid
+	}
+	group := primaryGID
+	if len(parts) == 2 && parts[1] != "" {
+		group = parts[1]
+	}
+	gid, err := strconv.Atoi(group)
+	if err != nil {
+		lookup, err := user.LookupGroup(group)
+		if err != nil {
+			return 0, 0, err
+		}
+		gid, _ = strconv.Atoi(lookup.Gid)
+	}
+	return uid, gid, nil
+}
+
 func setLoglevel() {
 	if *logLevel == "DEBUG" {
 		log.SetLevel(log.DebugLevel)
@@ -39,6 +301,621 @@ func setLoglevel() {
 	}
 }
 
+// convertBundles builds universal APKs for all downloaded app bundles
+func convertBundles(results []downloader.DownloadResult) {
+	bh := bundletoolHandler.NewBundletoolHandler(*bundletool)
+	if *bundletoolKeystore != "" {
+		err := bh.SetKeystore(*bundletoolKeystore, *bundletoolKeystorePass, *bundletoolKeyAlias, *bundletoolKeyPass)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+	}
+	for _, result := range results {
+		if !strings.HasSuffix(result.Destination, ".aab") {
+			continue
+		}
+		if _, err := bh.BuildUniversalAPK(result.Destination); err != nil {
+			log.Warn(err)
+		}
+	}
+}
+
+// fdroidResult describes the fdroid commands of a run
+type fdroidResult struct {
+	Commands []string `json:"commands"`
+	Error    string   `json:"error,omitempty"`
+	ExitCode int      `json:"exitCode,omitempty"`
+	Failure  string   `json:"failure,omitempty"`
+}
+
+// publisherResult describes the outcome of a publisher
+type publisherResult struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// runOutput is printed for every run with -output json
+type runOutput struct {
+	downloader.RunReport
+	Error      string            `json:"error,omitempty"`
+	Fdroid     *fdroidResult     `json:"fdroid,omitempty"`
+	Publishers []publisherResult `json:"publishers,omitempty"`
+}
+
+// hasNewAPKs checks whether any downloaded APK is missing in the repo index
+func hasNewAPKs(fh *fdroidHandler.FdroidHandler, downloads []downloader.DownloadResult) bool {
+	var sums []string
+	for _, download := range downloads {
+		if strings.HasSuffix(download.Destination, ".apk") {
+			sums = append(sums, download.SHA256)
+		}
+	}
+	if len(sums) == 0 {
+		return false
+	}
+	hasNew, err := fh.HasNewAPKs(sums)
+	if err != nil {
+		log.Warn(err)
+	}
+	return hasNew
+}
+
+// runFdroid creates missing metadata if enabled and runs fdroid update and,
+// if enabled, publish and deploy. Every command only runs if the previous
+// ones succeeded
+func runFdroid(fh *fdroidHandler.FdroidHandler, downloads []downloader.DownloadResult, result *fdroidResult) error {
+	if *fdroidCreateMetadata {
+		for _, download := range downloads {
+			if download.APK == nil {
+				continue
+			}
+			if _, err := fh.CreateMetadataStub(*download.APK); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+	updateArgs := strings.Fields(*fdroidUpdateArgs)
+	result.Commands = append(result.Commands, strings.Join(append([]string{"update"}, updateArgs...), " "))
+	if err := fh.RunFdroidCommand("update", updateArgs...); err != nil {
+		return err
+	}
+	if *fdroidVerifyIndex {
+		var apks []common.APKInfo
+		for _, download := range downloads {
+			if download.APK != nil {
+				apks = append(apks, *download.APK)
+			}
+		}
+		if err := fh.VerifyIndex(apks); err != nil {
+			return err
+		}
+	}
+	if *runFdroidPublish {
+		result.Commands = append(result.Commands, "publish")
+		if err := fh.RunFdroidCommand("publish"); err != nil {
+			return err
+		}
+	}
+	if !*runFdroidDeploy {
+		return nil
+	}
+	hasTargets, err := fh.HasDeployTargets()
+	if err != nil {
+		log.Warn(err)
+		return nil
+	}
+	if !hasTargets {
+		log.Warn("No deploy targets configured in config.yml. Skip fdroid deploy")
+		return nil
+	}
+	deployArgs := strings.Fields(*fdroidDeployArgs)
+	result.Commands = append(result.Commands, strings.Join(append([]string{"deploy"}, deployArgs...), " "))
+	return fh.RunFdroidCommand("deploy", deployArgs...)
+}
+
+// fdroidFailure marks errors of runFdroid so that main can use a distinct
+// exit code
+type fdroidFailure struct {
+	error
+}
+
+// fdroidExitCode returns the exit code for a failure of runFdroid
+func fdroidExitCode(err error) int {
+	if cmdErr, ok := err.(*fdroidHandler.CommandError); ok {
+		switch cmdErr.Failure {
+		case fdroidHandler.FailureBadSignature:
+			return exitFdroidBadSignature
+		case fdroidHandler.FailureDuplicateVersionCode:
+			return exitFdroidDuplicateVersionCode
+		case fdroidHandler.FailureMissingKeystore:
+			return exitFdroidMissingKeystore
+		}
+	}
+	return exitFdroidFailed
+}
+
+// postProcessLock serializes fdroid, the publishers, the notifiers and the
+// output of concurrent runs
+var postProcessLock sync.Mutex
+
+// runDownload fetches the artifacts of the configured (or latest) build and
+// runs fdroid, the publishers and the notifiers afterwards. It returns the
+// count of downloads and the error of the download or, if that succeeded, of
+// fdroid
+func runDownload(buildkiteHandler *downloader.BuildkiteHandler, fh *fdroidHandler.FdroidHandler, publishers []publisher.Publisher, notifiers []notifier.Notifier) (int, error) {
+	// reset the buildID so that the latest build gets resolved on every run
+	buildkiteHandler.SetBuildID(*buildID)
+
+	downloads, err := buildkiteHandler.Start()
+	report := runOutput{RunReport: buildkiteHandler.Report()}
+	if err == downloader.ErrNothingNew {
+		report.Error = err.Error()
+	} else if err != nil {
+		log.Warn(err)
+		report.Error = err.Error()
+	}
+
+	if *bundletool != "" {
+		convertBundles(buildkiteHandler.Results())
+	}
+
+	// the pipelines of -pipelines share the fdroid repository, the
+	// publishers and stdout
+	postProcessLock.Lock()
+	defer postProcessLock.Unlock()
+
+	if downloads > 0 && fh != nil && *fdroidSkipUnchanged && !hasNewAPKs(fh, buildkiteHandler.Results()) {
+		log.Info("No new APKs. Skip fdroid")
+	} else if downloads > 0 && fh != nil {
+		report.Fdroid = &fdroidResult{}
+		if fdroidErr := runFdroid(fh, buildkiteHandler.Results(), report.Fdroid); fdroidErr != nil {
+			log.Error(fdroidErr)
+			report.Fdroid.Error = fdroidErr.Error()
+			if cmdErr, ok := fdroidErr.(*fdroidHandler.CommandError); ok {
+				report.Fdroid.ExitCode = cmdErr.ExitCode
+				report.Fdroid.Failure = cmdErr.Failure
+			}
+			if err == nil {
+				err = fdroidFailure{fdroidErr}
+			}
+		}
+	}
+
+	if downloads > 0 {
+		for _, pub := range publishers {
+			result := publisherResult{Name: pub.Name()}
+			if err := pub.Publish(report.RunReport); err != nil {
+				log.Error(err)
+				result.Error = err.Error()
+			}
+			report.Publishers = append(report.Publishers, result)
+		}
+	}
+
+	if downloads > 0 || len(report.Failed) > 0 || (err != nil && err != downloader.ErrNothingNew) {
+		event := notifier.Event{RunReport: report.RunReport, LinkBase: *notifyLinkBase}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		for _, n := range notifiers {
+			if err := n.Notify(event); err != nil {
+				log.WithFields(log.Fields{
+					"notifier": n.Name(),
+					"error":    err,
+				}).Error("Cannot send notification")
+			}
+		}
+	}
+
+	if *output == "json" {
+		if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			log.Error(err)
+		}
+	}
+	return downloads, err
+}
+
+// notifyTargets are the destinations notifications are sent to. The
+// servers and credentials are taken from the flags
+type notifyTargets struct {
+	MatrixRoom   string `json:"matrixRoom,omitempty"`
+	Webhook      string `json:"webhook,omitempty"`
+	SMTPTo       string `json:"smtpTo,omitempty"`
+	NtfyTopic    string `json:"ntfyTopic,omitempty"`
+	GotifyServer string `json:"gotifyServer,omitempty"`
+	TelegramChat string `json:"telegramChat,omitempty"`
+}
+
+// newNotifiers sets up a notifier for every configured target
+func newNotifiers(targets notifyTargets) []notifier.Notifier {
+	var notifiers []notifier.Notifier
+	if targets.MatrixRoom != "" {
+		if *matrixToken == "" {
+			*matrixToken = os.Getenv("MATRIX_ACCESS_TOKEN")
+		}
+		mn, err := notifier.NewMatrixNotifier(*matrixHomeserver, targets.MatrixRoom, *matrixToken)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"matrixRoom": targets.MatrixRoom,
+				"error":      err,
+			}).Fatal("Cannot set up Matrix notifications")
+		}
+		notifiers = append(notifiers, mn)
+	}
+
+	if targets.Webhook != "" {
+		if *webhookSecret == "" {
+			*webhookSecret = os.Getenv("BKAD_WEBHOOK_SECRET")
+		}
+		wn, err := notifier.NewWebhookNotifier(targets.Webhook, *webhookSecret)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"webhook": targets.Webhook,
+				"error":   err,
+			}).Fatal("Cannot set up webhook")
+		}
+		notifiers = append(notifiers, wn)
+	}
+
+	if targets.SMTPTo != "" {
+		if *smtpPassword == "" {
+			*smtpPassword = os.Getenv("SMTP_PASSWORD")
+		}
+		sn, err := notifier.NewSMTPNotifier(*smtpServer, *smtpFrom, strings.Split(targets.SMTPTo, ","), *smtpUser, *smtpPassword)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"smtpServer": *smtpServer,
+				"error":      err,
+			}).Fatal("Cannot set up mail notifications")
+		}
+		notifiers = append(notifiers, sn)
+	}
+
+	if targets.NtfyTopic != "" {
+		if *ntfyToken == "" {
+			*ntfyToken = os.Getenv("NTFY_TOKEN")
+		}
+		nn, err := notifier.NewNtfyNotifier(targets.NtfyTopic, *ntfyToken)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"ntfyTopic": targets.NtfyTopic,
+				"error":     err,
+			}).Fatal("Cannot set up ntfy notifications")
+		}
+		notifiers = append(notifiers, nn)
+	}
+	if targets.GotifyServer != "" {
+		if *gotifyToken == "" {
+			*gotifyToken = os.Getenv("GOTIFY_TOKEN")
+		}
+		gn, err := notifier.NewGotifyNotifier(targets.GotifyServer, *gotifyToken)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"gotifyServer": targets.GotifyServer,
+				"error":        err,
+			}).Fatal("Cannot set up Gotify notifications")
+		}
+		notifiers = append(notifiers, gn)
+	}
+	if targets.TelegramChat != "" {
+		if *telegramToken == "" {
+			*telegramToken = os.Getenv("TELEGRAM_BOT_TOKEN")
+		}
+		tn, err := notifier.NewTelegramNotifier(*telegramToken, targets.TelegramChat)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"telegramChat": targets.TelegramChat,
+				"error":        err,
+			}).Fatal("Cannot set up Telegram notifications")
+		}
+		notifiers = append(notifiers, tn)
+	}
+	return notifiers
+}
+
+// isFlagSet reports if the flag name was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// repoRelative places relative destinations in the repository fdroid runs in
+func repoRelative(dest string) string {
+	if *fdroidRepoDir != "" && dest != "" && !filepath.IsAbs(dest) {
+		return strings.TrimSuffix(*fdroidRepoDir, "/") + "/" + dest
+	}
+	return dest
+}
+
+// exitCode tells with the exit code whether artifacts got downloaded
+func exitCode(downloads int, err error) int {
+	if failure, ok := err.(fdroidFailure); ok {
+		return fdroidExitCode(failure.error)
+	} else if err == downloader.ErrNothingNew {
+		return exitNothingNew
+	} else if downloads > 0 {
+		return 0
+	}
+	return 1
+}
+
+// pipelineConfig is a pipeline of the -pipelines file. The other fields
+// override the flags of the same name for the pipeline, lists replace the
+// values of repeated flags
+type pipelineConfig struct {
+	Org      string `json:"org"`
+	Pipeline string `json:"pipeline"`
+	Branch   string `json:"branch,omitempty"`
+	// Dest is the directory of the pipeline with -mirror
+	Dest            *string  `json:"dest,omitempty"`
+	ArtifactFilters []string `json:"artifactFilters,omitempty"`
+	ArtifactGlobs   []string `json:"artifactGlobs,omitempty"`
+	MimeTypes       []string `json:"mimeTypes,omitempty"`
+	StepKeys        []string `json:"stepKeys,omitempty"`
+	ArtifactExclude *string  `json:"artifactExclude,omitempty"`
+	PathFilter      *string  `json:"pathFilter,omitempty"`
+	JobFilter       *string  `json:"jobFilter,omitempty"`
+	PreDownloadHook *string  `json:"preDownloadHook,omitempty"`
+	PostHook        *string  `json:"postHook,omitempty"`
+	PostRunHook     *string  `json:"postRunHook,omitempty"`
+	// Notify replaces the notification targets of the flags, targets which
+	// are not given are not notified
+	Notify *notifyTargets `json:"notify,omitempty"`
+	// Fdroid disables fdroid for the pipeline if false. It requires
+	// runFdroidUpdate
+	Fdroid *bool `json:"fdroid,omitempty"`
+}
+
+// pipelineRun is a pipeline of the -pipelines file set up for runDownload
+type pipelineRun struct {
+	handler   *downloader.BuildkiteHandler
+	fh        *fdroidHandler.FdroidHandler
+	notifiers []notifier.Notifier
+}
+
+// pipelinesConfig is the content of the -pipelines file
+type pipelinesConfig struct {
+	// Concurrency is the count of pipelines processed at once
+	Concurrency int              `json:"concurrency,omitempty"`
+	Pipelines   []pipelineConfig `json:"pipelines"`
+}
+
+// readPipelinesConfig reads the -pipelines file
+func readPipelinesConfig(path string) (*pipelinesConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &pipelinesConfig{}
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("Cannot parse %s (%v)", path, err)
+	}
+	if len(config.Pipelines) == 0 {
+		return nil, fmt.Errorf("%s lists no pipelines", path)
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = defaultPipelineConcurrency
+	}
+	return config, nil
+}
+
+// newPipelineRun sets up the pipeline of entry with the configuration of
+// base, fh and notifiers and the overrides of entry
+func newPipelineRun(base *downloader.BuildkiteHandler, entry pipelineConfig, fh *fdroidHandler.FdroidHandler, notifiers []notifier.Notifier) (pipelineRun, error) {
+	handler, err := base.ForPipeline(entry.Org, entry.Pipeline)
+	if err != nil {
+		return pipelineRun{}, err
+	}
+	run := pipelineRun{handler: handler, fh: fh, notifiers: notifiers}
+	if entry.Branch != "" {
+		handler.SetBranch(entry.Branch)
+	}
+	if entry.Dest != nil {
+		dest := *entry.Dest
+		if *mirror {
+			if dest == "" {
+				dest = "."
+			}
+			handler.SetMirror(repoRelative(dest))
+		} else if err := handler.SetDestinationPattern(repoRelative(dest)); err != nil {
+			return pipelineRun{}, fmt.Errorf("Cannot parse dest (%v)", err)
+		}
+	}
+	if entry.ArtifactFilters != nil {
+		handler.SetArtifactFilter("")
+		for _, filter := range entry.ArtifactFilters {
+			if err := handler.AddArtifactFilter(filter); err != nil {
+				return pipelineRun{}, fmt.Errorf("Cannot parse artifactFilter %s (%v)", filter, err)
+			}
+		}
+	}
+	if entry.ArtifactGlobs != nil {
+		if err := handler.SetArtifactGlobs(entry.ArtifactGlobs); err != nil {
+			return pipelineRun{}, err
+		}
+	}
+	if entry.MimeTypes != nil {
+		if err := handler.SetMimeTypes(entry.MimeTypes); err != nil {
+			return pipelineRun{}, err
+		}
+	}
+	if entry.StepKeys != nil {
+		handler.SetStepKeys(entry.StepKeys)
+	}
+	if entry.ArtifactExclude != nil {
+		if err := handler.SetArtifactExclude(*entry.ArtifactExclude); err != nil {
+			return pipelineRun{}, fmt.Errorf("Cannot parse artifactExclude (%v)", err)
+		}
+	}
+	if entry.PathFilter != nil {
+		if err := handler.SetPathFilter(*entry.PathFilter); err != nil {
+			return pipelineRun{}, fmt.Errorf("Cannot parse pathFilter (%v)", err)
+		}
+	}
+	if entry.JobFilter != nil {
+		if err := handler.SetJobFilter(*entry.JobFilter); err != nil {
+			return pipelineRun{}, fmt.Errorf("Cannot parse jobFilter (%v)", err)
+		}
+	}
+	if entry.PreDownloadHook != nil {
+		handler.SetPreDownloadHook(*entry.PreDownloadHook)
+	}
+	if entry.PostHook != nil {
+		handler.SetPostHook(*entry.PostHook)
+	}
+	if entry.PostRunHook != nil {
+		handler.SetPostRunHook(*entry.PostRunHook)
+	}
+	if entry.Notify != nil {
+		run.notifiers = newNotifiers(*entry.Notify)
+	}
+	if entry.Fdroid != nil {
+		if !*entry.Fdroid {
+			run.fh = nil
+		} else if fh == nil {
+			return pipelineRun{}, fmt.Errorf("fdroid requires runFdroidUpdate")
+		}
+	}
+	return run, nil
+}
+
+// runPipelines runs runDownload for every pipeline, concurrency of them at
+// once. It returns the exit code of the worst run: an fdroid failure, a
+// failed run, downloads and nothing new in this order
+func runPipelines(runs []pipelineRun, concurrency int, publishers []publisher.Publisher) int {
+	codes := make([]int, len(runs))
+	downloads := make([]int, len(runs))
+	queue := make(chan int)
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for index := range queue {
+				run := runs[index]
+				count, err := runDownload(run.handler, run.fh, publishers, run.notifiers)
+				downloads[index] = count
+				codes[index] = exitCode(count, err)
+			}
+		}()
+	}
+	for i := range runs {
+		queue <- i
+	}
+	close(queue)
+	workers.Wait()
+
+	var total, failed, nothingNew int
+	for i, handlerCode := range codes {
+		total += downloads[i]
+		if handlerCode == 1 || handlerCode >= exitFdroidFailed {
+			failed++
+		} else if handlerCode == exitNothingNew {
+			nothingNew++
+		}
+	}
+	log.WithFields(log.Fields{
+		"pipelines":  len(runs),
+		"downloads":  total,
+		"failed":     failed,
+		"nothingNew": nothingNew,
+	}).Info("Pipelines processed")
+	return combineExitCodes(codes)
+}
+
+// combineExitCodes returns the exit code of the worst pipeline: the first
+// fdroid failure, then a failed pipeline, then downloads and finally nothing
+// new
+func combineExitCodes(codes []int) int {
+	code := exitNothingNew
+	for _, handlerCode := range codes {
+		switch {
+		case handlerCode >= exitFdroidFailed:
+			if code < exitFdroidFailed {
+				code = handlerCode
+			}
+		case handlerCode == 1:
+			if code < exitFdroidFailed {
+				code = 1
+			}
+		case handlerCode == 0:
+			if code == exitNothingNew {
+				code = 0
+			}
+		}
+	}
+	return code
+}
+
+// runBackfill downloads the builds of the pipeline back to the build number
+// or date given with -until (backfill subcommand). It returns the exit code
+func runBackfill(buildkiteHandler *downloader.BuildkiteHandler, args []string) int {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	until := flags.String("until", "", "build number or date (2006-01-02) the backfill stops at (required)")
+	state := flags.String("state", "passed", "only backfill builds in this state (empty for all)")
+	concurrency := flags.Int("concurrency", downloader.DefaultBackfillConcurrency, "count of builds downloaded at once")
+	flags.Parse(args)
+
+	opts := downloader.BackfillOptions{
+		Branch:      *branch,
+		State:       *state,
+		Concurrency: *concurrency,
+	}
+	if number, err := strconv.Atoi(*until); err == nil {
+		opts.UntilBuild = number
+	} else if date, err := time.Parse("2006-01-02", *until); err == nil {
+		opts.UntilDate = date
+	} else {
+		log.WithFields(log.Fields{
+			"until": *until,
+		}).Fatal("until has to be a build number or date")
+	}
+
+	var downloads, failed int
+	err := buildkiteHandler.Backfill(opts, func(result downloader.BackfillResult) {
+		downloads += result.Downloads
+		fields := log.Fields{
+			"buildID":   result.BuildID,
+			"downloads": result.Downloads,
+		}
+		if result.Err != nil && result.Err != downloader.ErrNothingNew {
+			failed++
+			fields["error"] = result.Err
+			log.WithFields(fields).Warn("Backfill of build failed")
+		} else {
+			log.WithFields(fields).Info("Build backfilled")
+		}
+		if *output == "json" {
+			report := runOutput{RunReport: result.Report}
+			if result.Err != nil {
+				report.Error = result.Err.Error()
+			}
+			if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+				log.Error(err)
+			}
+		}
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Backfill aborted")
+	}
+	log.WithFields(log.Fields{
+		"downloads": downloads,
+		"failed":    failed,
+	}).Info("Backfill finished")
+	if downloads > 0 {
+		return 0
+	}
+	return 1
+}
+
 func main() {
 	flag.Parse()
 
@@ -47,45 +924,633 @@ func main() {
 	buildkiteHandler := downloader.NewBuildkiteHandler(
 		*buildkiteOrg, *buildkitePipeline,
 	)
-	if *destPath != "" {
-		buildkiteHandler.SetDestinationPattern(*destPath)
+	if *output != "text" && *output != "json" {
+		log.WithFields(log.Fields{
+			"output": *output,
+		}).Fatal("output has to be text or json")
 	}
-
-	if *buildID > 0 {
-		buildkiteHandler.SetBuildID(*buildID)
+	if *mirror {
+		// the default destination is a pattern and no directory
+		mirrorDir := "."
+		if isFlagSet("dest") && *destPath != "" {
+			mirrorDir = *destPath
+		}
+		*destPath = repoRelative(mirrorDir)
+		buildkiteHandler.SetMirror(*destPath)
+	} else if *destPath != "" {
+		*destPath = repoRelative(*destPath)
+		if err := buildkiteHandler.SetDestinationPattern(*destPath); err != nil {
+			log.WithFields(log.Fields{
+				"dest":  *destPath,
+				"error": err,
+			}).Fatal("Cannot parse destination pattern")
+		}
+	}
+	buildkiteHandler.SetBranch(*branch)
+	buildkiteHandler.SetDownloadAttempts(*downloadAttempts)
+	buildkiteHandler.SetMaxRedirects(*maxRedirects)
+	if err := buildkiteHandler.SetReadTimeout(*readTimeout); err != nil {
+		log.WithFields(log.Fields{
+			"readTimeout": *readTimeout,
+			"error":       err,
+		}).Fatal("Cannot set read timeout")
+	}
+	if err := buildkiteHandler.SetRateLimit(*rateLimit); err != nil {
+		log.WithFields(log.Fields{
+			"rateLimit": *rateLimit,
+			"error":     err,
+		}).Fatal("Cannot set up rate limit")
+	}
+	if err := buildkiteHandler.SetIPVersion(*ipVersion); err != nil {
+		log.WithFields(log.Fields{
+			"ipVersion": *ipVersion,
+			"error":     err,
+		}).Fatal("Cannot set IP version")
+	}
+	if *resolver != "" {
+		if err := buildkiteHandler.SetResolver(*resolver); err != nil {
+			log.WithFields(log.Fields{
+				"resolver": *resolver,
+				"error":    err,
+			}).Fatal("Cannot set up resolver")
+		}
+	}
+	if *caBundle != "" {
+		if err := buildkiteHandler.AddCABundle(*caBundle); err != nil {
+			log.WithFields(log.Fields{
+				"caBundle": *caBundle,
+				"error":    err,
+			}).Fatal("Cannot load CA bundle")
+		}
+	}
+	if *clientCert != "" {
+		keyFile := *clientKey
+		if keyFile == "" {
+			keyFile = *clientCert
+		}
+		if err := buildkiteHandler.SetClientCertificate(*clientCert, keyFile); err != nil {
+			log.WithFields(log.Fields{
+				"clientCert": *clientCert,
+				"clientKey":  keyFile,
+				"error":      err,
+			}).Fatal("Cannot load client certificate")
+		}
+	} else if *clientKey != "" {
+		log.Fatal("clientKey requires clientCert")
+	}
+	if *proxy != "" && *socks5Proxy != "" {
+		log.Fatal("proxy and socks5 cannot be combined")
+	}
+	if *socks5Proxy != "" {
+		if err := buildkiteHandler.SetSocks5Proxy(*socks5Proxy); err != nil {
+			log.WithFields(log.Fields{
+				"socks5": *socks5Proxy,
+				"error":  err,
+			}).Fatal("Cannot set up SOCKS5 proxy")
+		}
+	}
+	if *proxy != "" {
+		if err := buildkiteHandler.SetProxy(*proxy); err != nil {
+			log.WithFields(log.Fields{
+				"proxy": *proxy,
+				"error": err,
+			}).Fatal("Cannot set up proxy")
+		}
+	}
+	buildkiteHandler.SetForceOverwrite(*force)
+	buildkiteHandler.SetBackupExisting(*backup)
+	if err := buildkiteHandler.SetRetention(*keepBuilds, *keepDays); err != nil {
+		log.WithFields(log.Fields{
+			"keepBuilds": *keepBuilds,
+			"keepDays":   *keepDays,
+			"error":      err,
+		}).Fatal("Cannot set up retention")
+	}
+	buildkiteHandler.SetHardlinkDuplicates(*hardlinkDuplicates)
+	buildkiteHandler.SetFileMode(parseMode("fileMode", *fileMode))
+	buildkiteHandler.SetDirMode(parseMode("dirMode", *dirMode))
+	if *owner != "" {
+		uid, gid, err := parseOwner(*owner)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"owner": *owner,
+				"error": err,
+			}).Fatal("Cannot parse owner")
+		}
+		buildkiteHandler.SetOwner(uid, gid)
+	}
+	buildkiteHandler.SetWriteSidecars(*writeSidecars)
+	buildkiteHandler.SetDownloadJobLogs(*jobLogs)
+	buildkiteHandler.SetAuditLog(*auditLog)
+	if *torrent {
+		minSize := int64(0)
+		if *torrentMinSize != "" {
+			size, err := common.ParseSize(*torrentMinSize)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"torrentMinSize": *torrentMinSize,
+					"error":          err,
+				}).Fatal("Cannot parse torrentMinSize")
+			}
+			minSize = size
+		}
+		buildkiteHandler.SetTorrent(torrentTrackers, torrentWebSeeds, minSize)
+	}
+	if err := buildkiteHandler.SetIPFS(*ipfsAPI); err != nil {
+		log.WithFields(log.Fields{
+			"ipfsAPI": *ipfsAPI,
+			"error":   err,
+		}).Fatal("Cannot set up IPFS")
+	}
+	if *ipfsPinToken == "" {
+		*ipfsPinToken = os.Getenv("IPFS_PIN_TOKEN")
+	}
+	if err := buildkiteHandler.SetIPFSPinningService(*ipfsPinService, *ipfsPinToken); err != nil {
+		log.WithFields(log.Fields{
+			"ipfsPinService": *ipfsPinService,
+			"error":          err,
+		}).Fatal("Cannot set up IPFS pinning service")
+	}
+	buildkiteHandler.SetStateFile(*stateFile)
+	if err := buildkiteHandler.SetOnlyNew(*onlyNew); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("Cannot set up onlyNew")
+	}
+	if err := buildkiteHandler.SetChangelog(*changelogRemote, *changelogCache); err != nil {
+		log.WithFields(log.Fields{
+			"changelogRemote": *changelogRemote,
+			"error":           err,
+		}).Fatal("Cannot set up changelog")
+	}
+	if err := buildkiteHandler.SetSizeDiff(*sizeDiff, *sizeGrowthWarn); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("Cannot set up sizeDiff")
+	}
+	if *maxTotalSize != "" {
+		size, err := common.ParseSize(*maxTotalSize)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"maxTotalSize": *maxTotalSize,
+				"error":        err,
+			}).Fatal("Cannot parse maxTotalSize")
+		}
+		buildkiteHandler.SetMaxTotalSize(size)
+	}
+	if *maxArtifactSize != "" {
+		size, err := common.ParseSize(*maxArtifactSize)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"maxArtifactSize": *maxArtifactSize,
+				"error":           err,
+			}).Fatal("Cannot parse maxArtifactSize")
+		}
+		buildkiteHandler.SetMaxArtifactSize(size)
+	}
+	buildkiteHandler.SetExtractArchives(*extract)
+	buildkiteHandler.SetDecompressGzip(*decompressGzip)
+	buildkiteHandler.SetWriteChecksumManifest(*writeChecksums || *gpgKey != "")
+	buildkiteHandler.SetSigningKey(*gpgKey)
+	buildkiteHandler.SetGPGHome(*gpgHome)
+	if err := buildkiteHandler.SetProvenance(*provenance, *provenanceSubjects, *provenanceSource); err != nil {
+		log.WithFields(log.Fields{
+			"provenance": *provenance,
+			"error":      err,
+		}).Fatal("Cannot set up provenance verification")
+	}
+	buildkiteHandler.SetSLSAVerifier(*slsaVerifier)
+	buildkiteHandler.SetScanCommand(*scanCommand)
+	buildkiteHandler.SetPreDownloadHook(*preDownloadHook)
+	buildkiteHandler.SetPostHook(*postHook)
+	buildkiteHandler.SetPostRunHook(*postRunHook)
+	if err := buildkiteHandler.SetQuarantineDir(*quarantineDir); err != nil {
+		log.WithFields(log.Fields{
+			"quarantineDir": *quarantineDir,
+			"error":         err,
+		}).Fatal("Cannot set up quarantine")
+	}
+	buildkiteHandler.SetRejectDebugAPKs(*rejectDebugAPKs)
+	for _, rule := range verificationRules {
+		separator := strings.LastIndex(rule, "=")
+		if separator < 0 {
+			log.WithFields(log.Fields{
+				"verify": rule,
+			}).Fatal("verify has to be in the format <regexp>=<verifier>")
+		}
+		if err := buildkiteHandler.AddVerificationRule(rule[:separator], rule[separator+1:]); err != nil {
+			log.WithFields(log.Fields{
+				"verify": rule,
+				"error":  err,
+			}).Fatal("Cannot parse verification rule")
+		}
+	}
+	for _, filter := range metaDataFilters {
+		separator := strings.Index(filter, "=")
+		if separator < 0 {
+			log.WithFields(log.Fields{
+				"metaData": filter,
+			}).Fatal("metaData has to be in the format <key>=<value>")
+		}
+		if err := buildkiteHandler.AddMetaDataFilter(filter[:separator], filter[separator+1:]); err != nil {
+			log.WithFields(log.Fields{
+				"metaData": filter,
+				"error":    err,
+			}).Fatal("Cannot parse meta-data filter")
+		}
+	}
+	for _, link := range latestLinks {
+		separator := strings.LastIndex(link, "=")
+		if separator < 0 {
+			log.WithFields(log.Fields{
+				"latest": link,
+			}).Fatal("latest has to be in the format <regexp>=<link name>")
+		}
+		if err := buildkiteHandler.AddLatestLink(link[:separator], link[separator+1:]); err != nil {
+			log.WithFields(log.Fields{
+				"latest": link,
+				"error":  err,
+			}).Fatal("Cannot parse latest link")
+		}
+	}
+	for _, pin := range apkSignerPins {
+		separator := strings.LastIndex(pin, "=")
+		if separator < 0 {
+			log.WithFields(log.Fields{
+				"apkSignerPin": pin,
+			}).Fatal("apkSignerPin has to be in the format <regexp>=<sha256>[,<sha256>...]")
+		}
+		err := buildkiteHandler.AddAPKSignerPin(pin[:separator], strings.Split(pin[separator+1:], ","))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"apkSignerPin": pin,
+				"error":        err,
+			}).Fatal("Cannot parse apkSignerPin")
+		}
+	}
+	if err := buildkiteHandler.SetVerifySignatures(*verifySignatures, *keyring); err != nil {
+		log.WithFields(log.Fields{
+			"keyring": *keyring,
+			"error":   err,
+		}).Fatal("Cannot set up signature verification")
+	}
+	if *apiToken == "" {
+		*apiToken = os.Getenv("BUILDKITE_API_TOKEN")
+	}
+	if *apiToken != "" {
+		buildkiteHandler.SetAPIToken(*apiToken)
+	}
+	switch *provider {
+	case "buildkite":
+	case "github":
+		if *githubToken == "" {
+			*githubToken = os.Getenv("GITHUB_TOKEN")
+		}
+		if err := buildkiteHandler.SetGitHubActions(*githubAPI, *githubWorkflow, *githubToken); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("Cannot set up GitHub Actions")
+		}
+	case "circleci":
+		if *circleciToken == "" {
+			*circleciToken = os.Getenv("CIRCLECI_TOKEN")
+		}
+		if err := buildkiteHandler.SetCircleCI(*circleciAPI, *circleciVCS, *circleciWorkflow, *circleciToken); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("Cannot set up CircleCI")
+		}
+	case "azure":
+		if *azureDevOpsToken == "" {
+			*azureDevOpsToken = os.Getenv("AZURE_DEVOPS_TOKEN")
+		}
+		if err := buildkiteHandler.SetAzureDevOps(*azureDevOpsURL, *azureDevOpsPipeline, *azureDevOpsToken); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("Cannot set up Azure DevOps")
+		}
+	default:
+		log.WithFields(log.Fields{
+			"provider": *provider,
+		}).Fatal("Unknown provider")
 	}
-	if *artifactFilter != "" {
-		err := buildkiteHandler.SetArtifactFilter(*artifactFilter)
+	for _, filter := range artifactFilters {
+		err := buildkiteHandler.AddArtifactFilter(filter)
 		if err != nil {
 			log.WithFields(log.Fields{
-				"artifactFilter": *artifactFilter,
+				"artifactFilter": filter,
 			}).Fatal("Cannot parse artifactFilter")
 			os.Exit(2)
 		}
 	}
 
-	downloads, err := buildkiteHandler.Start()
-	if err != nil {
-		log.Warn(err)
+	for _, glob := range artifactGlobs {
+		if err := buildkiteHandler.AddArtifactGlob(glob); err != nil {
+			log.WithFields(log.Fields{
+				"artifactGlob": glob,
+				"error":        err,
+			}).Fatal("Cannot parse artifactGlob")
+		}
+	}
+	for _, mimeType := range mimeTypes {
+		if err := buildkiteHandler.AddMimeType(mimeType); err != nil {
+			log.WithFields(log.Fields{
+				"mimeType": mimeType,
+				"error":    err,
+			}).Fatal("Cannot parse mimeType")
+		}
+	}
+	if err := buildkiteHandler.SetJobFilter(*jobFilter); err != nil {
+		log.WithFields(log.Fields{
+			"jobFilter": *jobFilter,
+			"error":     err,
+		}).Fatal("Cannot parse jobFilter")
+	}
+	if err := buildkiteHandler.SetMessageFilter(*messageFilter); err != nil {
+		log.WithFields(log.Fields{
+			"messageFilter": *messageFilter,
+			"error":         err,
+		}).Fatal("Cannot parse messageFilter")
+	}
+	if err := buildkiteHandler.SetAuthorFilter(*authorFilter); err != nil {
+		log.WithFields(log.Fields{
+			"authorFilter": *authorFilter,
+			"error":        err,
+		}).Fatal("Cannot parse authorFilter")
+	}
+	buildkiteHandler.SetOnlyPassedJobs(*onlyPassedJobs)
+	for _, stepKey := range stepKeys {
+		buildkiteHandler.AddStepKey(stepKey)
+	}
+	if err := buildkiteHandler.SetArtifactExclude(*artifactExclude); err != nil {
+		log.WithFields(log.Fields{
+			"artifactExclude": *artifactExclude,
+			"error":           err,
+		}).Fatal("Cannot parse artifactExclude")
+	}
+	if err := buildkiteHandler.SetArtifactOrder(*sortArtifacts); err != nil {
+		log.WithFields(log.Fields{
+			"sortArtifacts": *sortArtifacts,
+			"error":         err,
+		}).Fatal("Cannot set artifact order")
+	}
+	buildkiteHandler.SetMaxArtifacts(*maxArtifacts)
+	if err := buildkiteHandler.SetPathFilter(*pathFilter); err != nil {
+		log.WithFields(log.Fields{
+			"pathFilter": *pathFilter,
+			"error":      err,
+		}).Fatal("Cannot parse pathFilter")
 	}
 
-	if downloads > 0 && *runFdroidUpdate {
-		fh := fdroidHandler.NewFdroidHandler()
+	var fh *fdroidHandler.FdroidHandler
+	if *runFdroidUpdate {
+		fh = fdroidHandler.NewFdroidHandler()
+		fh.SetTimeout(*fdroidTimeout)
+		fh.SetDryRun(*fdroidDryRun)
+		for _, variable := range fdroidEnv {
+			command := ""
+			if colon := strings.Index(variable, ":"); colon > 0 && colon < strings.Index(variable, "=") {
+				command, variable = variable[:colon], variable[colon+1:]
+			}
+			if err := fh.AddEnv(command, variable); err != nil {
+				log.WithFields(log.Fields{
+					"fdroidEnv": variable,
+					"error":     err,
+				}).Fatal("Cannot parse fdroidEnv")
+			}
+		}
+		if *fdroidMetadataTemplate != "" {
+			if err := fh.SetMetadataTemplate(*fdroidMetadataTemplate); err != nil {
+				log.WithFields(log.Fields{
+					"fdroidMetadataTemplate": *fdroidMetadataTemplate,
+					"error":                  err,
+				}).Fatal("Cannot use metadata template")
+			}
+		}
+		if err := fh.SetKeystorePasswords(*fdroidKeystorePass, *fdroidKeyPass); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("Cannot set up fdroid keystore passwords")
+		}
 		if len(*fdroidVirtualEnv) > 0 {
-			err = fh.SetFdroidVENV(*fdroidVirtualEnv)
-			if err != nil {
+			if err := fh.SetFdroidVENV(*fdroidVirtualEnv); err != nil {
 				log.Error(err)
 			}
 		}
-		fh.RunFdroidCommand("update")
-		// TODO: Check if deploy is possible/configured
-		fh.RunFdroidCommand("deploy")
+		if *fdroidRepoDir != "" {
+			if err := fh.SetRepoDir(*fdroidRepoDir); err != nil {
+				log.WithFields(log.Fields{
+					"fdroidRepoDir": *fdroidRepoDir,
+					"error":         err,
+				}).Fatal("Cannot use fdroid repository")
+			}
+		}
+		if *fdroidContainer != "" {
+			if err := fh.SetContainer(*fdroidContainer, *fdroidImage); err != nil {
+				log.WithFields(log.Fields{
+					"fdroidContainer": *fdroidContainer,
+					"error":           err,
+				}).Fatal("Cannot run fdroid in a container")
+			}
+		}
+		if _, err := fh.CheckFdroid(); err != nil {
+			if !*fdroidDryRun {
+				log.WithFields(log.Fields{
+					"error": err,
+				}).Fatal("Pre-flight check of fdroid failed")
+			}
+			log.Warn(err)
+		}
 	}
 
-	// use exit code to respond if there are artifacts downloaded
-	if downloads > 0 {
-		os.Exit(0)
-	} else {
-		os.Exit(1)
+	var publishers []publisher.Publisher
+	for _, command := range publisherCommands {
+		pub, err := publisher.NewExecPublisher(command)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"publisher": command,
+				"error":     err,
+			}).Fatal("Cannot set up publisher")
+		}
+		publishers = append(publishers, pub)
+	}
+
+	if *azureContainer != "" {
+		if *azureConnectionString == "" {
+			*azureConnectionString = os.Getenv("AZURE_STORAGE_CONNECTION_STRING")
+		}
+		var pub *publisher.AzureBlobPublisher
+		var err error
+		if *azureConnectionString != "" {
+			pub, err = publisher.NewAzureBlobPublisher(*azureConnectionString, *azureContainer, *azurePrefix)
+		} else {
+			if *azureClientID == "" {
+				*azureClientID = os.Getenv("AZURE_CLIENT_ID")
+			}
+			pub, err = publisher.NewAzureBlobPublisherWithManagedIdentity(*azureAccount, *azureClientID, *azureContainer, *azurePrefix)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"azureContainer": *azureContainer,
+				"error":          err,
+			}).Fatal("Cannot set up Azure Blob Storage")
+		}
+		publishers = append(publishers, pub)
+	}
+
+	if *sftpTarget != "" {
+		pub, err := publisher.NewSFTPPublisher(*sftpTarget, *sftpKey, *sftpKnownHosts, *sftpPath)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"sftpTarget": *sftpTarget,
+				"error":      err,
+			}).Fatal("Cannot set up SFTP upload")
+		}
+		publishers = append(publishers, pub)
+	}
+
+	if *rsyncTarget != "" {
+		pub, err := publisher.NewRsyncPublisher(*rsyncSource, *rsyncTarget, *rsyncArgs)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"rsyncSource": *rsyncSource,
+				"rsyncTarget": *rsyncTarget,
+				"error":       err,
+			}).Fatal("Cannot set up rsync")
+		}
+		publishers = append(publishers, pub)
+	}
+
+	if *githubRepo != "" {
+		if *githubToken == "" {
+			*githubToken = os.Getenv("GITHUB_TOKEN")
+		}
+		pub, err := publisher.NewGitHubReleasePublisher(*githubAPI, *githubRepo, *githubToken, *githubTag, githubAssets, *githubPrerelease)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"githubRepo": *githubRepo,
+				"error":      err,
+			}).Fatal("Cannot set up GitHub releases")
+		}
+		publishers = append(publishers, pub)
+	}
+
+	if *gitlabProject != "" {
+		if *gitlabToken == "" {
+			*gitlabToken = os.Getenv("GITLAB_TOKEN")
+		}
+		pub, err := publisher.NewGitLabPackagePublisher(*gitlabURL, *gitlabProject, *gitlabToken, *gitlabPackage, *gitlabVersion)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"gitlabProject": *gitlabProject,
+				"error":         err,
+			}).Fatal("Cannot set up GitLab package registry")
+		}
+		publishers = append(publishers, pub)
+	}
+
+	if *rawRepository != "" {
+		if *rawRepositoryPassword == "" {
+			*rawRepositoryPassword = os.Getenv("RAW_REPOSITORY_PASSWORD")
+		}
+		if *rawRepositoryToken == "" {
+			*rawRepositoryToken = os.Getenv("RAW_REPOSITORY_TOKEN")
+		}
+		pub, err := publisher.NewRawRepositoryPublisher(*rawRepository, *rawRepositoryPath, *rawRepositoryUser, *rawRepositoryPassword, *rawRepositoryToken)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"rawRepository": *rawRepository,
+				"error":         err,
+			}).Fatal("Cannot set up repository upload")
+		}
+		publishers = append(publishers, pub)
+	}
+
+	if *ociReference != "" {
+		if *ociPassword == "" {
+			*ociPassword = os.Getenv("OCI_PASSWORD")
+		}
+		pub, err := publisher.NewOCIPublisher(*ociReference, *ociUser, *ociPassword, *ociInsecure)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"ociReference": *ociReference,
+				"error":        err,
+			}).Fatal("Cannot set up OCI registry")
+		}
+		publishers = append(publishers, pub)
+	}
+
+	if *playServiceAccount != "" {
+		pub, err := publisher.NewGooglePlayPublisher(*playServiceAccount, *playPackage, *playTrack)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"playServiceAccount": *playServiceAccount,
+				"error":              err,
+			}).Fatal("Cannot set up Google Play")
+		}
+		publishers = append(publishers, pub)
 	}
+
+	notifiers := newNotifiers(notifyTargets{
+		MatrixRoom:   *matrixRoom,
+		Webhook:      *webhook,
+		SMTPTo:       *smtpTo,
+		NtfyTopic:    *ntfyTopic,
+		GotifyServer: *gotifyServer,
+		TelegramChat: *telegramChat,
+	})
+
+	if flag.Arg(0) == "backfill" {
+		os.Exit(runBackfill(buildkiteHandler, flag.Args()[1:]))
+	}
+
+	if *pipelinesFile != "" {
+		config, err := readPipelinesConfig(*pipelinesFile)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"pipelines": *pipelinesFile,
+				"error":     err,
+			}).Fatal("Cannot read pipelines")
+		}
+		if *buildID != 0 {
+			log.Fatal("buildId cannot be combined with pipelines")
+		}
+		runs := make([]pipelineRun, len(config.Pipelines))
+		handlers := make([]*downloader.BuildkiteHandler, len(config.Pipelines))
+		for i, entry := range config.Pipelines {
+			runs[i], err = newPipelineRun(buildkiteHandler, entry, fh, notifiers)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"org":      entry.Org,
+					"pipeline": entry.Pipeline,
+					"error":    err,
+				}).Fatal("Cannot set up pipeline")
+			}
+			handlers[i] = runs[i].handler
+		}
+		if err := downloader.CheckSharedRetention(handlers); err != nil {
+			log.WithFields(log.Fields{
+				"keepBuilds": *keepBuilds,
+				"keepDays":   *keepDays,
+				"error":      err,
+			}).Fatal("Cannot set up retention")
+		}
+		if *watchInterval > 0 {
+			for {
+				runPipelines(runs, config.Concurrency, publishers)
+				time.Sleep(*watchInterval)
+			}
+		}
+		os.Exit(runPipelines(runs, config.Concurrency, publishers))
+	}
+
+	if *watchInterval > 0 {
+		for {
+			runDownload(buildkiteHandler, fh, publishers, notifiers)
+			time.Sleep(*watchInterval)
+		}
+	}
+
+	downloads, err := runDownload(buildkiteHandler, fh, publishers, notifiers)
+	os.Exit(exitCode(downloads, err))
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	downloader "github.com/krombel/buildkite-artifact-downloader/downloader"
+	fdroidHandler "github.com/krombel/buildkite-artifact-downloader/fdroid-handler"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		downloads int
+		err       error
+		code      int
+	}{
+		{2, nil, 0},
+		{0, nil, 1},
+		{0, errors.New("Could not get data"), 1},
+		{0, downloader.ErrNothingNew, exitNothingNew},
+		{2, fdroidFailure{errors.New("fdroid update failed")}, exitFdroidFailed},
+		{2, fdroidFailure{&fdroidHandler.CommandError{Failure: fdroidHandler.FailureBadSignature}}, exitFdroidBadSignature},
+		{2, fdroidFailure{&fdroidHandler.CommandError{Failure: fdroidHandler.FailureDuplicateVersionCode}}, exitFdroidDuplicateVersionCode},
+		{2, fdroidFailure{&fdroidHandler.CommandError{Failure: fdroidHandler.FailureMissingKeystore}}, exitFdroidMissingKeystore},
+		{2, fdroidFailure{&fdroidHandler.CommandError{ExitCode: 1}}, exitFdroidFailed},
+	}
+	for _, test := range tests {
+		if code := exitCode(test.downloads, test.err); code != test.code {
+			t.Errorf("%d downloads with %v: got %d, want %d", test.downloads, test.err, code, test.code)
+		}
+	}
+}
+
+func TestCombineExitCodes(t *testing.T) {
+	tests := []struct {
+		codes []int
+		code  int
+	}{
+		{nil, exitNothingNew},
+		{[]int{exitNothingNew, exitNothingNew}, exitNothingNew},
+		{[]int{exitNothingNew, 0}, 0},
+		{[]int{0, exitNothingNew}, 0},
+		{[]int{0, 1, exitNothingNew}, 1},
+		{[]int{exitNothingNew, 1, 0}, 1},
+		{[]int{1, exitFdroidFailed, 0}, exitFdroidFailed},
+		{[]int{exitFdroidFailed, 1}, exitFdroidFailed},
+		// the first fdroid failure wins
+		{[]int{0, exitFdroidMissingKeystore, exitFdroidBadSignature}, exitFdroidMissingKeystore},
+		{[]int{exitFdroidBadSignature, exitFdroidFailed, 1}, exitFdroidBadSignature},
+	}
+	for _, test := range tests {
+		if code := combineExitCodes(test.codes); code != test.code {
+			t.Errorf("%v: got %d, want %d", test.codes, code, test.code)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// GotifyNotifier sends messages to a Gotify server
+type GotifyNotifier struct {
+	server    string
+	appToken  string
+	netClient *http.Client
+}
+
+// NewGotifyNotifier constructs a notifier for the Gotify server (e.g.
+// https://gotify.example.org) with the token of an application
+func NewGotifyNotifier(server string, appToken string) (*GotifyNotifier, error) {
+	if _, err := url.ParseRequestURI(server); err != nil {
+		return nil, fmt.Errorf("Cannot parse Gotify URL (%v)", err)
+	}
+	if appToken == "" {
+		return nil, fmt.Errorf("Gotify application token is missing")
+	}
+	return &GotifyNotifier{
+		server:    strings.TrimSuffix(server, "/"),
+		appToken:  appToken,
+		netClient: newClient(),
+	}, nil
+}
+
+// Name returns "gotify"
+func (gn *GotifyNotifier) Name() string {
+	return "gotify"
+}
+
+// gotifyMessage is the body of POST /message
+type gotifyMessage struct {
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority"`
+}
+
+// Notify sends the event. Failures get a high priority
+func (gn *GotifyNotifier) Notify(event Event) error {
+	message := gotifyMessage{
+		Title:    event.Title(),
+		Message:  event.Text(),
+		Priority: 5,
+	}
+	if event.Failed() {
+		message.Priority = 8
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, gn.server+"/message", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", gn.appToken)
+	resp, err := gn.netClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Cannot send Gotify message (%v)", err)
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, "Gotify")
+}
@@ -0,0 +1,108 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MatrixNotifier posts to a Matrix room via the client-server API
+type MatrixNotifier struct {
+	homeserver  string
+	roomID      string
+	accessToken string
+	netClient   *http.Client
+}
+
+// NewMatrixNotifier constructs a notifier for the room (ID like
+// !abc:matrix.org) on the homeserver (e.g. https://matrix.org). The access
+// token belongs to the account which posts
+func NewMatrixNotifier(homeserver string, roomID string, accessToken string) (*MatrixNotifier, error) {
+	if _, err := url.ParseRequestURI(homeserver); err != nil {
+		return nil, fmt.Errorf("Cannot parse homeserver URL (%v)", err)
+	}
+	if !strings.HasPrefix(roomID, "!") {
+		return nil, fmt.Errorf("Room has to be given by its ID (!<id>:<server>)")
+	}
+	if accessToken == "" {
+		return nil, fmt.Errorf("Matrix access token is missing")
+	}
+	return &MatrixNotifier{
+		homeserver:  strings.TrimSuffix(homeserver, "/"),
+		roomID:      roomID,
+		accessToken: accessToken,
+		netClient:   newClient(),
+	}, nil
+}
+
+// Name returns "matrix"
+func (mn *MatrixNotifier) Name() string {
+	return "matrix"
+}
+
+// matrixMessage is the content of a m.room.message event
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+// formatHTML renders the event for Matrix clients which support HTML
+func formatHTML(event Event) string {
+	var body strings.Builder
+	body.WriteString(event.titleHTML())
+	if len(event.Downloaded) == 0 && len(event.RunReport.Failed) == 0 {
+		return body.String()
+	}
+	body.WriteString("<ul>")
+	for _, result := range event.Downloaded {
+		name, link := event.ArtifactLink(result)
+		if link != "" {
+			body.WriteString(`<li><a href="` + html.EscapeString(link) + `">` + html.EscapeString(name) + `</a></li>`)
+		} else {
+			body.WriteString("<li>" + html.EscapeString(name) + "</li>")
+		}
+	}
+	for _, failed := range event.RunReport.Failed {
+		body.WriteString("<li>" + html.EscapeString(failed.Filename+" failed: "+failed.Reason) + "</li>")
+	}
+	body.WriteString("</ul>")
+	return body.String()
+}
+
+// Notify sends the event as m.notice to the room
+func (mn *MatrixNotifier) Notify(event Event) error {
+	message, err := json.Marshal(matrixMessage{
+		MsgType:       "m.notice",
+		Body:          event.Text(),
+		Format:        "org.matrix.custom.html",
+		FormattedBody: formatHTML(event),
+	})
+	if err != nil {
+		return err
+	}
+	txnID := "bkad-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	req, err := http.NewRequest(
+		http.MethodPut,
+		mn.homeserver+"/_matrix/client/v3/rooms/"+url.PathEscape(mn.roomID)+"/send/m.room.message/"+txnID,
+		bytes.NewReader(message),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+mn.accessToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := mn.netClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Cannot send Matrix message (%v)", err)
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, "Matrix homeserver")
+}
@@ -0,0 +1,135 @@
+package notifier
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	downloader "github.com/krombel/buildkite-artifact-downloader/downloader"
+)
+
+// timeout limits the requests of the notifiers
+const timeout = 10 * time.Second
+
+// Notifier announces the outcome of a run
+type Notifier interface {
+	// Name identifies the notifier in logs
+	Name() string
+	// Notify sends the notification for the run
+	Notify(event Event) error
+}
+
+// Event describes a finished run
+type Event struct {
+	downloader.RunReport
+	// Error is the error of the run (download or fdroid)
+	Error string `json:"error,omitempty"`
+	// LinkBase is prepended to the file names of the downloads to link them
+	// (e.g. the URL the repo is served at). Downloads are not linked if empty
+	LinkBase string `json:"-"`
+}
+
+// Failed reports whether the run or any artifact failed
+func (e Event) Failed() bool {
+	return e.Error != "" || len(e.RunReport.Failed) > 0
+}
+
+// BuildURL links the build in the web UI of its provider. It is empty if
+// the provider did not report it
+func (e Event) BuildURL() string {
+	return e.WebURL
+}
+
+// titleHTML renders the title as link to the build if it is known
+func (e Event) titleHTML() string {
+	if e.BuildURL() == "" {
+		return html.EscapeString(e.Title())
+	}
+	return `<a href="` + html.EscapeString(e.BuildURL()) + `">` + html.EscapeString(e.Title()) + `</a>`
+}
+
+// ShortCommit returns the first 8 characters of the commit
+func (e Event) ShortCommit() string {
+	if len(e.CommitID) > 8 {
+		return e.CommitID[:8]
+	}
+	return e.CommitID
+}
+
+// Title summarizes the run in one line
+func (e Event) Title() string {
+	title := fmt.Sprintf("%s build %d", e.Pipeline, e.BuildID)
+	if commit := e.ShortCommit(); commit != "" {
+		title += " (" + commit + ")"
+	}
+	if e.Error != "" {
+		return title + " failed: " + e.Error
+	}
+	title += fmt.Sprintf(": %d artifacts downloaded", len(e.Downloaded))
+	if len(e.RunReport.Failed) > 0 {
+		title += fmt.Sprintf(", %d failed", len(e.RunReport.Failed))
+	}
+	return title
+}
+
+// ArtifactLink returns the name of the download and its link (empty without
+// LinkBase)
+func (e Event) ArtifactLink(result downloader.DownloadResult) (string, string) {
+	name := filepath.Base(result.Destination)
+	if result.APK != nil && result.APK.VersionName != "" {
+		name += " (" + result.APK.VersionName + ")"
+	}
+	if e.LinkBase == "" {
+		return name, ""
+	}
+	return name, strings.TrimSuffix(e.LinkBase, "/") + "/" + filepath.Base(result.Destination)
+}
+
+// Text describes the run in plain text: the title, the build link and one
+// line per downloaded, failed or unexpectedly grown artifact followed by the
+// changelog
+func (e Event) Text() string {
+	lines := []string{e.Title()}
+	if e.BuildURL() != "" {
+		lines = append(lines, e.BuildURL())
+	}
+	for _, result := range e.Downloaded {
+		name, link := e.ArtifactLink(result)
+		if link != "" {
+			name += " " + link
+		}
+		lines = append(lines, "- "+name)
+	}
+	for _, failed := range e.RunReport.Failed {
+		lines = append(lines, "- "+failed.Filename+" failed: "+failed.Reason)
+	}
+	for _, change := range e.SizeChanges {
+		if change.Flagged {
+			lines = append(lines, "- "+change.String()+" grew unexpectedly")
+		}
+	}
+	if e.Changelog != "" {
+		lines = append(lines, "", "Changes:", strings.TrimRight(e.Changelog, "\n"))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// newClient returns the HTTP client of the notifiers
+func newClient() *http.Client {
+	return &http.Client{Timeout: timeout}
+}
+
+// checkResponse returns an error with the start of the body if the service
+// did not respond with a 2xx status
+func checkResponse(resp *http.Response, service string) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s responded with %s (%s)", service, resp.Status, strings.TrimSpace(string(body)))
+}
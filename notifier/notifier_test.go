@@ -0,0 +1,39 @@
+package notifier
+
+import (
+	"strings"
+	"testing"
+
+	downloader "github.com/krombel/buildkite-artifact-downloader/downloader"
+)
+
+func TestBuildLink(t *testing.T) {
+	downloads := []downloader.DownloadResult{{Destination: "/srv/repo/app.apk"}}
+	tests := []struct {
+		webURL string
+	}{
+		{"https://github.com/org/repo/actions/runs/7"},
+		{"https://app.circleci.com/pipelines/github/org/repo/42"},
+		{"https://buildkite.com/org/pipe/builds/12"},
+		{""},
+	}
+	for _, test := range tests {
+		event := Event{RunReport: downloader.RunReport{Org: "org", Pipeline: "repo", BuildID: 7, WebURL: test.webURL, Downloaded: downloads}}
+		outputs := map[string]string{
+			"text":     event.Text(),
+			"telegram": formatTelegram(event),
+			"matrix":   formatHTML(event),
+		}
+		for name, output := range outputs {
+			if test.webURL != "" && !strings.Contains(output, test.webURL) {
+				t.Errorf("%s does not link %s: %s", name, test.webURL, output)
+			}
+			if test.webURL == "" && strings.Contains(output, "href") {
+				t.Errorf("%s links a build without URL: %s", name, output)
+			}
+			if test.webURL != "https://buildkite.com/org/pipe/builds/12" && strings.Contains(output, "buildkite.com") {
+				t.Errorf("%s links Buildkite: %s", name, output)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package notifier
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// NtfyNotifier publishes to a ntfy topic (e.g. https://ntfy.sh/mytopic)
+type NtfyNotifier struct {
+	topicURL  string
+	token     string
+	netClient *http.Client
+}
+
+// NewNtfyNotifier constructs a notifier for the topic URL. The access token
+// is only needed for protected topics
+func NewNtfyNotifier(topicURL string, token string) (*NtfyNotifier, error) {
+	parsed, err := url.ParseRequestURI(topicURL)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse ntfy topic URL (%v)", err)
+	}
+	if strings.Trim(parsed.Path, "/") == "" {
+		return nil, fmt.Errorf("ntfy URL has no topic")
+	}
+	return &NtfyNotifier{
+		topicURL:  topicURL,
+		token:     token,
+		netClient: newClient(),
+	}, nil
+}
+
+// Name returns "ntfy"
+func (nn *NtfyNotifier) Name() string {
+	return "ntfy"
+}
+
+// Notify publishes the event. Failures get a high priority
+func (nn *NtfyNotifier) Notify(event Event) error {
+	req, err := http.NewRequest(http.MethodPost, nn.topicURL, strings.NewReader(event.Text()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", event.Title())
+	if event.BuildURL() != "" {
+		req.Header.Set("Click", event.BuildURL())
+	}
+	if event.Failed() {
+		req.Header.Set("Priority", "high")
+		req.Header.Set("Tags", "warning")
+	} else {
+		req.Header.Set("Tags", "package")
+	}
+	if nn.token != "" {
+		req.Header.Set("Authorization", "Bearer "+nn.token)
+	}
+	resp, err := nn.netClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Cannot publish to ntfy (%v)", err)
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, "ntfy")
+}
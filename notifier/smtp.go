@@ -0,0 +1,119 @@
+package notifier
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPNotifier emails a summary of runs which failed or rejected artifacts
+type SMTPNotifier struct {
+	server   string
+	from     string
+	to       []string
+	username string
+	password string
+}
+
+// NewSMTPNotifier constructs a notifier which sends via server (host:port).
+// Port 465 uses implicit TLS, other ports STARTTLS if the server offers it.
+// Without username no authentication is done
+func NewSMTPNotifier(server string, from string, to []string, username string, password string) (*SMTPNotifier, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		return nil, fmt.Errorf("SMTP server has to be host:port (%v)", err)
+	}
+	var recipients []string
+	for _, recipient := range to {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+	if from == "" || len(recipients) == 0 {
+		return nil, fmt.Errorf("Sender and recipients are required")
+	}
+	return &SMTPNotifier{
+		server:   server,
+		from:     from,
+		to:       recipients,
+		username: username,
+		password: password,
+	}, nil
+}
+
+// Name returns "smtp"
+func (sn *SMTPNotifier) Name() string {
+	return "smtp"
+}
+
+// message renders the mail of the event
+func (sn *SMTPNotifier) message(event Event) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", sn.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(sn.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", event.Title()))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.Replace(event.Text(), "\n", "\r\n", -1))
+	msg.WriteString("\r\n")
+	return msg.Bytes()
+}
+
+// Notify sends the mail if the run failed. Successful runs are not reported
+func (sn *SMTPNotifier) Notify(event Event) error {
+	if !event.Failed() {
+		return nil
+	}
+	host, port, _ := net.SplitHostPort(sn.server)
+	var auth smtp.Auth
+	if sn.username != "" {
+		auth = smtp.PlainAuth("", sn.username, sn.password, host)
+	}
+	if port != "465" {
+		if err := smtp.SendMail(sn.server, auth, sn.from, sn.to, sn.message(event)); err != nil {
+			return fmt.Errorf("Cannot send mail (%v)", err)
+		}
+		return nil
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", sn.server, &tls.Config{ServerName: host})
+	if err != nil {
+		return fmt.Errorf("Cannot connect to SMTP server (%v)", err)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("Cannot connect to SMTP server (%v)", err)
+	}
+	defer client.Close()
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("Cannot authenticate at SMTP server (%v)", err)
+		}
+	}
+	if err := client.Mail(sn.from); err != nil {
+		return fmt.Errorf("Cannot send mail (%v)", err)
+	}
+	for _, recipient := range sn.to {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("Cannot send mail to %s (%v)", recipient, err)
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("Cannot send mail (%v)", err)
+	}
+	if _, err := writer.Write(sn.message(event)); err != nil {
+		return fmt.Errorf("Cannot send mail (%v)", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("Cannot send mail (%v)", err)
+	}
+	return client.Quit()
+}
@@ -0,0 +1,91 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+)
+
+// telegramAPIURL is the base of the Bot API
+const telegramAPIURL = "https://api.telegram.org"
+
+// TelegramNotifier announces new builds in a Telegram chat via a bot
+type TelegramNotifier struct {
+	apiURL    string
+	botToken  string
+	chatID    string
+	netClient *http.Client
+}
+
+// NewTelegramNotifier constructs a notifier which posts as the bot with
+// botToken into chatID (a numeric ID or @channelname)
+func NewTelegramNotifier(botToken string, chatID string) (*TelegramNotifier, error) {
+	if !strings.Contains(botToken, ":") {
+		return nil, fmt.Errorf("Telegram bot token has to be <id>:<secret>")
+	}
+	if chatID == "" {
+		return nil, fmt.Errorf("Telegram chat ID is missing")
+	}
+	return &TelegramNotifier{
+		apiURL:    telegramAPIURL,
+		botToken:  botToken,
+		chatID:    chatID,
+		netClient: newClient(),
+	}, nil
+}
+
+// Name returns "telegram"
+func (tn *TelegramNotifier) Name() string {
+	return "telegram"
+}
+
+// telegramMessage is the body of sendMessage
+type telegramMessage struct {
+	ChatID                string `json:"chat_id"`
+	Text                  string `json:"text"`
+	ParseMode             string `json:"parse_mode"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview"`
+}
+
+// formatTelegram lists the downloads with the version of APKs
+func formatTelegram(event Event) string {
+	lines := []string{event.titleHTML()}
+	for _, result := range event.Downloaded {
+		name, link := event.ArtifactLink(result)
+		if result.APK != nil {
+			name = fmt.Sprintf("%s %s (%d)", result.APK.PackageName, result.APK.VersionName, result.APK.VersionCode)
+		}
+		if link != "" {
+			lines = append(lines, `• <a href="`+html.EscapeString(link)+`">`+html.EscapeString(name)+`</a>`)
+		} else {
+			lines = append(lines, "• "+html.EscapeString(name))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Notify announces the downloads. Runs without downloads are not announced
+func (tn *TelegramNotifier) Notify(event Event) error {
+	if len(event.Downloaded) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(telegramMessage{
+		ChatID:                tn.chatID,
+		Text:                  formatTelegram(event),
+		ParseMode:             "HTML",
+		DisableWebPagePreview: true,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := tn.netClient.Post(tn.apiURL+"/bot"+tn.botToken+"/sendMessage", "application/json", bytes.NewReader(body))
+	if err != nil {
+		// the URL contains the token
+		return fmt.Errorf("Cannot send Telegram message")
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, "Telegram")
+}
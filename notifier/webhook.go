@@ -0,0 +1,74 @@
+package notifier
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of the body ("sha256=<hex>") if a
+// secret is configured
+const SignatureHeader = "X-Signature-256"
+
+// WebhookNotifier posts the event as JSON to a URL
+type WebhookNotifier struct {
+	url       string
+	secret    string
+	netClient *http.Client
+}
+
+// NewWebhookNotifier constructs a notifier which posts to webhookURL. The
+// body gets signed with secret unless it is empty
+func NewWebhookNotifier(webhookURL string, secret string) (*WebhookNotifier, error) {
+	parsed, err := url.ParseRequestURI(webhookURL)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse webhook URL (%v)", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("Webhook URL has to use http or https")
+	}
+	return &WebhookNotifier{
+		url:       webhookURL,
+		secret:    secret,
+		netClient: newClient(),
+	}, nil
+}
+
+// Name returns "webhook"
+func (wn *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+// Sign returns the value of SignatureHeader for body
+func Sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify posts the event
+func (wn *WebhookNotifier) Notify(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, wn.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if wn.secret != "" {
+		req.Header.Set(SignatureHeader, Sign(body, wn.secret))
+	}
+	resp, err := wn.netClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Cannot post webhook (%v)", err)
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, "Webhook")
+}
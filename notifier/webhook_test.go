@@ -0,0 +1,84 @@
+package notifier
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	downloader "github.com/krombel/buildkite-artifact-downloader/downloader"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	tests := []struct {
+		secret string
+	}{
+		{"s3cret"},
+		{"another secret"},
+		// unsigned
+		{""},
+	}
+	for _, test := range tests {
+		var body []byte
+		var header http.Header
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ = ioutil.ReadAll(r.Body)
+			header = r.Header
+		}))
+		wn, err := NewWebhookNotifier(srv.URL+"/hook", test.secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		event := Event{RunReport: downloader.RunReport{Org: "org", Pipeline: "pipe", BuildID: 42, CommitID: "abcdef1234"}}
+		err = wn.Notify(event)
+		srv.Close()
+		if err != nil {
+			t.Fatalf("secret %q: %v", test.secret, err)
+		}
+
+		var received Event
+		if err := json.Unmarshal(body, &received); err != nil || received.BuildID != 42 {
+			t.Errorf("secret %q: got body %s (%v)", test.secret, body, err)
+		}
+		signature := header.Get(SignatureHeader)
+		if test.secret == "" {
+			if signature != "" {
+				t.Errorf("unsigned webhook got signature %s", signature)
+			}
+			continue
+		}
+		// verify the signature the way a receiver does
+		mac := hmac.New(sha256.New, []byte(test.secret))
+		mac.Write(body)
+		want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if signature != want {
+			t.Errorf("secret %q: got signature %s, want %s", test.secret, signature, want)
+		}
+		if !strings.HasPrefix(signature, "sha256=") || len(signature) != len("sha256=")+64 {
+			t.Errorf("secret %q: signature %s is not sha256=<hex>", test.secret, signature)
+		}
+		if Sign(body, test.secret+"x") == signature {
+			t.Errorf("secret %q: signature does not depend on the secret", test.secret)
+		}
+	}
+}
+
+func TestWebhookReportsFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	wn, err := NewWebhookNotifier(srv.URL, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wn.Notify(Event{}); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Errorf("got %v, want the response of the receiver", err)
+	}
+}
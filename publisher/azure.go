@@ -0,0 +1,278 @@
+package publisher
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	downloader "github.com/krombel/buildkite-artifact-downloader/downloader"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// azureAPIVersion is sent as x-ms-version. Bearer tokens need at least
+	// 2017-11-09
+	azureAPIVersion = "2019-12-12"
+	// azureIMDSURL is the token endpoint of the instance metadata service
+	azureIMDSURL = "http://169.254.169.254/metadata/identity/oauth2/token"
+	// azureStorageResource is the audience of tokens for blob storage
+	azureStorageResource = "https://storage.azure.com/"
+)
+
+// AzureBlobPublisher uploads the downloads as block blobs into a container
+// of an Azure storage account
+type AzureBlobPublisher struct {
+	// containerURL is the URL of the container without a trailing slash
+	containerURL string
+	prefix       string
+	account      string
+	// accountKey and sas are set with a connection string
+	accountKey []byte
+	sas        string
+	// clientID selects a user-assigned managed identity
+	clientID    string
+	imdsURL     string
+	token       string
+	tokenExpiry time.Time
+	netClient   *http.Client
+}
+
+// NewAzureBlobPublisher constructs a publisher for container which
+// authenticates with the account key or shared access signature of
+// connectionString (as shown in the Azure portal). The blob names are the
+// file names of the downloads prefixed with prefix
+func NewAzureBlobPublisher(connectionString string, container string, prefix string) (*AzureBlobPublisher, error) {
+	settings := map[string]string{}
+	for _, part := range strings.Split(connectionString, ";") {
+		if kv := strings.SplitN(strings.TrimSpace(part), "=", 2); len(kv) == 2 {
+			settings[kv[0]] = kv[1]
+		}
+	}
+	ap := &AzureBlobPublisher{
+		prefix:    prefix,
+		account:   settings["AccountName"],
+		sas:       strings.TrimPrefix(settings["SharedAccessSignature"], "?"),
+		netClient: newClient(),
+	}
+	if key := settings["AccountKey"]; key != "" {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot decode AccountKey of connection string (%v)", err)
+		}
+		ap.accountKey = decoded
+	}
+	if ap.accountKey == nil && ap.sas == "" {
+		return nil, fmt.Errorf("Connection string contains neither AccountKey nor SharedAccessSignature")
+	}
+	if ap.accountKey != nil && ap.account == "" {
+		return nil, fmt.Errorf("Connection string does not contain AccountName")
+	}
+	endpoint := settings["BlobEndpoint"]
+	if endpoint == "" {
+		if ap.account == "" {
+			return nil, fmt.Errorf("Connection string contains neither AccountName nor BlobEndpoint")
+		}
+		protocol := settings["DefaultEndpointsProtocol"]
+		if protocol == "" {
+			protocol = "https"
+		}
+		suffix := settings["EndpointSuffix"]
+		if suffix == "" {
+			suffix = "core.windows.net"
+		}
+		endpoint = protocol + "://" + ap.account + ".blob." + suffix
+	}
+	if err := ap.setContainer(endpoint, container); err != nil {
+		return nil, err
+	}
+	return ap, nil
+}
+
+// NewAzureBlobPublisherWithManagedIdentity constructs a publisher for
+// container of account which authenticates with the managed identity of
+// the Azure VM or container it runs on. clientID selects a user-assigned
+// identity and may be empty for the system-assigned one
+func NewAzureBlobPublisherWithManagedIdentity(account string, clientID string, container string, prefix string) (*AzureBlobPublisher, error) {
+	if account == "" {
+		return nil, fmt.Errorf("Storage account is missing")
+	}
+	ap := &AzureBlobPublisher{
+		prefix:    prefix,
+		account:   account,
+		clientID:  clientID,
+		imdsURL:   azureIMDSURL,
+		netClient: newClient(),
+	}
+	if err := ap.setContainer("https://"+account+".blob.core.windows.net", container); err != nil {
+		return nil, err
+	}
+	return ap, nil
+}
+
+func (ap *AzureBlobPublisher) setContainer(endpoint string, container string) error {
+	if container == "" {
+		return fmt.Errorf("Container is missing")
+	}
+	if _, err := url.Parse(endpoint); err != nil {
+		return fmt.Errorf("Invalid blob endpoint %s (%v)", endpoint, err)
+	}
+	ap.containerURL = strings.TrimSuffix(endpoint, "/") + "/" + url.PathEscape(container)
+	return nil
+}
+
+// Name returns "azure-blob"
+func (ap *AzureBlobPublisher) Name() string {
+	return "azure-blob"
+}
+
+// azureToken is the response of the instance metadata service
+type azureToken struct {
+	AccessToken string `json:"access_token"`
+	// ExpiresOn is in seconds since epoch
+	ExpiresOn string `json:"expires_on"`
+}
+
+// managedIdentityToken returns a token for blob storage. It is cached until
+// shortly before it expires as the publisher is reused in watch mode
+func (ap *AzureBlobPublisher) managedIdentityToken() (string, error) {
+	if ap.token != "" && time.Now().Add(5*time.Minute).Before(ap.tokenExpiry) {
+		return ap.token, nil
+	}
+	query := url.Values{}
+	query.Set("api-version", "2018-02-01")
+	query.Set("resource", azureStorageResource)
+	if ap.clientID != "" {
+		query.Set("client_id", ap.clientID)
+	}
+	req, err := http.NewRequest(http.MethodGet, ap.imdsURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := ap.netClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Cannot reach the instance metadata service (%v)", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, "Instance metadata service"); err != nil {
+		return "", err
+	}
+	var token azureToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("Cannot decode managed identity token (%v)", err)
+	}
+	expiresOn, err := strconv.ParseInt(token.ExpiresOn, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("Invalid expiry of managed identity token (%v)", err)
+	}
+	ap.token = token.AccessToken
+	ap.tokenExpiry = time.Unix(expiresOn, 0)
+	return ap.token, nil
+}
+
+// signSharedKey sets the Authorization header of req as described in
+// https://docs.microsoft.com/rest/api/storageservices/authorize-with-shared-key
+func (ap *AzureBlobPublisher) signSharedKey(req *http.Request) {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+	var msHeaders []string
+	for name := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			msHeaders = append(msHeaders, name)
+		}
+	}
+	sort.Strings(msHeaders)
+	canonicalHeaders := ""
+	for _, name := range msHeaders {
+		canonicalHeaders += name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n"
+	}
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}, "\n") + "\n" + canonicalHeaders + "/" + ap.account + req.URL.EscapedPath()
+	mac := hmac.New(sha256.New, ap.accountKey)
+	mac.Write([]byte(stringToSign))
+	req.Header.Set("Authorization", "SharedKey "+ap.account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// upload puts the file at path as block blob name
+func (ap *AzureBlobPublisher) upload(path string, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	blobURL := ap.containerURL + "/" + (&url.URL{Path: name}).EscapedPath()
+	if ap.sas != "" && ap.accountKey == nil {
+		blobURL += "?" + ap.sas
+	}
+	req, err := http.NewRequest(http.MethodPut, blobURL, file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		req.Header.Set("x-ms-blob-content-type", contentType)
+	}
+	switch {
+	case ap.accountKey != nil:
+		ap.signSharedKey(req)
+	case ap.sas == "":
+		token, err := ap.managedIdentityToken()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := ap.netClient.Do(req)
+	if err != nil {
+		// the URL may contain the shared access signature
+		return fmt.Errorf("Cannot reach Azure Blob Storage")
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, "Azure Blob Storage")
+}
+
+// Publish uploads all downloads of the report
+func (ap *AzureBlobPublisher) Publish(report downloader.RunReport) error {
+	for _, result := range report.Downloaded {
+		name := ap.prefix + filepath.Base(result.Destination)
+		if err := ap.upload(result.Destination, name); err != nil {
+			return fmt.Errorf("Cannot upload %s (%v)", result.Destination, err)
+		}
+		log.WithFields(log.Fields{
+			"blob": ap.containerURL + "/" + name,
+		}).Info("Uploaded artifact to Azure Blob Storage")
+	}
+	return nil
+}
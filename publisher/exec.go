@@ -0,0 +1,61 @@
+package publisher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	downloader "github.com/krombel/buildkite-artifact-downloader/downloader"
+	log "github.com/sirupsen/logrus"
+)
+
+// ExecPublisher runs a plugin binary which gets the run report (see
+// downloader.RunReport) as JSON on stdin. A non-zero exit code marks the
+// publishing as failed. This way publishers can be added without changing
+// the downloader
+type ExecPublisher struct {
+	command []string
+}
+
+// NewExecPublisher constructs a publisher which runs command (the plugin
+// binary followed by its arguments)
+func NewExecPublisher(command string) (*ExecPublisher, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("Publisher command is empty")
+	}
+	if _, err := exec.LookPath(fields[0]); err != nil {
+		return nil, fmt.Errorf("Cannot find publisher (%v)", err)
+	}
+	return &ExecPublisher{
+		command: fields,
+	}, nil
+}
+
+// Name returns the file name of the plugin binary
+func (ep *ExecPublisher) Name() string {
+	return filepath.Base(ep.command[0])
+}
+
+// Publish runs the plugin with the report on stdin
+func (ep *ExecPublisher) Publish(report downloader.RunReport) error {
+	input, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("Cannot encode report (%v)", err)
+	}
+	cmd := exec.Command(ep.command[0], ep.command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		log.WithFields(log.Fields{
+			"publisher": ep.Name(),
+		}).Info(strings.TrimSpace(string(output)))
+	}
+	if err != nil {
+		return fmt.Errorf("Publisher %s failed (%v)", ep.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package publisher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	downloader "github.com/krombel/buildkite-artifact-downloader/downloader"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultGitHubAPI is the API of github.com
+	DefaultGitHubAPI = "https://api.github.com"
+	// DefaultReleaseTag is the tag of the release if no pattern is given
+	DefaultReleaseTag = "build-<buildID>"
+)
+
+// GitHubReleasePublisher uploads the downloads as assets of a release. The
+// release gets created for the commit of the build if it does not exist
+type GitHubReleasePublisher struct {
+	apiURL     string
+	repo       string
+	token      string
+	tagPattern string
+	assets     []string
+	prerelease bool
+	netClient  *http.Client
+}
+
+// NewGitHubReleasePublisher constructs a publisher for repo (owner/name)
+// on the GitHub API at apiURL. tagPattern is the tag of the release (see
+// expandPath; the APK placeholders refer to the first downloaded APK).
+// Only downloads whose file name matches one of the assets globs are
+// uploaded (all if empty)
+func NewGitHubReleasePublisher(apiURL string, repo string, token string, tagPattern string, assets []string, prerelease bool) (*GitHubReleasePublisher, error) {
+	if parts := strings.Split(repo, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("GitHub repository has to be <owner>/<name>")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("GitHub token is missing")
+	}
+	for _, glob := range assets {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("Invalid asset pattern %s (%v)", glob, err)
+		}
+	}
+	if apiURL == "" {
+		apiURL = DefaultGitHubAPI
+	}
+	if tagPattern == "" {
+		tagPattern = DefaultReleaseTag
+	}
+	return &GitHubReleasePublisher{
+		apiURL:     strings.TrimSuffix(apiURL, "/"),
+		repo:       repo,
+		token:      token,
+		tagPattern: tagPattern,
+		assets:     assets,
+		prerelease: prerelease,
+		netClient:  newClient(),
+	}, nil
+}
+
+// Name returns "github-release"
+func (gp *GitHubReleasePublisher) Name() string {
+	return "github-release"
+}
+
+// githubRelease is the part of a release of the GitHub API which is used
+type githubRelease struct {
+	ID              int64         `json:"id,omitempty"`
+	TagName         string        `json:"tag_name"`
+	TargetCommitish string        `json:"target_commitish,omitempty"`
+	Name            string        `json:"name,omitempty"`
+	Body            string        `json:"body,omitempty"`
+	Prerelease      bool          `json:"prerelease"`
+	UploadURL       string        `json:"upload_url,omitempty"`
+	Assets          []githubAsset `json:"assets,omitempty"`
+}
+
+type githubAsset struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// request sends a request to the GitHub API and decodes the response into
+// result if it is not nil
+func (gp *GitHubReleasePublisher) request(method string, requestURL string, body *bytes.Reader, contentType string, result interface{}) (*http.Response, error) {
+	var req *http.Request
+	var err error
+	if body != nil {
+		req, err = http.NewRequest(method, requestURL, body)
+	} else {
+		req, err = http.NewRequest(method, requestURL, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Authorization", "token "+gp.token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := gp.netClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodGet {
+		return resp, nil
+	}
+	if err := checkResponse(resp, "GitHub"); err != nil {
+		return resp, err
+	}
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return resp, fmt.Errorf("Cannot decode response of GitHub (%v)", err)
+		}
+	}
+	return resp, nil
+}
+
+// release returns the release of tag and creates it if it does not exist
+func (gp *GitHubReleasePublisher) release(tag string, report downloader.RunReport) (githubRelease, error) {
+	var release githubRelease
+	resp, err := gp.request(http.MethodGet, gp.apiURL+"/repos/"+gp.repo+"/releases/tags/"+url.PathEscape(tag), nil, "", &release)
+	if err != nil {
+		return release, fmt.Errorf("Cannot get release %s (%v)", tag, err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return release, nil
+	}
+	body, err := json.Marshal(githubRelease{
+		TagName:         tag,
+		TargetCommitish: report.CommitID,
+		Name:            tag,
+		Body:            fmt.Sprintf("Artifacts of build %d of %s", report.BuildID, report.Pipeline),
+		Prerelease:      gp.prerelease,
+	})
+	if err != nil {
+		return release, err
+	}
+	if _, err := gp.request(http.MethodPost, gp.apiURL+"/repos/"+gp.repo+"/releases", bytes.NewReader(body), "application/json", &release); err != nil {
+		return release, fmt.Errorf("Cannot create release %s (%v)", tag, err)
+	}
+	log.WithFields(log.Fields{
+		"repo": gp.repo,
+		"tag":  tag,
+	}).Info("Created GitHub release")
+	return release, nil
+}
+
+// matches reports whether the download is uploaded as asset
+func (gp *GitHubReleasePublisher) matches(name string) bool {
+	if len(gp.assets) == 0 {
+		return true
+	}
+	for _, glob := range gp.assets {
+		if matched, _ := filepath.Match(glob, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// upload uploads the file at path as asset of release. An existing asset
+// with the same name is replaced
+func (gp *GitHubReleasePublisher) upload(release githubRelease, path string) error {
+	name := filepath.Base(path)
+	for _, asset := range release.Assets {
+		if asset.Name == name {
+			if _, err := gp.request(http.MethodDelete, gp.apiURL+"/repos/"+gp.repo+"/releases/assets/"+fmt.Sprint(asset.ID), nil, "", nil); err != nil {
+				return fmt.Errorf("Cannot delete existing asset (%v)", err)
+			}
+		}
+	}
+	content, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	info, err := content.Stat()
+	if err != nil {
+		return err
+	}
+	// upload_url is a URI template like .../assets{?name,label}
+	uploadURL := release.UploadURL
+	if i := strings.Index(uploadURL, "{"); i >= 0 {
+		uploadURL = uploadURL[:i]
+	}
+	req, err := http.NewRequest(http.MethodPost, uploadURL+"?name="+url.QueryEscape(name), content)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "token "+gp.token)
+	resp, err := gp.netClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, "GitHub")
+}
+
+// Publish uploads the matching downloads to the release of the build
+func (gp *GitHubReleasePublisher) Publish(report downloader.RunReport) error {
+	var uploads []downloader.DownloadResult
+	for _, result := range report.Downloaded {
+		if gp.matches(filepath.Base(result.Destination)) {
+			uploads = append(uploads, result)
+		}
+	}
+	if len(uploads) == 0 {
+		return nil
+	}
+	tagSource := uploads[0]
+	for _, result := range uploads {
+		if result.APK != nil {
+			tagSource = result
+			break
+		}
+	}
+	tag := expandPath(gp.tagPattern, report, tagSource)
+	release, err := gp.release(tag, report)
+	if err != nil {
+		return err
+	}
+	for _, result := range uploads {
+		if err := gp.upload(release, result.Destination); err != nil {
+			return fmt.Errorf("Cannot upload %s to release %s (%v)", result.Destination, tag, err)
+		}
+	}
+	log.WithFields(log.Fields{
+		"repo":   gp.repo,
+		"tag":    tag,
+		"assets": len(uploads),
+	}).Info("Uploaded assets to GitHub release")
+	return nil
+}
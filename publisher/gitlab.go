@@ -0,0 +1,131 @@
+package publisher
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	downloader "github.com/krombel/buildkite-artifact-downloader/downloader"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultGitLabURL is the URL of gitlab.com
+	DefaultGitLabURL = "https://gitlab.com"
+	// DefaultPackageName is the name of the package if no pattern is given
+	DefaultPackageName = "<pipeline>"
+	// DefaultPackageVersion is the version of the package if no pattern is
+	// given
+	DefaultPackageVersion = "<buildID>"
+)
+
+// rePackageName and rePackageVersion match what GitLab accepts as name and
+// version of generic packages
+var (
+	rePackageName    = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	rePackageVersion = regexp.MustCompile(`^(\.?[\w+-]+\.?)+$`)
+)
+
+// GitLabPackagePublisher uploads the downloads into the generic package
+// registry of a GitLab project
+type GitLabPackagePublisher struct {
+	apiURL         string
+	project        string
+	token          string
+	namePattern    string
+	versionPattern string
+	netClient      *http.Client
+}
+
+// NewGitLabPackagePublisher constructs a publisher for project (its ID or
+// path like group/name) on the GitLab at serverURL. namePattern and
+// versionPattern name the package the downloads are uploaded to (see
+// expandPath)
+func NewGitLabPackagePublisher(serverURL string, project string, token string, namePattern string, versionPattern string) (*GitLabPackagePublisher, error) {
+	if project == "" {
+		return nil, fmt.Errorf("GitLab project is missing")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("GitLab token is missing")
+	}
+	if serverURL == "" {
+		serverURL = DefaultGitLabURL
+	}
+	if _, err := url.Parse(serverURL); err != nil {
+		return nil, fmt.Errorf("Invalid GitLab URL %s (%v)", serverURL, err)
+	}
+	if namePattern == "" {
+		namePattern = DefaultPackageName
+	}
+	if versionPattern == "" {
+		versionPattern = DefaultPackageVersion
+	}
+	return &GitLabPackagePublisher{
+		apiURL:         strings.TrimSuffix(serverURL, "/") + "/api/v4",
+		project:        project,
+		token:          token,
+		namePattern:    namePattern,
+		versionPattern: versionPattern,
+		netClient:      newClient(),
+	}, nil
+}
+
+// Name returns "gitlab-package"
+func (gp *GitLabPackagePublisher) Name() string {
+	return "gitlab-package"
+}
+
+// upload puts the file at path into version of package name
+func (gp *GitLabPackagePublisher) upload(path string, name string, version string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	uploadURL := gp.apiURL + "/projects/" + url.PathEscape(gp.project) + "/packages/generic/" +
+		url.PathEscape(name) + "/" + url.PathEscape(version) + "/" + url.PathEscape(filepath.Base(path))
+	req, err := http.NewRequest(http.MethodPut, uploadURL, file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("PRIVATE-TOKEN", gp.token)
+	resp, err := gp.netClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, "GitLab")
+}
+
+// Publish uploads all downloads of the report
+func (gp *GitLabPackagePublisher) Publish(report downloader.RunReport) error {
+	for _, result := range report.Downloaded {
+		name := expandPath(gp.namePattern, report, result)
+		version := expandPath(gp.versionPattern, report, result)
+		if !rePackageName.MatchString(name) {
+			return fmt.Errorf("Invalid package name %s for %s", name, result.Destination)
+		}
+		if !rePackageVersion.MatchString(version) {
+			return fmt.Errorf("Invalid package version %s for %s", version, result.Destination)
+		}
+		if err := gp.upload(result.Destination, name, version); err != nil {
+			return fmt.Errorf("Cannot upload %s (%v)", result.Destination, err)
+		}
+		log.WithFields(log.Fields{
+			"project": gp.project,
+			"package": name,
+			"version": version,
+			"file":    filepath.Base(result.Destination),
+		}).Info("Uploaded artifact to GitLab package registry")
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package publisher
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	downloader "github.com/krombel/buildkite-artifact-downloader/downloader"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultPlayTrack is the track releases are created on
+	DefaultPlayTrack = "internal"
+
+	playAPIURL      = "https://androidpublisher.googleapis.com"
+	playScope       = "https://www.googleapis.com/auth/androidpublisher"
+	googleTokenURL  = "https://oauth2.googleapis.com/token"
+	jwtBearerGrant  = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	bundleExtension = ".aab"
+)
+
+// serviceAccountKey is the part of the JSON key of a Google service account
+// which is used
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// GooglePlayPublisher uploads the downloaded APKs and app bundles to Google
+// Play and releases them on a track (the internal testing track by
+// default) via the Android Publisher API
+type GooglePlayPublisher struct {
+	apiURL      string
+	tokenURL    string
+	clientEmail string
+	privateKey  *rsa.PrivateKey
+	// packageName is used for app bundles, APKs carry their package name
+	packageName string
+	track       string
+	netClient   *http.Client
+}
+
+// NewGooglePlayPublisher constructs a publisher which authenticates with the
+// JSON key of a service account at keyFile. The account needs release
+// permissions for the apps in the Play Console. packageName is the
+// application ID of app bundles (which are not parsed) and may be empty if
+// only APKs are published
+func NewGooglePlayPublisher(keyFile string, packageName string, track string) (*GooglePlayPublisher, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read service account key (%v)", err)
+	}
+	var key serviceAccountKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("Cannot parse service account key (%v)", err)
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil || key.ClientEmail == "" {
+		return nil, fmt.Errorf("Service account key does not contain client_email and private_key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse private key of service account (%v)", err)
+	}
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Private key of service account is no RSA key")
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = googleTokenURL
+	}
+	if track == "" {
+		track = DefaultPlayTrack
+	}
+	return &GooglePlayPublisher{
+		apiURL:      playAPIURL,
+		tokenURL:    key.TokenURI,
+		clientEmail: key.ClientEmail,
+		privateKey:  privateKey,
+		packageName: packageName,
+		track:       track,
+		netClient:   newClient(),
+	}, nil
+}
+
+// Name returns "google-play"
+func (gp *GooglePlayPublisher) Name() string {
+	return "google-play"
+}
+
+// accessToken exchanges a JWT signed with the key of the service account
+// for an access token
+func (gp *GooglePlayPublisher) accessToken() (string, error) {
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   gp.clientEmail,
+		"scope": playScope,
+		"aud":   gp.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, gp.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("Cannot sign token request (%v)", err)
+	}
+	resp, err := gp.netClient.PostForm(gp.tokenURL, url.Values{
+		"grant_type": {jwtBearerGrant},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)},
+	})
+	if err != nil {
+		return "", fmt.Errorf("Cannot reach Google token service (%v)", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, "Google token service"); err != nil {
+		return "", err
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("Cannot decode access token (%v)", err)
+	}
+	return token.AccessToken, nil
+}
+
+// call sends a request to the Android Publisher API and decodes the
+// response into result if it is not nil
+func (gp *GooglePlayPublisher) call(token string, method string, path string, contentType string, body *os.File, jsonBody interface{}, result interface{}) error {
+	var req *http.Request
+	var err error
+	switch {
+	case body != nil:
+		req, err = http.NewRequest(method, gp.apiURL+path, body)
+	case jsonBody != nil:
+		var encoded []byte
+		if encoded, err = json.Marshal(jsonBody); err == nil {
+			req, err = http.NewRequest(method, gp.apiURL+path, bytes.NewReader(encoded))
+		}
+	default:
+		req, err = http.NewRequest(method, gp.apiURL+path, nil)
+	}
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		info, err := body.Stat()
+		if err != nil {
+			return err
+		}
+		req.ContentLength = info.Size()
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := gp.netClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, "Google Play"); err != nil {
+		return err
+	}
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("Cannot decode response of Google Play (%v)", err)
+		}
+	}
+	return nil
+}
+
+// playRelease is a release of a track
+type playRelease struct {
+	VersionCodes []string `json:"versionCodes"`
+	Status       string   `json:"status"`
+}
+
+// publishPackage uploads the files of packageName within one edit and
+// releases them on the track
+func (gp *GooglePlayPublisher) publishPackage(token string, packageName string, files []string) error {
+	base := "/androidpublisher/v3/applications/" + url.PathEscape(packageName) + "/edits"
+	var edit struct {
+		ID string `json:"id"`
+	}
+	if err := gp.call(token, http.MethodPost, base, "application/json", nil, struct{}{}, &edit); err != nil {
+		return fmt.Errorf("Cannot create edit (%v)", err)
+	}
+	base += "/" + url.PathEscape(edit.ID)
+
+	var versionCodes []string
+	for _, path := range files {
+		kind, contentType := "apks", "application/vnd.android.package-archive"
+		if strings.EqualFold(filepath.Ext(path), bundleExtension) {
+			kind, contentType = "bundles", "application/octet-stream"
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		var uploaded struct {
+			VersionCode int64 `json:"versionCode"`
+		}
+		err = gp.call(token, http.MethodPost, "/upload"+base+"/"+kind+"?uploadType=media", contentType, file, nil, &uploaded)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("Cannot upload %s (%v)", path, err)
+		}
+		versionCodes = append(versionCodes, strconv.FormatInt(uploaded.VersionCode, 10))
+	}
+
+	track := map[string]interface{}{
+		"track":    gp.track,
+		"releases": []playRelease{{VersionCodes: versionCodes, Status: "completed"}},
+	}
+	if err := gp.call(token, http.MethodPut, base+"/tracks/"+url.PathEscape(gp.track), "application/json", nil, track, nil); err != nil {
+		return fmt.Errorf("Cannot update track %s (%v)", gp.track, err)
+	}
+	if err := gp.call(token, http.MethodPost, base+":commit", "", nil, nil, nil); err != nil {
+		return fmt.Errorf("Cannot commit edit (%v)", err)
+	}
+	log.WithFields(log.Fields{
+		"package":      packageName,
+		"track":        gp.track,
+		"versionCodes": versionCodes,
+	}).Info("Released on Google Play")
+	return nil
+}
+
+// Publish releases the downloaded APKs and app bundles grouped by package
+func (gp *GooglePlayPublisher) Publish(report downloader.RunReport) error {
+	var packages []string
+	files := map[string][]string{}
+	for _, result := range report.Downloaded {
+		packageName := ""
+		switch {
+		case result.APK != nil:
+			packageName = result.APK.PackageName
+		case strings.EqualFold(filepath.Ext(result.Destination), bundleExtension):
+			if gp.packageName == "" {
+				return fmt.Errorf("Package name of app bundle %s is unknown", result.Destination)
+			}
+			packageName = gp.packageName
+		default:
+			continue
+		}
+		if _, ok := files[packageName]; !ok {
+			packages = append(packages, packageName)
+		}
+		files[packageName] = append(files[packageName], result.Destination)
+	}
+	if len(packages) == 0 {
+		return nil
+	}
+	token, err := gp.accessToken()
+	if err != nil {
+		return err
+	}
+	for _, packageName := range packages {
+		if err := gp.publishPackage(token, packageName, files[packageName]); err != nil {
+			return fmt.Errorf("Cannot publish %s (%v)", packageName, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package publisher
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	downloader "github.com/krombel/buildkite-artifact-downloader/downloader"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultOCITag is the tag of the pushed artifact if no pattern is given
+	DefaultOCITag = "<buildID>"
+	// OCIArtifactType identifies the pushed manifests as builds of this tool
+	OCIArtifactType = "application/vnd.buildkite-artifact-downloader.build.v1"
+
+	ociManifestType = "application/vnd.oci.image.manifest.v1+json"
+	ociEmptyType    = "application/vnd.oci.empty.v1+json"
+	// ociLayerType is the media type ORAS uses for files by default
+	ociLayerType = "application/vnd.oci.image.layer.v1.tar"
+)
+
+// ociEmpty is the empty config of artifact manifests
+var ociEmpty = []byte("{}")
+
+// reChallengeParam matches the parameters of a WWW-Authenticate header
+var reChallengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// OCIPublisher pushes the downloads of a build as one OCI artifact (one
+// layer per file, like "oras push") into a container registry
+type OCIPublisher struct {
+	scheme     string
+	registry   string
+	repository string
+	tagPattern string
+	user       string
+	password   string
+	// authorization is the Authorization header for the registry, resolved
+	// at the start of every Publish
+	authorization string
+	netClient     *http.Client
+}
+
+// NewOCIPublisher constructs a publisher which pushes to reference
+// (registry/repository[:tag pattern], see expandPath for the placeholders
+// of the tag). insecure uses http instead of https (e.g. for local
+// registries)
+func NewOCIPublisher(reference string, user string, password string, insecure bool) (*OCIPublisher, error) {
+	slash := strings.Index(reference, "/")
+	if slash <= 0 || slash == len(reference)-1 {
+		return nil, fmt.Errorf("OCI reference has to be <registry>/<repository>[:<tag>]")
+	}
+	op := &OCIPublisher{
+		scheme:     "https",
+		registry:   reference[:slash],
+		repository: reference[slash+1:],
+		tagPattern: DefaultOCITag,
+		user:       user,
+		password:   password,
+		netClient:  newClient(),
+	}
+	if colon := strings.LastIndex(op.repository, ":"); colon >= 0 {
+		op.repository, op.tagPattern = op.repository[:colon], op.repository[colon+1:]
+	}
+	if op.repository == "" || op.tagPattern == "" {
+		return nil, fmt.Errorf("OCI reference has to be <registry>/<repository>[:<tag>]")
+	}
+	if insecure {
+		op.scheme = "http"
+	}
+	return op, nil
+}
+
+// Name returns "oci"
+func (op *OCIPublisher) Name() string {
+	return "oci"
+}
+
+// ociDescriptor describes a blob of a manifest
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociManifest is an image manifest with an artifact type (OCI 1.1)
+type ociManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType"`
+	Config        ociDescriptor     `json:"config"`
+	Layers        []ociDescriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+func (op *OCIPublisher) url(path string) string {
+	return op.scheme + "://" + op.registry + "/v2/" + op.repository + path
+}
+
+// do sends req with the authorization of the registry
+func (op *OCIPublisher) do(req *http.Request) (*http.Response, error) {
+	if op.authorization != "" {
+		req.Header.Set("Authorization", op.authorization)
+	}
+	return op.netClient.Do(req)
+}
+
+// authorize resolves the authorization for pushing. Registries announce in
+// the WWW-Authenticate header of /v2/ whether they expect basic auth or a
+// bearer token from a token service
+func (op *OCIPublisher) authorize() error {
+	op.authorization = ""
+	resp, err := op.netClient.Get(op.scheme + "://" + op.registry + "/v2/")
+	if err != nil {
+		return fmt.Errorf("Cannot reach registry %s (%v)", op.registry, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		return nil
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if strings.HasPrefix(strings.ToLower(challenge), "basic") {
+		if op.user == "" {
+			return fmt.Errorf("Registry %s requires credentials", op.registry)
+		}
+		req, _ := http.NewRequest(http.MethodGet, "", nil)
+		req.SetBasicAuth(op.user, op.password)
+		op.authorization = req.Header.Get("Authorization")
+		return nil
+	}
+	params := map[string]string{}
+	for _, match := range reChallengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer") || params["realm"] == "" {
+		return fmt.Errorf("Unsupported authentication challenge of registry %s (%s)", op.registry, challenge)
+	}
+	query := url.Values{}
+	query.Set("scope", "repository:"+op.repository+":pull,push")
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	req, err := http.NewRequest(http.MethodGet, params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if op.user != "" {
+		req.SetBasicAuth(op.user, op.password)
+	}
+	resp, err = op.netClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Cannot reach token service of registry %s (%v)", op.registry, err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, "Token service"); err != nil {
+		return err
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("Cannot decode token of registry %s (%v)", op.registry, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	op.authorization = "Bearer " + token.Token
+	return nil
+}
+
+// pushBlob uploads content with digest unless the registry already has it
+func (op *OCIPublisher) pushBlob(digest string, size int64, open func() (io.ReadCloser, error)) error {
+	head, _ := http.NewRequest(http.MethodHead, op.url("/blobs/"+digest), nil)
+	resp, err := op.do(head)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	start, _ := http.NewRequest(http.MethodPost, op.url("/blobs/uploads/"), nil)
+	resp, err = op.do(start)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if err := checkResponse(resp, "Registry"); err != nil {
+		return err
+	}
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return fmt.Errorf("Registry did not return an upload location")
+	}
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+
+	content, err := open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	put, err := http.NewRequest(http.MethodPut, location.String(), content)
+	if err != nil {
+		return err
+	}
+	put.ContentLength = size
+	put.Header.Set("Content-Type", "application/octet-stream")
+	resp, err = op.do(put)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, "Registry")
+}
+
+// fileDigest returns the sha256 digest and the size of the file at path
+func fileDigest(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// Publish pushes the downloads of the report as one artifact
+func (op *OCIPublisher) Publish(report downloader.RunReport) error {
+	if len(report.Downloaded) == 0 {
+		return nil
+	}
+	if err := op.authorize(); err != nil {
+		return err
+	}
+
+	emptyDigest := sha256.Sum256(ociEmpty)
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestType,
+		ArtifactType:  OCIArtifactType,
+		Config: ociDescriptor{
+			MediaType: ociEmptyType,
+			Digest:    "sha256:" + hex.EncodeToString(emptyDigest[:]),
+			Size:      int64(len(ociEmpty)),
+		},
+		Annotations: map[string]string{
+			"org.opencontainers.image.created": report.StartedAt.UTC().Format("2006-01-02T15:04:05Z"),
+		},
+	}
+	if report.CommitID != "" {
+		manifest.Annotations["org.opencontainers.image.revision"] = report.CommitID
+	}
+	err := op.pushBlob(manifest.Config.Digest, manifest.Config.Size, func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(ociEmpty)), nil
+	})
+	if err != nil {
+		return fmt.Errorf("Cannot push config (%v)", err)
+	}
+
+	tagSource := report.Downloaded[0]
+	for _, result := range report.Downloaded {
+		path := result.Destination
+		digest, size, err := fileDigest(path)
+		if err != nil {
+			return fmt.Errorf("Cannot hash %s (%v)", path, err)
+		}
+		err = op.pushBlob(digest, size, func() (io.ReadCloser, error) {
+			return os.Open(path)
+		})
+		if err != nil {
+			return fmt.Errorf("Cannot push %s (%v)", path, err)
+		}
+		manifest.Layers = append(manifest.Layers, ociDescriptor{
+			MediaType: ociLayerType,
+			Digest:    digest,
+			Size:      size,
+			Annotations: map[string]string{
+				"org.opencontainers.image.title": filepath.Base(path),
+			},
+		})
+		if result.APK != nil && tagSource.APK == nil {
+			tagSource = result
+		}
+	}
+
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	tag := expandPath(op.tagPattern, report, tagSource)
+	req, err := http.NewRequest(http.MethodPut, op.url("/manifests/"+tag), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ociManifestType)
+	resp, err := op.do(req)
+	if err != nil {
+		return fmt.Errorf("Cannot push manifest (%v)", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, "Registry"); err != nil {
+		return fmt.Errorf("Cannot push manifest (%v)", err)
+	}
+	log.WithFields(log.Fields{
+		"reference": op.registry + "/" + op.repository + ":" + tag,
+		"digest":    resp.Header.Get("Docker-Content-Digest"),
+		"layers":    len(manifest.Layers),
+	}).Info("Pushed artifacts to OCI registry")
+	return nil
+}
@@ -0,0 +1,44 @@
+package publisher
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	downloader "github.com/krombel/buildkite-artifact-downloader/downloader"
+)
+
+// DefaultPathPattern puts the downloads into the root of the target
+const DefaultPathPattern = "<fileName>"
+
+// expandPath replaces the placeholders of pattern for the download result
+// of report. Supported are <org>, <pipeline>, <buildID>, <commitID>
+// (shortened to 8 characters), <commitFull>, <branch>, <fileName> and for
+// APKs <apkPackage>, <apkVersionName> and <apkVersionCode> ("unknown" for
+// other files)
+func expandPath(pattern string, report downloader.RunReport, result downloader.DownloadResult) string {
+	commit := report.CommitID
+	if len(commit) > 8 {
+		commit = commit[:8]
+	}
+	apkValues := []string{"unknown", "unknown", "unknown"}
+	if result.APK != nil {
+		apkValues = []string{
+			result.APK.PackageName,
+			result.APK.VersionName,
+			strconv.FormatInt(result.APK.VersionCode, 10),
+		}
+	}
+	return strings.NewReplacer(
+		`<org>`, report.Org,
+		`<pipeline>`, report.Pipeline,
+		`<buildID>`, strconv.Itoa(report.BuildID),
+		`<commitID>`, commit,
+		`<commitFull>`, report.CommitID,
+		`<branch>`, strings.ReplaceAll(report.Branch, "/", "_"),
+		`<fileName>`, filepath.Base(result.Destination),
+		`<apkPackage>`, apkValues[0],
+		`<apkVersionName>`, apkValues[1],
+		`<apkVersionCode>`, apkValues[2],
+	).Replace(pattern)
+}
@@ -0,0 +1,38 @@
+package publisher
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	downloader "github.com/krombel/buildkite-artifact-downloader/downloader"
+)
+
+// uploadTimeout limits the requests of the publishers which upload
+// artifacts
+const uploadTimeout = 30 * time.Minute
+
+// Publisher publishes the downloads of a build to a further destination
+type Publisher interface {
+	// Name identifies the publisher in logs and reports
+	Name() string
+	// Publish publishes the downloaded artifacts of the report
+	Publish(report downloader.RunReport) error
+}
+
+func newClient() *http.Client {
+	return &http.Client{Timeout: uploadTimeout}
+}
+
+// checkResponse returns an error with the start of the body if the service
+// did not respond with a 2xx status
+func checkResponse(resp *http.Response, service string) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s responded with %s (%s)", service, resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,113 @@
+package publisher
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	downloader "github.com/krombel/buildkite-artifact-downloader/downloader"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultRawRepositoryPath is the path of the uploads in raw repositories if
+// no pattern is given
+const DefaultRawRepositoryPath = "<pipeline>/<buildID>/<fileName>"
+
+// RawRepositoryPublisher uploads the downloads with PUT requests into a raw
+// (generic) repository of JFrog Artifactory or Sonatype Nexus
+type RawRepositoryPublisher struct {
+	repoURL     string
+	pathPattern string
+	user        string
+	password    string
+	token       string
+	netClient   *http.Client
+}
+
+// NewRawRepositoryPublisher constructs a publisher which uploads to
+// repoURL (e.g. https://example.jfrog.io/artifactory/android or
+// https://nexus.example.com/repository/android). It authenticates with
+// token as bearer token if given and with user and password otherwise.
+// pathPattern is the path of each download in the repository (see
+// expandPath)
+func NewRawRepositoryPublisher(repoURL string, pathPattern string, user string, password string, token string) (*RawRepositoryPublisher, error) {
+	parsed, err := url.Parse(repoURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil, fmt.Errorf("Repository URL has to be a http(s) URL")
+	}
+	if token == "" && user == "" {
+		return nil, fmt.Errorf("Repository needs a token or a user")
+	}
+	if pathPattern == "" {
+		pathPattern = DefaultRawRepositoryPath
+	}
+	return &RawRepositoryPublisher{
+		repoURL:     strings.TrimSuffix(repoURL, "/"),
+		pathPattern: pathPattern,
+		user:        user,
+		password:    password,
+		token:       token,
+		netClient:   newClient(),
+	}, nil
+}
+
+// Name returns "raw-repository"
+func (rp *RawRepositoryPublisher) Name() string {
+	return "raw-repository"
+}
+
+// upload puts the download at remotePath
+func (rp *RawRepositoryPublisher) upload(result downloader.DownloadResult, remotePath string) error {
+	file, err := os.Open(result.Destination)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	uploadURL := rp.repoURL + "/" + (&url.URL{Path: strings.TrimPrefix(path.Clean("/"+remotePath), "/")}).EscapedPath()
+	req, err := http.NewRequest(http.MethodPut, uploadURL, file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	if rp.token != "" {
+		req.Header.Set("Authorization", "Bearer "+rp.token)
+	} else {
+		req.SetBasicAuth(rp.user, rp.password)
+	}
+	// Artifactory verifies the upload against the checksums, Nexus ignores
+	// them
+	if result.SHA1 != "" {
+		req.Header.Set("X-Checksum-Sha1", result.SHA1)
+	}
+	if result.SHA256 != "" {
+		req.Header.Set("X-Checksum-Sha256", result.SHA256)
+	}
+	resp, err := rp.netClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, "Repository")
+}
+
+// Publish uploads all downloads of the report
+func (rp *RawRepositoryPublisher) Publish(report downloader.RunReport) error {
+	for _, result := range report.Downloaded {
+		remotePath := expandPath(rp.pathPattern, report, result)
+		if err := rp.upload(result, remotePath); err != nil {
+			return fmt.Errorf("Cannot upload %s (%v)", result.Destination, err)
+		}
+		log.WithFields(log.Fields{
+			"repository": rp.repoURL,
+			"path":       remotePath,
+		}).Info("Uploaded artifact to repository")
+	}
+	return nil
+}
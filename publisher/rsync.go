@@ -0,0 +1,65 @@
+package publisher
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	downloader "github.com/krombel/buildkite-artifact-downloader/downloader"
+	log "github.com/sirupsen/logrus"
+)
+
+// RsyncPublisher synchronizes a directory (e.g. the repo directory of
+// fdroid) to a target with rsync. As publishers run after fdroid, the
+// target gets the updated index together with the new APKs
+type RsyncPublisher struct {
+	source string
+	target string
+	args   []string
+}
+
+// NewRsyncPublisher constructs a publisher which runs
+// "rsync -a <args> <source> <target>". A trailing slash of source syncs its
+// content instead of the directory itself
+func NewRsyncPublisher(source string, target string, args string) (*RsyncPublisher, error) {
+	if _, err := exec.LookPath("rsync"); err != nil {
+		return nil, fmt.Errorf("Cannot find rsync (%v)", err)
+	}
+	if source == "" {
+		return nil, fmt.Errorf("Source of rsync is missing")
+	}
+	if info, err := os.Stat(source); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("Source of rsync %s is no directory", source)
+	}
+	return &RsyncPublisher{
+		source: source,
+		target: target,
+		args:   strings.Fields(args),
+	}, nil
+}
+
+// Name returns "rsync"
+func (rp *RsyncPublisher) Name() string {
+	return "rsync"
+}
+
+// Publish runs rsync. The report is not used as the whole source gets
+// synchronized
+func (rp *RsyncPublisher) Publish(report downloader.RunReport) error {
+	args := append([]string{"-a"}, rp.args...)
+	args = append(args, rp.source, rp.target)
+	log.WithFields(log.Fields{
+		"args": args,
+	}).Info("Running rsync")
+	output, err := exec.Command("rsync", args...).CombinedOutput()
+	if len(output) > 0 {
+		log.WithFields(log.Fields{
+			"publisher": rp.Name(),
+		}).Info(strings.TrimSpace(string(output)))
+	}
+	if err != nil {
+		return fmt.Errorf("rsync to %s failed (%v)", rp.target, err)
+	}
+	return nil
+}
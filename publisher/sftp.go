@@ -0,0 +1,175 @@
+package publisher
+
+import (
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	downloader "github.com/krombel/buildkite-artifact-downloader/downloader"
+	log "github.com/sirupsen/logrus"
+)
+
+// SFTPPublisher uploads the downloads to a remote host with the sftp client
+// of OpenSSH. It runs in batch mode, so authentication has to work without
+// interaction (e.g. with a key)
+type SFTPPublisher struct {
+	// target is the destination of sftp ([user@]host[:dir], IPv6 hosts in
+	// brackets)
+	target      string
+	port        string
+	identity    string
+	knownHosts  string
+	pathPattern string
+}
+
+// NewSFTPPublisher constructs a publisher which uploads to target
+// ([user@]host[:port], [user@]host:dir or sftp://[user@]host[:port][/dir],
+// IPv6 hosts in brackets). Relative remote paths start at dir. identity is the private key to authenticate with
+// and knownHosts a known_hosts file to verify the host against (both use
+// the ssh configuration if empty). pathPattern is the remote path of each
+// download (see expandPath), relative paths start at the login directory
+func NewSFTPPublisher(target string, identity string, knownHosts string, pathPattern string) (*SFTPPublisher, error) {
+	if _, err := exec.LookPath("sftp"); err != nil {
+		return nil, fmt.Errorf("Cannot find sftp (%v)", err)
+	}
+	destination, port, err := parseSFTPTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	sp := &SFTPPublisher{
+		target:      destination,
+		port:        port,
+		identity:    identity,
+		knownHosts:  knownHosts,
+		pathPattern: pathPattern,
+	}
+	if sp.pathPattern == "" {
+		sp.pathPattern = DefaultPathPattern
+	}
+	return sp, nil
+}
+
+// parseSFTPTarget splits target into the destination of sftp and the port.
+// The host ends at the first ":" after an optional user and a bracketed IPv6
+// address, the rest is the port if it is numeric and the remote directory
+// otherwise (which may contain ":" as well)
+func parseSFTPTarget(target string) (destination string, port string, err error) {
+	var user, host, dir string
+	hasUser := false
+	if strings.HasPrefix(target, "sftp://") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return "", "", fmt.Errorf("Invalid SFTP target %s (%v)", target, err)
+		}
+		if u.User != nil {
+			user, hasUser = u.User.Username(), true
+		}
+		host, port, dir = u.Hostname(), u.Port(), u.Path
+	} else {
+		rest := target
+		if i := strings.Index(rest, "@"); i >= 0 && !strings.ContainsAny(rest[:i], ":[/") {
+			user, rest, hasUser = rest[:i], rest[i+1:], true
+		}
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 || (end+1 < len(rest) && rest[end+1] != ':') {
+				return "", "", fmt.Errorf("SFTP target %s has an invalid IPv6 host", target)
+			}
+			host, rest = rest[1:end], rest[end+1:]
+		} else if i := strings.Index(rest, ":"); i >= 0 {
+			host, rest = rest[:i], rest[i:]
+		} else {
+			host, rest = rest, ""
+		}
+		rest = strings.TrimPrefix(rest, ":")
+		if _, err := strconv.Atoi(rest); err == nil {
+			port = rest
+		} else {
+			dir = rest
+		}
+	}
+	if host == "" || hasUser && user == "" {
+		return "", "", fmt.Errorf("SFTP target has to be [user@]host[:port], [user@]host:dir or sftp://[user@]host[:port][/dir]")
+	}
+	if port != "" {
+		if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+			return "", "", fmt.Errorf("SFTP target %s has an invalid port", target)
+		}
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	destination = host
+	if user != "" {
+		destination = user + "@" + host
+	}
+	if dir != "" {
+		destination += ":" + dir
+	}
+	return destination, port, nil
+}
+
+// Name returns "sftp"
+func (sp *SFTPPublisher) Name() string {
+	return "sftp"
+}
+
+// quoteSFTP quotes a path for a sftp batch file
+func quoteSFTP(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// batch returns the sftp commands which create the missing directories and
+// upload the downloads
+func (sp *SFTPPublisher) batch(report downloader.RunReport) string {
+	var commands []string
+	created := map[string]bool{}
+	for _, result := range report.Downloaded {
+		remote := expandPath(sp.pathPattern, report, result)
+		var parents []string
+		for dir := path.Dir(remote); dir != "." && dir != "/" && !created[dir]; dir = path.Dir(dir) {
+			created[dir] = true
+			parents = append([]string{dir}, parents...)
+		}
+		for _, dir := range parents {
+			// "-" ignores the failure of existing directories
+			commands = append(commands, "-mkdir "+quoteSFTP(dir))
+		}
+		commands = append(commands, "put "+quoteSFTP(result.Destination)+" "+quoteSFTP(remote))
+	}
+	return strings.Join(commands, "\n") + "\n"
+}
+
+// Publish uploads all downloads of the report in one sftp session
+func (sp *SFTPPublisher) Publish(report downloader.RunReport) error {
+	if len(report.Downloaded) == 0 {
+		return nil
+	}
+	args := []string{"-b", "-", "-o", "BatchMode=yes"}
+	if sp.port != "" {
+		args = append(args, "-P", sp.port)
+	}
+	if sp.identity != "" {
+		args = append(args, "-i", sp.identity)
+	}
+	if sp.knownHosts != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+sp.knownHosts, "-o", "StrictHostKeyChecking=yes")
+	}
+	cmd := exec.Command("sftp", append(args, sp.target)...)
+	cmd.Stdin = strings.NewReader(sp.batch(report))
+	output, err := cmd.CombinedOutput()
+	log.WithFields(log.Fields{
+		"target": sp.target,
+	}).Debug(strings.TrimSpace(string(output)))
+	if err != nil {
+		return fmt.Errorf("Upload to %s failed (%v): %s", sp.target, err, strings.TrimSpace(string(output)))
+	}
+	log.WithFields(log.Fields{
+		"target":    sp.target,
+		"artifacts": len(report.Downloaded),
+	}).Info("Uploaded artifacts via SFTP")
+	return nil
+}
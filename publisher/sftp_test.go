@@ -0,0 +1,49 @@
+package publisher
+
+import "testing"
+
+func TestParseSFTPTarget(t *testing.T) {
+	tests := []struct {
+		target      string
+		destination string
+		port        string
+	}{
+		{"example.org", "example.org", ""},
+		{"deploy@example.org", "deploy@example.org", ""},
+		{"deploy@example.org:2222", "deploy@example.org", "2222"},
+		{"deploy@example.org:/srv/fdroid", "deploy@example.org:/srv/fdroid", ""},
+		{"example.org:uploads", "example.org:uploads", ""},
+		// remote paths may contain ":" and "@"
+		{"deploy@example.org:/srv/a:b", "deploy@example.org:/srv/a:b", ""},
+		{"example.org:/srv/user@host", "example.org:/srv/user@host", ""},
+		{"[::1]", "[::1]", ""},
+		{"[::1]:2222", "[::1]", "2222"},
+		{"deploy@[::1]:/srv/fdroid", "deploy@[::1]:/srv/fdroid", ""},
+		{"deploy@[2001:db8::1]:22", "deploy@[2001:db8::1]", "22"},
+		{"sftp://example.org", "example.org", ""},
+		{"sftp://deploy@example.org:2222/srv/fdroid", "deploy@example.org:/srv/fdroid", "2222"},
+		{"sftp://deploy@[::1]:2222/srv/a:b", "deploy@[::1]:/srv/a:b", "2222"},
+		{"", "", ""},
+		{"@example.org", "", ""},
+		{"deploy@", "", ""},
+		{":2222", "", ""},
+		{"[::1", "", ""},
+		{"[::1]2222", "", ""},
+		{"example.org:0", "", ""},
+		{"example.org:70000", "", ""},
+		{"sftp://deploy@example.org:port/srv", "", ""},
+		{"sftp:///srv", "", ""},
+	}
+	for _, test := range tests {
+		destination, port, err := parseSFTPTarget(test.target)
+		if test.destination == "" {
+			if err == nil {
+				t.Errorf("%q: got %q and port %q, want an error", test.target, destination, port)
+			}
+			continue
+		}
+		if err != nil || destination != test.destination || port != test.port {
+			t.Errorf("%q: got %q and port %q (%v), want %q and port %q", test.target, destination, port, err, test.destination, test.port)
+		}
+	}
+}